@@ -105,6 +105,24 @@ type Options struct {
 	// If `MinimizeStackMemory` is set, the call stack will be automatically grown or shrank up to a limit of
 	// `CallStackSize` in order to minimize memory usage. This does incur a slight performance penalty.
 	MinimizeStackMemory bool
+	// If `Sandbox` is set, only the libraries and functions allowed by the profile are opened
+	// instead of all libraries.
+	Sandbox *SandboxProfile
+	// The file system used by the io and os libraries, dofile, loadfile and require. This defaults to `lua.OSFS`.
+	FS FileSystem
+	// If `ProtoCache` is set, Load, LoadFile, LoadString and require share the compiled chunks of the cache.
+	ProtoCache *ProtoCache
+	// If `UnicodePatterns` is set, the pattern matching functions of the string library match UTF-8 encoded
+	// characters instead of bytes and the classes %a, %l, %u and %w include all Unicode letters and digits.
+	UnicodePatterns bool
+	// The maximum number of steps of a single pattern match of the string library before it raises a
+	// "pattern too complex" error. This defaults to `lua.PatternStepLimit`, a negative value disables the limit.
+	PatternStepLimit int
+	// The seed of the random number generator of math.random. Each Global has its own generator, which is
+	// seeded randomly if `RandomSeed` is nil.
+	RandomSeed *int64
+	// The algorithm of the random number generator of math.random. This defaults to `lua.RandomXoshiro`.
+	RandomAlgorithm RandomAlgorithm
 }
 
 /* }}} */
@@ -541,7 +559,7 @@ func newGlobal() *Global {
 		Registry:   newLTable(0, 32),
 		Global:     newLTable(0, 64),
 		builtinMts: make(map[int]LValue),
-		tempFiles:  make([]*os.File, 0, 10),
+		tempFiles:  make([]File, 0, 10),
 	}
 }
 
@@ -763,6 +781,18 @@ func (ls *LState) frameFuncName(fr *callFrame) (string, bool) {
 	return "(anonymous)", false
 }
 
+// updateMainLoop selects the VM loop matching the current context and hook configuration.
+func (ls *LState) updateMainLoop() {
+	switch {
+	case len(ls.G.hooks) > 0:
+		ls.mainLoop = mainLoopWithHooks
+	case ls.ctx != nil:
+		ls.mainLoop = mainLoopWithContext
+	default:
+		ls.mainLoop = mainLoop
+	}
+}
+
 func (ls *LState) isStarted() bool {
 	return ls.currentFrame != nil
 }
@@ -1193,8 +1223,9 @@ func NewState(opts ...Options) *LState {
 	var ls *LState
 	if len(opts) == 0 {
 		ls = newLState(Options{
-			CallStackSize: CallStackSize,
-			RegistrySize:  RegistrySize,
+			CallStackSize:    CallStackSize,
+			RegistrySize:     RegistrySize,
+			PatternStepLimit: PatternStepLimit,
 		})
 		ls.OpenLibs()
 	} else {
@@ -1204,6 +1235,9 @@ func NewState(opts ...Options) *LState {
 		if opts[0].RegistrySize < 128 {
 			opts[0].RegistrySize = RegistrySize
 		}
+		if opts[0].PatternStepLimit == 0 {
+			opts[0].PatternStepLimit = PatternStepLimit
+		}
 		if opts[0].RegistryMaxSize < opts[0].RegistrySize {
 			opts[0].RegistryMaxSize = 0 // disable growth if max size is smaller than initial size
 		} else {
@@ -1213,7 +1247,11 @@ func NewState(opts ...Options) *LState {
 			}
 		}
 		ls = newLState(opts[0])
-		if !opts[0].SkipOpenLibs {
+		switch {
+		case opts[0].SkipOpenLibs:
+		case opts[0].Sandbox != nil:
+			ls.OpenSandbox(opts[0].Sandbox)
+		default:
 			ls.OpenLibs()
 		}
 	}
@@ -1229,8 +1267,12 @@ func (ls *LState) Close() {
 	for _, file := range ls.G.tempFiles {
 		// ignore errors in these operations
 		file.Close()
-		os.Remove(file.Name())
+		ls.fileSystem().Remove(file.Name())
+	}
+	if ls.G.profiler != nil {
+		ls.G.profiler.stop()
 	}
+	ls.StopCoverage()
 	ls.stack.FreeAll()
 	ls.stack = nil
 }
@@ -1403,10 +1445,10 @@ func (ls *LState) NewThread() (*LState, context.CancelFunc) {
 	thread.Env = ls.Env
 	var f context.CancelFunc = nil
 	if ls.ctx != nil {
-		thread.mainLoop = mainLoopWithContext
 		thread.ctx, f = context.WithCancel(ls.ctx)
 		thread.ctxCancelFn = f
 	}
+	thread.updateMainLoop()
 	return thread, f
 }
 
@@ -1797,6 +1839,17 @@ func (ls *LState) Register(name string, fn LGFunction) {
 /* load and function call operations {{{ */
 
 func (ls *LState) Load(reader io.Reader, name string) (*LFunction, error) {
+	if cache := ls.Options.ProtoCache; cache != nil {
+		source, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, newApiErrorE(ApiErrorFile, err)
+		}
+		proto, err := cache.Compile(name, source)
+		if err != nil {
+			return nil, newApiErrorE(ApiErrorSyntax, err)
+		}
+		return newLFunctionL(proto, ls.currentEnv(), 0), nil
+	}
 	chunk, err := parse.Parse(reader, name)
 	if err != nil {
 		return nil, newApiErrorE(ApiErrorSyntax, err)
@@ -1916,6 +1969,9 @@ func (ls *LState) SetMetatable(obj LValue, mt LValue) {
 
 	switch v := obj.(type) {
 	case *LTable:
+		if v.journal != nil {
+			v.journal.saveMetatable(v)
+		}
 		v.Metatable = mt
 	case *LUserData:
 		v.Metatable = mt
@@ -2045,8 +2101,8 @@ func (ls *LState) SetMx(mx int) {
 
 // SetContext set a context ctx to this LState. The provided ctx must be non-nil.
 func (ls *LState) SetContext(ctx context.Context) {
-	ls.mainLoop = mainLoopWithContext
 	ls.ctx = ctx
+	ls.updateMainLoop()
 }
 
 // Context returns the LState's context. To change the context, use WithContext.
@@ -2057,8 +2113,8 @@ func (ls *LState) Context() context.Context {
 // RemoveContext removes the context associated with this LState and returns this context.
 func (ls *LState) RemoveContext() context.Context {
 	oldctx := ls.ctx
-	ls.mainLoop = mainLoop
 	ls.ctx = nil
+	ls.updateMainLoop()
 	return oldctx
 }
 
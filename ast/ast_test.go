@@ -584,3 +584,20 @@ func TestNestedLogicalOp(t *testing.T) {
 
 	fmt.Println(PrintRule(r))
 }
+
+func TestCoverableLines(t *testing.T) {
+	stmts := []Stmt{
+		&LocalAssignStmt{Names: []string{"f"}, Exprs: []Expr{&FunctionExpr{Stmts: []Stmt{&ReturnStmt{}}}}},
+		&IfStmt{Condition: &TrueExpr{}, Then: []Stmt{&BreakStmt{}}},
+	}
+	for i, line := range []int{1, 4} {
+		stmts[i].SetLine(line)
+	}
+	stmts[0].(*LocalAssignStmt).Exprs[0].(*FunctionExpr).Stmts[0].SetLine(2)
+	stmts[1].(*IfStmt).Then[0].SetLine(5)
+
+	lines := CoverableLines(stmts)
+	if fmt.Sprint(lines) != "[1 2 4 5]" {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+}
@@ -0,0 +1,125 @@
+package ast
+
+import (
+	"sort"
+)
+
+// Inspect traverses the statements and expressions in depth-first order. It calls f
+// for every node; if f returns false, the children of the node are not visited.
+func Inspect(stmts []Stmt, f func(node PositionHolder) bool) {
+	for _, stmt := range stmts {
+		inspectStmt(stmt, f)
+	}
+}
+
+func inspectStmt(stmt Stmt, f func(node PositionHolder) bool) {
+	if stmt == nil || !f(stmt) {
+		return
+	}
+	switch s := stmt.(type) {
+	case *AssignStmt:
+		inspectExprs(s.Lhs, f)
+		inspectExprs(s.Rhs, f)
+	case *LocalAssignStmt:
+		inspectExprs(s.Exprs, f)
+	case *FuncCallStmt:
+		inspectExpr(s.Expr, f)
+	case *DoBlockStmt:
+		Inspect(s.Stmts, f)
+	case *WhileStmt:
+		inspectExpr(s.Condition, f)
+		Inspect(s.Stmts, f)
+	case *RepeatStmt:
+		Inspect(s.Stmts, f)
+		inspectExpr(s.Condition, f)
+	case *IfStmt:
+		inspectExpr(s.Condition, f)
+		Inspect(s.Then, f)
+		Inspect(s.Else, f)
+	case *NumberForStmt:
+		inspectExpr(s.Init, f)
+		inspectExpr(s.Limit, f)
+		inspectExpr(s.Step, f)
+		Inspect(s.Stmts, f)
+	case *GenericForStmt:
+		inspectExprs(s.Exprs, f)
+		Inspect(s.Stmts, f)
+	case *FuncDefStmt:
+		if s.Name != nil {
+			inspectExpr(s.Name.Func, f)
+			inspectExpr(s.Name.Receiver, f)
+		}
+		if s.Func != nil {
+			inspectExpr(s.Func, f)
+		}
+	case *ReturnStmt:
+		inspectExprs(s.Exprs, f)
+	}
+}
+
+func inspectExprs(exprs []Expr, f func(node PositionHolder) bool) {
+	for _, expr := range exprs {
+		inspectExpr(expr, f)
+	}
+}
+
+func inspectExpr(expr Expr, f func(node PositionHolder) bool) {
+	if expr == nil || !f(expr) {
+		return
+	}
+	switch e := expr.(type) {
+	case *AttrGetExpr:
+		inspectExpr(e.Object, f)
+		inspectExpr(e.Key, f)
+	case *TableExpr:
+		for _, field := range e.Fields {
+			inspectExpr(field.Key, f)
+			inspectExpr(field.Value, f)
+		}
+	case *FuncCallExpr:
+		inspectExpr(e.Func, f)
+		inspectExpr(e.Receiver, f)
+		inspectExprs(e.Args, f)
+	case *LogicalOpExpr:
+		inspectExpr(e.Lhs, f)
+		inspectExpr(e.Rhs, f)
+	case *RelationalOpExpr:
+		inspectExpr(e.Lhs, f)
+		inspectExpr(e.Rhs, f)
+	case *StringConcatOpExpr:
+		inspectExpr(e.Lhs, f)
+		inspectExpr(e.Rhs, f)
+	case *ArithmeticOpExpr:
+		inspectExpr(e.Lhs, f)
+		inspectExpr(e.Rhs, f)
+	case *BitwiseOpExpr:
+		inspectExpr(e.Lhs, f)
+		inspectExpr(e.Rhs, f)
+	case *UnaryMinusOpExpr:
+		inspectExpr(e.Expr, f)
+	case *UnaryNotOpExpr:
+		inspectExpr(e.Expr, f)
+	case *UnaryLenOpExpr:
+		inspectExpr(e.Expr, f)
+	case *FunctionExpr:
+		Inspect(e.Stmts, f)
+	}
+}
+
+// CoverableLines returns the sorted source lines of all statements including
+// statements in nested function bodies. Nodes without position information are ignored.
+func CoverableLines(stmts []Stmt) []int {
+	seen := map[int]bool{}
+	Inspect(stmts, func(node PositionHolder) bool {
+		if _, ok := node.(Stmt); ok && node.Line() > 0 {
+			seen[node.Line()] = true
+		}
+		return true
+	})
+	lines := make([]int, 0, len(seen))
+	for line := range seen {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
@@ -149,6 +149,9 @@ func ipairsaux(L *LState) int {
 }
 
 func baseIpairs(L *LState) int {
+	if ret := callPairsMeta(L, "__ipairs"); ret > 0 {
+		return ret
+	}
 	tb := L.CheckTable(1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
@@ -198,19 +201,19 @@ func baseLoad(L *LState) int {
 func baseLoadFile(L *LState) int {
 	var reader io.Reader
 	var chunkname string
-	var err error
 	if L.GetTop() < 1 {
 		reader = os.Stdin
 		chunkname = "<stdin>"
 	} else {
 		chunkname = L.CheckString(1)
-		reader, err = os.Open(chunkname)
+		file, err := L.fileSystem().Open(chunkname)
 		if err != nil {
 			L.Push(LNil)
 			L.Push(LString(fmt.Sprintf("can not open file: %v", chunkname)))
 			return 2
 		}
-		defer reader.(*os.File).Close()
+		defer file.Close()
+		reader = file
 	}
 	return loadaux(L, reader, chunkname)
 }
@@ -249,7 +252,22 @@ func pairsaux(L *LState) int {
 	}
 }
 
+// callPairsMeta calls the __pairs or __ipairs metamethod of the first argument if it has one.
+func callPairsMeta(L *LState, event string) int {
+	obj := L.CheckAny(1)
+	if fn := L.GetMetaField(obj, event); fn.Type() == LTFunction {
+		L.Push(fn)
+		L.Push(obj)
+		L.Call(1, 3)
+		return 3
+	}
+	return 0
+}
+
 func basePairs(L *LState) int {
+	if ret := callPairsMeta(L, "__pairs"); ret > 0 {
+		return ret
+	}
 	tb := L.CheckTable(1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"github.com/chzyer/readline"
 	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/luatest"
 	"github.com/yuin/gopher-lua/parse"
+	"io"
 	"os"
 	"runtime/pprof"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -15,12 +19,20 @@ func main() {
 }
 
 func mainAux() int {
-	var opt_e, opt_l, opt_p string
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		return testMain(os.Args[2:])
+	}
+	var opt_e, opt_l, opt_p, opt_lp, opt_lf, opt_lm, opt_hd, opt_s string
 	var opt_i, opt_v, opt_dt, opt_dc bool
 	var opt_m int
 	flag.StringVar(&opt_e, "e", "", "")
 	flag.StringVar(&opt_l, "l", "", "")
 	flag.StringVar(&opt_p, "p", "", "")
+	flag.StringVar(&opt_lp, "lp", "", "")
+	flag.StringVar(&opt_lf, "lf", "", "")
+	flag.StringVar(&opt_lm, "lm", "sample", "")
+	flag.StringVar(&opt_hd, "heapdump", "", "")
+	flag.StringVar(&opt_s, "sandbox", "", "")
 	flag.IntVar(&opt_m, "mx", 0, "")
 	flag.BoolVar(&opt_i, "i", false, "")
 	flag.BoolVar(&opt_v, "v", false, "")
@@ -28,14 +40,20 @@ func mainAux() int {
 	flag.BoolVar(&opt_dc, "dc", false, "")
 	flag.Usage = func() {
 		fmt.Println(`Usage: glua [options] [script [args]].
+       glua test [options] [files or directories].
 Available options are:
   -e stat  execute string 'stat'
   -l name  require library 'name'
   -mx MB   memory limit(default: unlimited)
+  -sandbox profile  open only the libraries allowed by the profile: pure, rule or trusted
   -dt      dump AST trees
   -dc      dump VM codes
   -i       enter interactive mode after executing 'script'
   -p file  write cpu profiles to the file
+  -lp file write Lua profiles in pprof format to the file
+  -lf file write Lua profiles in folded stack format to the file
+  -lm mode Lua profiler mode: sample(default) or count
+  -heapdump file  write a Lua heap snapshot in JSON format to the file
   -v       show version information`)
 	}
 	flag.Parse()
@@ -54,12 +72,43 @@ Available options are:
 
 	status := 0
 
-	L := lua.NewState()
+	options := lua.Options{}
+	if len(opt_s) != 0 {
+		profile, ok := lua.SandboxProfiles[opt_s]
+		if !ok {
+			fmt.Println("unknown sandbox profile: " + opt_s)
+			return 1
+		}
+		options.Sandbox = profile
+	}
+	L := lua.NewState(options)
 	defer L.Close()
 	if opt_m > 0 {
 		L.SetMx(opt_m)
 	}
 
+	if len(opt_lp) != 0 || len(opt_lf) != 0 {
+		mode := lua.ProfileSampling
+		switch opt_lm {
+		case "sample":
+		case "count":
+			mode = lua.ProfileInstrumenting
+		default:
+			fmt.Println("unknown Lua profiler mode: " + opt_lm)
+			return 1
+		}
+		L.StartProfile(lua.ProfileOptions{Mode: mode})
+		defer func() {
+			prof := L.StopProfile()
+			if len(opt_lp) != 0 {
+				writeFile(opt_lp, prof.WritePprof)
+			}
+			if len(opt_lf) != 0 {
+				writeFile(opt_lf, prof.WriteFolded)
+			}
+		}()
+	}
+
 	if opt_v || opt_i {
 		fmt.Println(lua.PackageCopyRight)
 	}
@@ -116,9 +165,84 @@ Available options are:
 	if opt_i {
 		doREPL(L)
 	}
+
+	if len(opt_hd) != 0 {
+		writeFile(opt_hd, L.HeapSnapshot().WriteJSON)
+	}
 	return status
 }
 
+func writeFile(path string, write func(io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+// run *_test.lua files
+func testMain(args []string) int {
+	var opt_f, opt_o, opt_mock string
+	var opt_t time.Duration
+	var opt_u bool
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	flags.StringVar(&opt_f, "format", "tap", "")
+	flags.StringVar(&opt_o, "o", "", "")
+	flags.StringVar(&opt_mock, "mock", "", "")
+	flags.DurationVar(&opt_t, "timeout", 0, "")
+	flags.BoolVar(&opt_u, "update", false, "")
+	flags.Usage = func() {
+		fmt.Println(`Usage: glua test [options] [files or directories].
+Runs the tests in all *_test.lua files, the current directory is used by default.
+Available options are:
+  -format fmt   report format: tap(default) or junit
+  -o file       write the report to the file instead of stdout
+  -mock names   comma separated host functions to mock, e.g. alert,http.post
+  -timeout d    time limit for a single test, e.g. 5s
+  -update       overwrite golden files of testing.snapshot`)
+	}
+	flags.Parse(args)
+
+	luatest.UpdateSnapshots = opt_u
+	runner := &luatest.Runner{Timeout: opt_t}
+	if len(opt_mock) != 0 {
+		runner.Mocks = strings.Split(opt_mock, ",")
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	report, err := runner.Run(paths...)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+
+	var write func(io.Writer) error
+	switch opt_f {
+	case "tap":
+		write = report.WriteTAP
+	case "junit":
+		write = report.WriteJUnit
+	default:
+		fmt.Println("unknown report format: " + opt_f)
+		return 1
+	}
+	if len(opt_o) != 0 {
+		writeFile(opt_o, write)
+	} else if err := write(os.Stdout); err != nil {
+		fmt.Println(err.Error())
+	}
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
+
 // do read/eval/print/loop
 func doREPL(L *lua.LState) {
 	rl, err := readline.New("> ")
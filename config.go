@@ -2,6 +2,7 @@ package lua
 
 import (
 	"os"
+	"time"
 )
 
 var CompatVarArg = true
@@ -11,6 +12,9 @@ var RegistryGrowStep = 32
 var CallStackSize = 256
 var MaxTableGetLoop = 100
 var MaxArrayIndex = 67108864
+var ProfileSampleInterval = 10 * time.Millisecond
+var PatternStepLimit = 50000000
+var PatternCacheSize = 512
 
 type LNumber float64
 
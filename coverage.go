@@ -0,0 +1,424 @@
+package lua
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yuin/gopher-lua/ast"
+)
+
+/* Coverage {{{ */
+
+// BranchCoverage holds the outcomes of a conditional instruction
+// (==, <, <=, and the truth tests of `if`, `while`, `and`, `or` ...).
+type BranchCoverage struct {
+	Line int
+	// Taken is the number of times the jump following the test was executed.
+	Taken int64
+	// NotTaken is the number of times the jump following the test was skipped.
+	NotTaken int64
+
+	lineDefined int
+	pc          int
+}
+
+// FileCoverage is the coverage of a single chunk.
+type FileCoverage struct {
+	Source string
+	// Lines maps coverable lines to their hit counts.
+	Lines map[int]int64
+	// Branches are sorted by line.
+	Branches []*BranchCoverage
+}
+
+type branchKey struct {
+	line        int
+	lineDefined int
+	pc          int
+}
+
+type fileCoverage struct {
+	lines    map[int]int64
+	branches map[branchKey]*BranchCoverage
+}
+
+// Coverage accumulates line and branch coverage of Lua code. A Coverage may be
+// shared by many LStates at the same time; the results of all states are merged.
+type Coverage struct {
+	mu    sync.Mutex
+	files map[string]*fileCoverage
+}
+
+// NewCoverage returns a new empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{files: make(map[string]*fileCoverage)}
+}
+
+func (cv *Coverage) file(source string) *fileCoverage {
+	fc, ok := cv.files[source]
+	if !ok {
+		fc = &fileCoverage{lines: make(map[int]int64), branches: make(map[branchKey]*BranchCoverage)}
+		cv.files[source] = fc
+	}
+	return fc
+}
+
+// AddLines marks the given lines of the source as coverable, so they are reported even if
+// the code is never executed.
+func (cv *Coverage) AddLines(source string, lines []int) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	fc := cv.file(source)
+	for _, line := range lines {
+		if _, ok := fc.lines[line]; !ok {
+			fc.lines[line] = 0
+		}
+	}
+}
+
+// AddChunk marks the statement lines of the chunk as coverable.
+func (cv *Coverage) AddChunk(source string, chunk []ast.Stmt) {
+	cv.AddLines(source, ast.CoverableLines(chunk))
+}
+
+// Files returns a snapshot of the collected coverage sorted by source name.
+func (cv *Coverage) Files() []*FileCoverage {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	files := make([]*FileCoverage, 0, len(cv.files))
+	for source, fc := range cv.files {
+		file := &FileCoverage{
+			Source:   source,
+			Lines:    make(map[int]int64, len(fc.lines)),
+			Branches: make([]*BranchCoverage, 0, len(fc.branches)),
+		}
+		for line, hits := range fc.lines {
+			file.Lines[line] = hits
+		}
+		for _, br := range fc.branches {
+			cbr := *br
+			file.Branches = append(file.Branches, &cbr)
+		}
+		sort.Slice(file.Branches, func(i, j int) bool {
+			bi, bj := file.Branches[i], file.Branches[j]
+			if bi.Line != bj.Line {
+				return bi.Line < bj.Line
+			}
+			if bi.lineDefined != bj.lineDefined {
+				return bi.lineDefined < bj.lineDefined
+			}
+			return bi.pc < bj.pc
+		})
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Source < files[j].Source })
+	return files
+}
+
+func (cv *Coverage) merge(protos map[*FunctionProto]*protoCoverage) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	registered := map[*FunctionProto]bool{}
+	for proto, pc := range protos {
+		cv.register(proto, registered)
+		fc := cv.file(proto.SourceName)
+		lineHits := map[int]int64{}
+		for i, hits := range pc.hits {
+			line := proto.DbgSourcePositions[i]
+			if hits > lineHits[line] {
+				lineHits[line] = hits
+			}
+		}
+		for line, hits := range lineHits {
+			fc.lines[line] += hits
+		}
+		for i, br := range pc.branches {
+			if br[0] == 0 && br[1] == 0 {
+				continue
+			}
+			key := branchKey{proto.DbgSourcePositions[i], proto.LineDefined, i}
+			fc.branches[key].Taken += br[0]
+			fc.branches[key].NotTaken += br[1]
+		}
+	}
+}
+
+// register adds the lines and branches of the proto and its nested functions.
+func (cv *Coverage) register(proto *FunctionProto, registered map[*FunctionProto]bool) {
+	if registered[proto] {
+		return
+	}
+	registered[proto] = true
+	fc := cv.file(proto.SourceName)
+	for i, code := range proto.Code {
+		line := proto.DbgSourcePositions[i]
+		if _, ok := fc.lines[line]; !ok {
+			fc.lines[line] = 0
+		}
+		if isBranchOpCode(opGetOpCode(code)) {
+			key := branchKey{line, proto.LineDefined, i}
+			if _, ok := fc.branches[key]; !ok {
+				fc.branches[key] = &BranchCoverage{Line: line, lineDefined: proto.LineDefined, pc: i}
+			}
+		}
+	}
+	for _, nested := range proto.FunctionPrototypes {
+		cv.register(nested, registered)
+	}
+}
+
+func isBranchOpCode(op int) bool {
+	switch op {
+	case OP_EQ, OP_LT, OP_LE, OP_TEST, OP_TESTSET:
+		return true
+	}
+	return false
+}
+
+/* }}} */
+
+/* coverage collector {{{ */
+
+type protoCoverage struct {
+	hits     []int64
+	branches [][2]int64
+}
+
+// coverageHook collects coverage of a single Global without locking and
+// merges it into the shared Coverage when it is stopped.
+type coverageHook struct {
+	cov       *Coverage
+	protos    map[*FunctionProto]*protoCoverage
+	lastProto *FunctionProto
+	last      *protoCoverage
+}
+
+func (ch *coverageHook) proto(proto *FunctionProto) *protoCoverage {
+	if proto == ch.lastProto {
+		return ch.last
+	}
+	pc, ok := ch.protos[proto]
+	if !ok {
+		pc = &protoCoverage{
+			hits:     make([]int64, len(proto.Code)),
+			branches: make([][2]int64, len(proto.Code)),
+		}
+		ch.protos[proto] = pc
+	}
+	ch.lastProto = proto
+	ch.last = pc
+	return pc
+}
+
+func (ch *coverageHook) beforeInst(L *LState, cf *callFrame, pc int, inst uint32) {
+	ch.proto(cf.Fn.Proto).hits[pc]++
+}
+
+func (ch *coverageHook) afterInst(L *LState, cf *callFrame, pc int, inst uint32) {
+	if !isBranchOpCode(int(inst >> 26)) {
+		return
+	}
+	if cf.Pc == pc+1 {
+		ch.proto(cf.Fn.Proto).branches[pc][0]++
+	} else {
+		ch.proto(cf.Fn.Proto).branches[pc][1]++
+	}
+}
+
+/* }}} */
+
+/* reports {{{ */
+
+func (fc *FileCoverage) sortedLines() []int {
+	lines := make([]int, 0, len(fc.Lines))
+	for line := range fc.Lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+func (fc *FileCoverage) counts() (linesHit, linesFound, branchesHit, branchesFound int) {
+	for _, hits := range fc.Lines {
+		linesFound++
+		if hits > 0 {
+			linesHit++
+		}
+	}
+	for _, br := range fc.Branches {
+		branchesFound += 2
+		if br.Taken > 0 {
+			branchesHit++
+		}
+		if br.NotTaken > 0 {
+			branchesHit++
+		}
+	}
+	return
+}
+
+// WriteLCOV writes the coverage to w in the LCOV tracefile format.
+func (cv *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, fc := range cv.Files() {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", fc.Source)
+		for block, br := range fc.Branches {
+			if br.Taken == 0 && br.NotTaken == 0 && fc.Lines[br.Line] == 0 {
+				fmt.Fprintf(bw, "BRDA:%d,%d,0,-\nBRDA:%d,%d,1,-\n", br.Line, block, br.Line, block)
+				continue
+			}
+			fmt.Fprintf(bw, "BRDA:%d,%d,0,%d\nBRDA:%d,%d,1,%d\n", br.Line, block, br.Taken, br.Line, block, br.NotTaken)
+		}
+		linesHit, linesFound, branchesHit, branchesFound := fc.counts()
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", branchesFound, branchesHit)
+		for _, line := range fc.sortedLines() {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, fc.Lines[line])
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", linesFound, linesHit)
+	}
+	return bw.Flush()
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int64  `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaReport struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+func coberturaRate(hit, found int) string {
+	if found == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(hit)/float64(found), 'f', 4, 64)
+}
+
+// WriteCobertura writes the coverage to w as a Cobertura XML report.
+func (cv *Coverage) WriteCobertura(w io.Writer) error {
+	report := coberturaReport{
+		Version:   PackageName + " " + PackageVersion,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Sources:   []string{"."},
+	}
+	pkg := coberturaPackage{}
+	for _, fc := range cv.Files() {
+		linesHit, linesFound, branchesHit, branchesFound := fc.counts()
+		report.LinesCovered += linesHit
+		report.LinesValid += linesFound
+		report.BranchesCovered += branchesHit
+		report.BranchesValid += branchesFound
+		class := coberturaClass{
+			Name:       fc.Source,
+			Filename:   fc.Source,
+			LineRate:   coberturaRate(linesHit, linesFound),
+			BranchRate: coberturaRate(branchesHit, branchesFound),
+		}
+		branches := map[int][2]int{}
+		for _, br := range fc.Branches {
+			counts := branches[br.Line]
+			counts[1] += 2
+			if br.Taken > 0 {
+				counts[0]++
+			}
+			if br.NotTaken > 0 {
+				counts[0]++
+			}
+			branches[br.Line] = counts
+		}
+		for _, line := range fc.sortedLines() {
+			cl := coberturaLine{Number: line, Hits: fc.Lines[line]}
+			if counts, ok := branches[line]; ok {
+				cl.Branch = true
+				cl.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", counts[0]*100/counts[1], counts[0], counts[1])
+			}
+			class.Lines = append(class.Lines, cl)
+		}
+		pkg.Classes = append(pkg.Classes, class)
+	}
+	pkg.LineRate = coberturaRate(report.LinesCovered, report.LinesValid)
+	pkg.BranchRate = coberturaRate(report.BranchesCovered, report.BranchesValid)
+	report.LineRate = pkg.LineRate
+	report.BranchRate = pkg.BranchRate
+	report.Packages = []coberturaPackage{pkg}
+
+	if _, err := io.WriteString(w, xml.Header+"<!DOCTYPE coverage SYSTEM \"http://cobertura.sourceforge.net/xml/coverage-04.dtd\">\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+/* }}} */
+
+/* api methods {{{ */
+
+// StartCoverage starts collecting line and branch coverage of this LState and all threads
+// sharing its globals into cov.
+func (ls *LState) StartCoverage(cov *Coverage) error {
+	if ls.G.coverage != nil {
+		return errors.New("coverage is already being collected")
+	}
+	ch := &coverageHook{cov: cov, protos: make(map[*FunctionProto]*protoCoverage)}
+	ls.G.coverage = ch
+	ls.addHook(ch)
+	return nil
+}
+
+// StopCoverage stops collecting coverage and merges the results into the Coverage passed to StartCoverage.
+// LState.Close calls StopCoverage automatically.
+func (ls *LState) StopCoverage() {
+	ch := ls.G.coverage
+	if ch == nil {
+		return
+	}
+	ls.removeHook(ch)
+	ls.G.coverage = nil
+	ch.cov.merge(ch.protos)
+}
+
+/* }}} */
@@ -0,0 +1,75 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const coverageTestScript = `function on_logline(n)
+  if n > 10 then
+    return "big"
+  end
+  return "small"
+end
+
+function unused()
+  return 1
+end
+`
+
+func TestCoverageLinesAndBranches(t *testing.T) {
+	cov := NewCoverage()
+	for _, n := range []int{1, 2, 20} {
+		L := NewState()
+		errorIfNotNil(t, L.StartCoverage(cov))
+		errorIfNotNil(t, L.DoString(coverageTestScript))
+		errorIfNotNil(t, L.CallByParam(P{Fn: L.GetGlobal("on_logline"), NRet: 1, Protect: true}, LNumber(n)))
+		L.Close()
+	}
+	files := cov.Files()
+	errorIfNotEqual(t, 1, len(files))
+	fc := files[0]
+	errorIfNotEqual(t, "<string>", fc.Source)
+	errorIfNotEqual(t, int64(3), fc.Lines[2])
+	errorIfNotEqual(t, int64(1), fc.Lines[3])
+	errorIfNotEqual(t, int64(2), fc.Lines[5])
+	hits, ok := fc.Lines[9]
+	errorIfFalse(t, ok, "lines of functions which were never called must be coverable")
+	errorIfNotEqual(t, int64(0), hits)
+
+	errorIfNotEqual(t, 1, len(fc.Branches))
+	br := fc.Branches[0]
+	errorIfNotEqual(t, 2, br.Line)
+	errorIfNotEqual(t, int64(2), br.Taken)
+	errorIfNotEqual(t, int64(1), br.NotTaken)
+
+	var lcov bytes.Buffer
+	errorIfNotNil(t, cov.WriteLCOV(&lcov))
+	for _, expected := range []string{"SF:<string>\n", "DA:2,3\n", "DA:9,0\n", "BRDA:2,0,0,2\n", "BRDA:2,0,1,1\n", "BRF:2\nBRH:2\n", "end_of_record\n"} {
+		errorIfFalse(t, strings.Contains(lcov.String(), expected), "%q not found in %v", expected, lcov.String())
+	}
+
+	var cobertura bytes.Buffer
+	errorIfNotNil(t, cov.WriteCobertura(&cobertura))
+	var report coberturaReport
+	errorIfNotNil(t, xml.Unmarshal(cobertura.Bytes(), &report))
+	errorIfNotEqual(t, 2, report.BranchesCovered)
+	errorIfNotEqual(t, "100% (2/2)", report.Packages[0].Classes[0].Lines[1].ConditionCoverage)
+}
+
+func TestCoverageAddChunk(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader(coverageTestScript), "rule.lua")
+	errorIfNotNil(t, err)
+	cov := NewCoverage()
+	cov.AddChunk("rule.lua", chunk)
+	fc := cov.Files()[0]
+	errorIfNotEqual(t, "rule.lua", fc.Source)
+	errorIfNotEqual(t, 6, len(fc.Lines))
+	linesHit, linesFound, _, _ := fc.counts()
+	errorIfNotEqual(t, 0, linesHit)
+	errorIfNotEqual(t, 6, linesFound)
+}
@@ -0,0 +1,504 @@
+package lua
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* file systems {{{ */
+
+// File is an open file of a FileSystem. *os.File implements File.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+	// Name returns the name the file was opened with.
+	Name() string
+}
+
+// FileSystem is the file system used by io.open, io.lines, io.input, io.output,
+// io.tmpfile, os.remove, os.rename, os.tmpname, dofile, loadfile, require and
+// LState.LoadFile. It extends fs.FS with writing, removing, renaming and
+// temporary files; Open must accept the same names as OpenFile.
+type FileSystem interface {
+	fs.FS
+	// OpenFile opens a file like os.OpenFile.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	// Remove removes a file like os.Remove.
+	Remove(name string) error
+	// Rename renames a file like os.Rename.
+	Rename(oldpath, newpath string) error
+	// CreateTemp creates a new temporary file like os.CreateTemp.
+	CreateTemp(dir, pattern string) (File, error)
+}
+
+// OSFS is the default FileSystem. It passes names unchanged to the os package.
+var OSFS FileSystem = osFS{}
+
+func (ls *LState) fileSystem() FileSystem {
+	if ls.Options.FS != nil {
+		return ls.Options.FS
+	}
+	return OSFS
+}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFS) Remove(name string) error { return os.Remove(name) }
+
+func (osFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+func (osFS) CreateTemp(dir, pattern string) (File, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// cleanFSPath converts a name used by Lua code to a name valid for fs.FS:
+// "./rules/a.lua" and "/rules/a.lua" both become "rules/a.lua". Names
+// escaping the root are invalid.
+func cleanFSPath(op, name string) (string, error) {
+	slashed := path.Clean(filepath.ToSlash(name))
+	if slashed == ".." || strings.HasPrefix(slashed, "../") {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	cleaned := strings.TrimPrefix(slashed, "/")
+	if cleaned == "" {
+		cleaned = "."
+	}
+	return cleaned, nil
+}
+
+/* }}} */
+
+/* DirFS {{{ */
+
+type dirFS struct {
+	dir string
+}
+
+// NewDirFS returns a FileSystem rooted at the given directory, e.g. a scratch
+// directory of a tenant. Names are resolved relative to the directory and can
+// not escape it.
+func NewDirFS(dir string) FileSystem {
+	return &dirFS{dir: dir}
+}
+
+func (d *dirFS) path(op, name string) (string, error) {
+	cleaned, err := cleanFSPath(op, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.dir, filepath.FromSlash(cleaned)), nil
+}
+
+func (d *dirFS) Open(name string) (fs.File, error) {
+	return d.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (d *dirFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	p, err := d.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, d.pathError(err, name)
+	}
+	return &namedFile{File: file, name: name}, nil
+}
+
+func (d *dirFS) Remove(name string) error {
+	p, err := d.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return d.pathError(os.Remove(p), name)
+}
+
+func (d *dirFS) Rename(oldpath, newpath string) error {
+	op, err := d.path("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	np, err := d.path("rename", newpath)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(op, np); err != nil {
+		var lerr *os.LinkError
+		if errors.As(err, &lerr) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: lerr.Err}
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *dirFS) CreateTemp(dir, pattern string) (File, error) {
+	p, err := d.path("createtemp", dir)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(p, pattern)
+	if err != nil {
+		return nil, err
+	}
+	rel, _ := filepath.Rel(d.dir, file.Name())
+	return &namedFile{File: file, name: filepath.ToSlash(rel)}, nil
+}
+
+// pathError hides the directory of the file system in error messages.
+func (d *dirFS) pathError(err error, name string) error {
+	var perr *fs.PathError
+	if errors.As(err, &perr) {
+		return &fs.PathError{Op: perr.Op, Path: name, Err: perr.Err}
+	}
+	return err
+}
+
+// namedFile is a File whose name differs from the name of the underlying file.
+type namedFile struct {
+	File
+	name string
+}
+
+func (f *namedFile) Name() string { return f.name }
+
+/* }}} */
+
+/* ReadOnlyFS {{{ */
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+// NewReadOnlyFS returns a FileSystem which reads files from the given fs.FS,
+// e.g. an embed.FS or a fstest.MapFS, and rejects all writes.
+func NewReadOnlyFS(fsys fs.FS) FileSystem {
+	return &readOnlyFS{fsys: fsys}
+}
+
+func (r *readOnlyFS) Open(name string) (fs.File, error) {
+	return r.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (r *readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	cleaned, err := cleanFSPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.fsys.Open(cleaned)
+	if err != nil {
+		var perr *fs.PathError
+		if errors.As(err, &perr) {
+			return nil, &fs.PathError{Op: perr.Op, Path: name, Err: perr.Err}
+		}
+		return nil, err
+	}
+	return &readOnlyFile{File: file, name: name}, nil
+}
+
+func (r *readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (r *readOnlyFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrPermission}
+}
+
+func (r *readOnlyFS) CreateTemp(dir, pattern string) (File, error) {
+	return nil, &fs.PathError{Op: "createtemp", Path: path.Join(dir, pattern), Err: fs.ErrPermission}
+}
+
+type readOnlyFile struct {
+	fs.File
+	name string
+}
+
+func (f *readOnlyFile) Name() string { return f.name }
+
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	if seeker, ok := f.File.(io.Seeker); ok {
+		return seeker.Seek(offset, whence)
+	}
+	return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+}
+
+/* }}} */
+
+/* MemFS {{{ */
+
+// MemFS is an in-memory FileSystem. Directories are not supported: any
+// name is a valid file name.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+	seq   int
+}
+
+type memFileData struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS returns a MemFS containing the given files.
+func NewMemFS(files map[string][]byte) *MemFS {
+	m := &MemFS{files: make(map[string]*memFileData, len(files))}
+	now := time.Now()
+	for name, data := range files {
+		cleaned, err := cleanFSPath("open", name)
+		if err != nil {
+			continue
+		}
+		m.files[cleaned] = &memFileData{data: append([]byte(nil), data...), modTime: now}
+	}
+	return m
+}
+
+// Names returns the sorted names of all files.
+func (m *MemFS) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadFile returns the contents of a file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	cleaned, err := cleanFSPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fd, ok := m.files[cleaned]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), fd.data...), nil
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	cleaned, err := cleanFSPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fd, ok := m.files[cleaned]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		fd = &memFileData{modTime: time.Now()}
+		m.files[cleaned] = fd
+	}
+	if flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		fd.data = fd.data[:0]
+		fd.modTime = time.Now()
+	}
+	return &memFile{fs: m, fd: fd, name: name, flag: flag}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	cleaned, err := cleanFSPath("remove", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[cleaned]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, cleaned)
+	return nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	oldCleaned, err := cleanFSPath("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newCleaned, err := cleanFSPath("rename", newpath)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fd, ok := m.files[oldCleaned]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldCleaned)
+	m.files[newCleaned] = fd
+	return nil
+}
+
+func (m *MemFS) CreateTemp(dir, pattern string) (File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for {
+		m.mu.Lock()
+		m.seq++
+		name := path.Join(dir, prefix+strconv.Itoa(m.seq)+suffix)
+		m.mu.Unlock()
+		file, err := m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			return file, err
+		}
+	}
+}
+
+type memFile struct {
+	fs     *MemFS
+	fd     *memFileData
+	name   string
+	flag   int
+	pos    int64
+	closed bool
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) check(op string, write bool) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	writable := f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+	readable := f.flag&os.O_WRONLY == 0
+	if (write && !writable) || (!write && !readable) {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.pos >= int64(len(f.fd.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.fd.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.fd.data))
+	}
+	if end := f.pos + int64(len(p)); end > int64(len(f.fd.data)) {
+		if end > int64(cap(f.fd.data)) {
+			data := make([]byte, len(f.fd.data), end*2)
+			copy(data, f.fd.data)
+			f.fd.data = data
+		}
+		f.fd.data = f.fd.data[:end]
+	}
+	n := copy(f.fd.data[f.pos:], p)
+	f.pos += int64(n)
+	f.fd.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += f.pos
+	case io.SeekEnd:
+		pos += int64(len(f.fd.data))
+	}
+	if pos < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return &memFileInfo{name: path.Base(f.name), size: int64(len(f.fd.data)), modTime: f.fd.modTime}, nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return 0600 }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return false }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+/* }}} */
@@ -0,0 +1,89 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemFS(t *testing.T) {
+	mem := NewMemFS(map[string][]byte{
+		"rules/helpers.lua": []byte(`return {double = function(x) return x * 2 end}`),
+		"init.lua":          []byte(`initialized = true`),
+		"data.txt":          []byte("a\nb\n"),
+	})
+	L := NewState(Options{FS: mem})
+	L.SetGlobal("path", LString("./?.lua;./rules/?.lua"))
+	errorIfScriptFail(t, L, `
+	  package.path = path
+	  assert(require("helpers").double(2) == 4)
+	  dofile("init.lua")
+	  assert(initialized)
+	  assert(loadfile("/init.lua"))
+	  assert(loadfile("missing.lua") == nil)
+
+	  local lines = {}
+	  for line in io.lines("data.txt") do table.insert(lines, line) end
+	  assert(#lines == 2 and lines[2] == "b")
+
+	  local f = assert(io.open("out.txt", "w"))
+	  f:write("hello ", 1)
+	  f:close()
+	  f = assert(io.open("out.txt", "a+"))
+	  f:write("!")
+	  f:seek("set", 0)
+	  assert(f:read("*a") == "hello 1!")
+	  f:close()
+	  assert(os.rename("out.txt", "moved.txt"))
+	  assert(io.open("out.txt") == nil)
+	  assert(os.remove("moved.txt"))
+	  local ok, err = os.remove("moved.txt")
+	  assert(ok == nil and err:find("moved.txt"))
+
+	  local name = os.tmpname()
+	  assert(io.open(name) == nil)
+	  f = io.tmpfile()
+	  f:write("x")
+	  f:seek("set")
+	  assert(f:read("*a") == "x")
+	`)
+	errorIfNotEqual(t, 4, len(mem.Names()))
+	L.Close()
+	errorIfNotEqual(t, 3, len(mem.Names()))
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	L := NewState(Options{FS: NewReadOnlyFS(fstest.MapFS{
+		"rules/a.lua": &fstest.MapFile{Data: []byte(`return 1`)},
+	})})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(dofile("rules/a.lua") == 1 and dofile("./rules/a.lua") == 1)
+	  local f, err = io.open("rules/a.lua", "w")
+	  assert(f == nil and err:find("permission denied"), err)
+	  assert(not os.remove("rules/a.lua"))
+	  assert(not pcall(os.tmpname))
+	  assert(io.open("../a.lua") == nil)
+	`)
+}
+
+func TestDirFS(t *testing.T) {
+	dir := t.TempDir()
+	errorIfNotNil(t, os.WriteFile(filepath.Join(dir, "a.lua"), []byte(`return 2`), 0600))
+	L := NewState(Options{FS: NewDirFS(dir)})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(dofile("/a.lua") == 2)
+	  local f = assert(io.open("b.txt", "w"))
+	  f:write("b")
+	  f:close()
+	  local _, err = io.open("../a.lua")
+	  assert(err:find("invalid argument"), err)
+	  _, err = io.open("missing.txt")
+	  assert(err == "open missing.txt: no such file or directory", err)
+	`)
+	data, err := os.ReadFile(filepath.Join(dir, "b.txt"))
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "b", string(data))
+}
@@ -0,0 +1,370 @@
+package lua
+
+import (
+	"reflect"
+)
+
+/* typed function helpers {{{ */
+
+// CheckArg converts the n-th argument to T or raises an argument error.
+//
+// Pointer types which are not Lua values, such as *string or *int, are optional
+// arguments: they are nil if the argument is nil or absent. Other Go types are
+// converted as in LState.ToLua.
+func CheckArg[T any](L *LState, n int) T {
+	var v T
+	switch p := any(&v).(type) {
+	case *string:
+		*p = L.CheckString(n)
+	case *int:
+		*p = L.CheckInt(n)
+	case *int64:
+		*p = L.CheckInt64(n)
+	case *float64:
+		*p = float64(L.CheckNumber(n))
+	case *bool:
+		*p = L.CheckBool(n)
+	case *LValue:
+		*p = L.CheckAny(n)
+	case *LNumber:
+		*p = L.CheckNumber(n)
+	case *LString:
+		*p = LString(L.CheckString(n))
+	case **LTable:
+		*p = L.CheckTable(n)
+	case **LFunction:
+		*p = L.CheckFunction(n)
+	case **LUserData:
+		*p = L.CheckUserData(n)
+	case **LState:
+		*p = L.CheckThread(n)
+	default:
+		rv := reflect.ValueOf(p).Elem()
+		t := rv.Type()
+		if t.Kind() == reflect.Ptr && !t.Implements(lvalueType) && t.Elem().Kind() != reflect.Struct {
+			// optional argument
+			if L.Get(n) == LNil {
+				return v
+			}
+			elem := reflect.New(t.Elem())
+			elem.Elem().Set(checkGoValue(L, n, L.Get(n), t.Elem()))
+			rv.Set(elem)
+			return v
+		}
+		rv.Set(checkGoValue(L, n, L.Get(n), t))
+	}
+	return v
+}
+
+// PushResult pushes v converted as in LState.ToLua.
+func PushResult[T any](L *LState, v T) {
+	switch r := any(v).(type) {
+	case string:
+		L.Push(LString(r))
+	case int:
+		L.Push(LNumber(r))
+	case int64:
+		L.Push(LNumber(r))
+	case float64:
+		L.Push(LNumber(r))
+	case bool:
+		L.Push(LBool(r))
+	case LValue:
+		L.Push(r)
+	default:
+		L.Push(L.reflectToLua(reflect.ValueOf(&v).Elem()))
+	}
+}
+
+// pushError pushes nil and the error message if err is not nil.
+func pushError(L *LState, err error) int {
+	L.Push(LNil)
+	L.Push(LString(err.Error()))
+	return 2
+}
+
+// Func0 returns an LGFunction calling fn with no arguments and pushing its result.
+func Func0[R any](fn func() R) LGFunction {
+	return func(L *LState) int {
+		PushResult(L, fn())
+		return 1
+	}
+}
+
+// Func0E is like Func0, but returns nil and the error message if fn fails.
+func Func0E[R any](fn func() (R, error)) LGFunction {
+	return func(L *LState) int {
+		r, err := fn()
+		if err != nil {
+			return pushError(L, err)
+		}
+		PushResult(L, r)
+		return 1
+	}
+}
+
+// Func0R2 is like Func0 for functions with two results.
+func Func0R2[R1, R2 any](fn func() (R1, R2)) LGFunction {
+	return func(L *LState) int {
+		r1, r2 := fn()
+		PushResult(L, r1)
+		PushResult(L, r2)
+		return 2
+	}
+}
+
+// Proc0 returns an LGFunction calling fn with no arguments.
+func Proc0(fn func()) LGFunction {
+	return func(L *LState) int {
+		fn()
+		return 0
+	}
+}
+
+// Proc0E is like Proc0, but returns nil and the error message if fn fails.
+func Proc0E(fn func() error) LGFunction {
+	return func(L *LState) int {
+		if err := fn(); err != nil {
+			return pushError(L, err)
+		}
+		return 0
+	}
+}
+
+// Func1 returns an LGFunction calling fn with one argument and pushing its result.
+func Func1[A1, R any](fn func(A1) R) LGFunction {
+	return func(L *LState) int {
+		PushResult(L, fn(CheckArg[A1](L, 1)))
+		return 1
+	}
+}
+
+// Func1E is like Func1, but returns nil and the error message if fn fails.
+func Func1E[A1, R any](fn func(A1) (R, error)) LGFunction {
+	return func(L *LState) int {
+		r, err := fn(CheckArg[A1](L, 1))
+		if err != nil {
+			return pushError(L, err)
+		}
+		PushResult(L, r)
+		return 1
+	}
+}
+
+// Func1R2 is like Func1 for functions with two results.
+func Func1R2[A1, R1, R2 any](fn func(A1) (R1, R2)) LGFunction {
+	return func(L *LState) int {
+		r1, r2 := fn(CheckArg[A1](L, 1))
+		PushResult(L, r1)
+		PushResult(L, r2)
+		return 2
+	}
+}
+
+// Proc1 returns an LGFunction calling fn with one argument.
+func Proc1[A1 any](fn func(A1)) LGFunction {
+	return func(L *LState) int {
+		fn(CheckArg[A1](L, 1))
+		return 0
+	}
+}
+
+// Proc1E is like Proc1, but returns nil and the error message if fn fails.
+func Proc1E[A1 any](fn func(A1) error) LGFunction {
+	return func(L *LState) int {
+		if err := fn(CheckArg[A1](L, 1)); err != nil {
+			return pushError(L, err)
+		}
+		return 0
+	}
+}
+
+// Func2 returns an LGFunction calling fn with two arguments and pushing its result.
+func Func2[A1, A2, R any](fn func(A1, A2) R) LGFunction {
+	return func(L *LState) int {
+		PushResult(L, fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2)))
+		return 1
+	}
+}
+
+// Func2E is like Func2, but returns nil and the error message if fn fails.
+func Func2E[A1, A2, R any](fn func(A1, A2) (R, error)) LGFunction {
+	return func(L *LState) int {
+		r, err := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2))
+		if err != nil {
+			return pushError(L, err)
+		}
+		PushResult(L, r)
+		return 1
+	}
+}
+
+// Func2R2 is like Func2 for functions with two results.
+func Func2R2[A1, A2, R1, R2 any](fn func(A1, A2) (R1, R2)) LGFunction {
+	return func(L *LState) int {
+		r1, r2 := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2))
+		PushResult(L, r1)
+		PushResult(L, r2)
+		return 2
+	}
+}
+
+// Proc2 returns an LGFunction calling fn with two arguments.
+func Proc2[A1, A2 any](fn func(A1, A2)) LGFunction {
+	return func(L *LState) int {
+		fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2))
+		return 0
+	}
+}
+
+// Proc2E is like Proc2, but returns nil and the error message if fn fails.
+func Proc2E[A1, A2 any](fn func(A1, A2) error) LGFunction {
+	return func(L *LState) int {
+		if err := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2)); err != nil {
+			return pushError(L, err)
+		}
+		return 0
+	}
+}
+
+// Func3 returns an LGFunction calling fn with three arguments and pushing its result.
+func Func3[A1, A2, A3, R any](fn func(A1, A2, A3) R) LGFunction {
+	return func(L *LState) int {
+		PushResult(L, fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3)))
+		return 1
+	}
+}
+
+// Func3E is like Func3, but returns nil and the error message if fn fails.
+func Func3E[A1, A2, A3, R any](fn func(A1, A2, A3) (R, error)) LGFunction {
+	return func(L *LState) int {
+		r, err := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3))
+		if err != nil {
+			return pushError(L, err)
+		}
+		PushResult(L, r)
+		return 1
+	}
+}
+
+// Func3R2 is like Func3 for functions with two results.
+func Func3R2[A1, A2, A3, R1, R2 any](fn func(A1, A2, A3) (R1, R2)) LGFunction {
+	return func(L *LState) int {
+		r1, r2 := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3))
+		PushResult(L, r1)
+		PushResult(L, r2)
+		return 2
+	}
+}
+
+// Proc3 returns an LGFunction calling fn with three arguments.
+func Proc3[A1, A2, A3 any](fn func(A1, A2, A3)) LGFunction {
+	return func(L *LState) int {
+		fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3))
+		return 0
+	}
+}
+
+// Proc3E is like Proc3, but returns nil and the error message if fn fails.
+func Proc3E[A1, A2, A3 any](fn func(A1, A2, A3) error) LGFunction {
+	return func(L *LState) int {
+		if err := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3)); err != nil {
+			return pushError(L, err)
+		}
+		return 0
+	}
+}
+
+// Func4 returns an LGFunction calling fn with four arguments and pushing its result.
+func Func4[A1, A2, A3, A4, R any](fn func(A1, A2, A3, A4) R) LGFunction {
+	return func(L *LState) int {
+		PushResult(L, fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3), CheckArg[A4](L, 4)))
+		return 1
+	}
+}
+
+// Func4E is like Func4, but returns nil and the error message if fn fails.
+func Func4E[A1, A2, A3, A4, R any](fn func(A1, A2, A3, A4) (R, error)) LGFunction {
+	return func(L *LState) int {
+		r, err := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3), CheckArg[A4](L, 4))
+		if err != nil {
+			return pushError(L, err)
+		}
+		PushResult(L, r)
+		return 1
+	}
+}
+
+// Func4R2 is like Func4 for functions with two results.
+func Func4R2[A1, A2, A3, A4, R1, R2 any](fn func(A1, A2, A3, A4) (R1, R2)) LGFunction {
+	return func(L *LState) int {
+		r1, r2 := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3), CheckArg[A4](L, 4))
+		PushResult(L, r1)
+		PushResult(L, r2)
+		return 2
+	}
+}
+
+// Proc4 returns an LGFunction calling fn with four arguments.
+func Proc4[A1, A2, A3, A4 any](fn func(A1, A2, A3, A4)) LGFunction {
+	return func(L *LState) int {
+		fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3), CheckArg[A4](L, 4))
+		return 0
+	}
+}
+
+// Proc4E is like Proc4, but returns nil and the error message if fn fails.
+func Proc4E[A1, A2, A3, A4 any](fn func(A1, A2, A3, A4) error) LGFunction {
+	return func(L *LState) int {
+		if err := fn(CheckArg[A1](L, 1), CheckArg[A2](L, 2), CheckArg[A3](L, 3), CheckArg[A4](L, 4)); err != nil {
+			return pushError(L, err)
+		}
+		return 0
+	}
+}
+
+// checkVariadic converts the arguments from the n-th on to V.
+func checkVariadic[V any](L *LState, n int) []V {
+	if n > L.GetTop() {
+		return nil
+	}
+	values := make([]V, 0, L.GetTop()-n+1)
+	for ; n <= L.GetTop(); n++ {
+		values = append(values, CheckArg[V](L, n))
+	}
+	return values
+}
+
+// FuncV returns an LGFunction calling the variadic fn with all arguments.
+func FuncV[V, R any](fn func(...V) R) LGFunction {
+	return func(L *LState) int {
+		PushResult(L, fn(checkVariadic[V](L, 1)...))
+		return 1
+	}
+}
+
+// Func1V returns an LGFunction calling the variadic fn with one fixed argument.
+func Func1V[A1, V, R any](fn func(A1, ...V) R) LGFunction {
+	return func(L *LState) int {
+		PushResult(L, fn(CheckArg[A1](L, 1), checkVariadic[V](L, 2)...))
+		return 1
+	}
+}
+
+// ProcV returns an LGFunction calling the variadic fn with all arguments.
+func ProcV[V any](fn func(...V)) LGFunction {
+	return func(L *LState) int {
+		fn(checkVariadic[V](L, 1)...)
+		return 0
+	}
+}
+
+// Proc1V returns an LGFunction calling the variadic fn with one fixed argument.
+func Proc1V[A1, V any](fn func(A1, ...V)) LGFunction {
+	return func(L *LState) int {
+		fn(CheckArg[A1](L, 1), checkVariadic[V](L, 2)...)
+		return 0
+	}
+}
+
+/* }}} */
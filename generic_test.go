@@ -0,0 +1,66 @@
+package lua
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGenericFuncs(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.RegisterModule("gen", map[string]LGFunction{
+		"upper":  Func1(strings.ToUpper),
+		"repeat": Func2[string, int, string](strings.Repeat),
+		"atoi":   Func1E(strconv.Atoi),
+		"cut":    Func2R2(func(s, sep string) (string, string) { b, a, _ := strings.Cut(s, sep); return b, a }),
+		"greet": Func2(func(name string, greeting *string) string {
+			if greeting == nil {
+				return "hello " + name
+			}
+			return *greeting + " " + name
+		}),
+		"sum": FuncV(func(values ...float64) float64 {
+			s := 0.0
+			for _, v := range values {
+				s += v
+			}
+			return s
+		}),
+		"join": Func1V(func(sep string, parts ...string) string { return strings.Join(parts, sep) }),
+		"check": Proc1E(func(name string) error {
+			if name == "" {
+				return errors.New("empty name")
+			}
+			return nil
+		}),
+		"keys": Func1(func(tb *LTable) []string {
+			var keys []string
+			tb.ForEach(func(k, v LValue) { keys = append(keys, k.String()) })
+			return keys
+		}),
+		"size": Func1(func(m map[string]int) int { return len(m) }),
+	})
+	errorIfScriptFail(t, L, `
+	  assert(gen.upper("abc") == "ABC")
+	  assert(gen["repeat"]("ab", 2) == "abab")
+	  assert(gen.atoi("12") == 12)
+	  local v, err = gen.atoi("x")
+	  assert(v == nil and err:find("invalid syntax"))
+	  local b, a = gen.cut("k=v", "=")
+	  assert(b == "k" and a == "v")
+	  assert(gen.greet("bob") == "hello bob" and gen.greet("bob", "hi") == "hi bob")
+	  assert(gen.sum() == 0 and gen.sum(1, 2, 3.5) == 6.5)
+	  assert(gen.join("-", "a", "b") == "a-b")
+	  assert(gen.check("x") == nil)
+	  local ok, err = gen.check("")
+	  assert(ok == nil and err == "empty name")
+	  assert(gen.keys({a = 1})[1] == "a")
+	  assert(gen.size({a = 1, b = 2}) == 2)
+	`)
+	errorIfScriptNotFail(t, L, `gen.upper(nil)`, `bad argument #1 to .* \(string expected, got nil\)`)
+	errorIfScriptNotFail(t, L, `gen["repeat"]("a", "b")`, `bad argument #2 to .* \(number expected, got string\)`)
+	errorIfScriptNotFail(t, L, `gen.sum(1, {})`, `bad argument #2 to .* \(number expected, got table\)`)
+	errorIfScriptNotFail(t, L, `gen.greet("bob", {})`, `bad argument #2 to .* \(string expected, got table\)`)
+}
@@ -0,0 +1,706 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+/* Go value binding {{{ */
+
+var lstateType = reflect.TypeOf((*LState)(nil))
+var lvalueType = reflect.TypeOf((*LValue)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var lgfunctionType = reflect.TypeOf(LGFunction(nil))
+
+// ToLua converts a Go value to an LValue.
+//
+// nil, booleans, numbers and strings are converted to the corresponding Lua values and
+// LValues are returned as they are. Go funcs become functions which convert their
+// arguments and results; a non-nil error result raises a Lua error. A parameter of type
+// *LState receives the calling state. All other values are wrapped in an LUserData
+// whose metatable is generated for the type of the value:
+//
+//   - struct fields and methods are accessible through __index, fields of struct
+//     pointers can be assigned through __newindex
+//   - maps and slices support indexing (slices are 1-based), #, pairs and ipairs
+//
+// The metatables are cached per Go type in the Global.
+func (ls *LState) ToLua(v interface{}) LValue {
+	if v == nil {
+		return LNil
+	}
+	if lv, ok := v.(LValue); ok {
+		return lv
+	}
+	return ls.reflectToLua(reflect.ValueOf(v))
+}
+
+func (ls *LState) reflectToLua(rv reflect.Value) LValue {
+	if !rv.IsValid() {
+		return LNil
+	}
+	if rv.Type().Implements(lvalueType) {
+		if rv.Kind() == reflect.Interface && rv.IsNil() {
+			return LNil
+		}
+		return rv.Interface().(LValue)
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return LBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return LNumber(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return LNumber(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return LNumber(rv.Float())
+	case reflect.String:
+		return LString(rv.String())
+	case reflect.Interface:
+		if rv.IsNil() {
+			return LNil
+		}
+		return ls.reflectToLua(rv.Elem())
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan:
+		if rv.IsNil() {
+			return LNil
+		}
+	case reflect.Func:
+		if rv.IsNil() {
+			return LNil
+		}
+		if rv.Type().ConvertibleTo(lgfunctionType) {
+			return ls.NewFunction(rv.Convert(lgfunctionType).Interface().(LGFunction))
+		}
+		return ls.NewFunction(goFuncToLua(rv, false))
+	}
+	ud := ls.NewUserData()
+	ud.Value = rv.Interface()
+	ud.Metatable = ls.reflectMetatable(rv.Type())
+	return ud
+}
+
+// reflectMetatable returns the cached metatable for the Go type.
+func (ls *LState) reflectMetatable(t reflect.Type) *LTable {
+	if ls.G.reflectMts == nil {
+		ls.G.reflectMts = make(map[reflect.Type]*LTable)
+	}
+	if mt, ok := ls.G.reflectMts[t]; ok {
+		return mt
+	}
+	mt := ls.NewTable()
+	ls.G.reflectMts[t] = mt
+	info := newReflectTypeInfo(ls, t)
+	mt.RawSetString("__index", ls.NewFunction(info.index))
+	mt.RawSetString("__newindex", ls.NewFunction(info.newIndex))
+	mt.RawSetString("__tostring", ls.NewFunction(reflectToString))
+	mt.RawSetString("__eq", ls.NewFunction(reflectEq))
+	switch info.container.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String, reflect.Chan:
+		mt.RawSetString("__len", ls.NewFunction(info.len))
+	}
+	switch info.container.Kind() {
+	case reflect.Map:
+		mt.RawSetString("__pairs", ls.NewFunction(info.mapPairs))
+	case reflect.Slice, reflect.Array:
+		mt.RawSetString("__pairs", ls.NewFunction(info.ipairs))
+		mt.RawSetString("__ipairs", ls.NewFunction(info.ipairs))
+	}
+	return mt
+}
+
+// reflectTypeInfo holds the methods and fields of a bound Go type.
+type reflectTypeInfo struct {
+	typ reflect.Type
+	// container is the type the index operations apply to: the element type for pointers
+	container reflect.Type
+	methods   map[string]*LFunction
+	fields    map[string][]int
+}
+
+func newReflectTypeInfo(ls *LState, t reflect.Type) *reflectTypeInfo {
+	info := &reflectTypeInfo{
+		typ:       t,
+		container: t,
+		methods:   make(map[string]*LFunction),
+		fields:    make(map[string][]int),
+	}
+	if t.Kind() == reflect.Ptr {
+		info.container = t.Elem()
+	}
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if method.PkgPath != "" {
+			continue
+		}
+		info.methods[method.Name] = ls.NewFunction(goFuncToLua(method.Func, true))
+	}
+	if info.container.Kind() == reflect.Struct {
+		collectFields(info.container, nil, info.fields)
+	}
+	return info
+}
+
+// collectFields collects the exported fields including the fields of embedded structs.
+// A `lua:"name"` tag renames a field, `lua:"-"` hides it.
+func collectFields(t reflect.Type, index []int, fields map[string][]int) {
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			field.Index = fieldIndex
+			embedded = append(embedded, field)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name, _ := parseLuaTag(field)
+		if name == "-" {
+			continue
+		}
+		if _, ok := fields[name]; !ok {
+			fields[name] = fieldIndex
+		}
+	}
+	// fields of the outer struct shadow fields of embedded structs
+	for _, field := range embedded {
+		collectFields(field.Type, field.Index, fields)
+	}
+}
+
+// parseLuaTag returns the name and the options of the `lua` struct tag.
+func parseLuaTag(field reflect.StructField) (string, []string) {
+	tag := field.Tag.Get("lua")
+	if len(tag) == 0 {
+		return field.Name, nil
+	}
+	var opts []string
+	for i := 0; i < len(tag); i++ {
+		if tag[i] == ',' {
+			opts = splitTagOptions(tag[i+1:])
+			tag = tag[:i]
+			break
+		}
+	}
+	if len(tag) == 0 {
+		tag = field.Name
+	}
+	return tag, opts
+}
+
+func splitTagOptions(s string) []string {
+	var opts []string
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == ',' {
+			opts = append(opts, s[start:i])
+			start = i + 1
+		}
+	}
+	return opts
+}
+
+func checkReflectValue(L *LState) reflect.Value {
+	ud := L.CheckUserData(1)
+	return reflect.ValueOf(ud.Value)
+}
+
+// deref returns the value index operations apply to.
+func (info *reflectTypeInfo) deref(rv reflect.Value) reflect.Value {
+	if rv.Kind() == reflect.Ptr {
+		return rv.Elem()
+	}
+	return rv
+}
+
+// addressable returns a pointer to nested structs, slices and arrays of pointers,
+// so that scripts can modify them in place.
+func addressable(rv reflect.Value) reflect.Value {
+	switch rv.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Array:
+		if rv.CanAddr() {
+			return rv.Addr()
+		}
+	}
+	return rv
+}
+
+func (info *reflectTypeInfo) index(L *LState) int {
+	rv := checkReflectValue(L)
+	key := L.CheckAny(2)
+	if name, ok := key.(LString); ok {
+		if fn, ok := info.methods[string(name)]; ok {
+			L.Push(fn)
+			return 1
+		}
+	}
+	container := info.deref(rv)
+	switch container.Kind() {
+	case reflect.Struct:
+		if name, ok := key.(LString); ok {
+			if index, ok := info.fields[string(name)]; ok {
+				L.Push(L.reflectToLua(addressable(container.FieldByIndex(index))))
+				return 1
+			}
+		}
+	case reflect.Map:
+		mkey, err := luaToGo(L, key, container.Type().Key())
+		if err == nil {
+			L.Push(L.reflectToLua(container.MapIndex(mkey)))
+			return 1
+		}
+	case reflect.Slice, reflect.Array, reflect.String:
+		if n, ok := key.(LNumber); ok {
+			i := int(n)
+			if LNumber(i) == n && i >= 1 && i <= container.Len() {
+				L.Push(L.reflectToLua(addressable(container.Index(i - 1))))
+				return 1
+			}
+		}
+	}
+	L.Push(LNil)
+	return 1
+}
+
+func (info *reflectTypeInfo) newIndex(L *LState) int {
+	ud := L.CheckUserData(1)
+	rv := reflect.ValueOf(ud.Value)
+	key := L.CheckAny(2)
+	value := L.CheckAny(3)
+	container := info.deref(rv)
+	switch container.Kind() {
+	case reflect.Struct:
+		name, ok := key.(LString)
+		if !ok {
+			break
+		}
+		index, ok := info.fields[string(name)]
+		if !ok {
+			L.RaiseError("%v has no field %v", info.typ, string(name))
+		}
+		field := container.FieldByIndex(index)
+		if !field.CanSet() {
+			L.RaiseError("cannot set field %v of %v, a pointer is required", string(name), info.typ)
+		}
+		field.Set(checkGoValue(L, 3, value, field.Type()))
+		return 0
+	case reflect.Map:
+		mkey, err := luaToGo(L, key, container.Type().Key())
+		if err != nil {
+			L.ArgError(2, err.Error())
+		}
+		if value == LNil {
+			container.SetMapIndex(mkey, reflect.Value{})
+		} else {
+			container.SetMapIndex(mkey, checkGoValue(L, 3, value, container.Type().Elem()))
+		}
+		return 0
+	case reflect.Slice, reflect.Array:
+		n, ok := key.(LNumber)
+		i := int(n)
+		if !ok || LNumber(i) != n {
+			L.ArgError(2, "integer index expected")
+		}
+		elem := checkGoValue(L, 3, value, container.Type().Elem())
+		switch {
+		case i >= 1 && i <= container.Len():
+			if container.Index(i - 1).CanSet() {
+				container.Index(i - 1).Set(elem)
+				return 0
+			}
+		case i == container.Len()+1 && container.Kind() == reflect.Slice:
+			appended := reflect.Append(container, elem)
+			if container.CanSet() {
+				container.Set(appended)
+			} else {
+				ud.Value = appended.Interface()
+			}
+			return 0
+		default:
+			L.RaiseError("index %v out of range [1, %v]", i, container.Len()+1)
+		}
+		L.RaiseError("cannot set an element of %v, a pointer is required", info.typ)
+	}
+	L.RaiseError("cannot set %v of %v", key.String(), info.typ)
+	return 0
+}
+
+func (info *reflectTypeInfo) len(L *LState) int {
+	L.Push(LNumber(info.deref(checkReflectValue(L)).Len()))
+	return 1
+}
+
+func (info *reflectTypeInfo) mapPairs(L *LState) int {
+	container := info.deref(checkReflectValue(L))
+	keys := container.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	i := 0
+	L.Push(L.NewFunction(func(L *LState) int {
+		for i < len(keys) {
+			key := keys[i]
+			i++
+			value := container.MapIndex(key)
+			if !value.IsValid() {
+				// deleted while iterating
+				continue
+			}
+			L.Push(L.reflectToLua(key))
+			L.Push(L.reflectToLua(value))
+			return 2
+		}
+		L.Push(LNil)
+		return 1
+	}))
+	L.Push(L.Get(1))
+	L.Push(LNil)
+	return 3
+}
+
+func (info *reflectTypeInfo) ipairs(L *LState) int {
+	ud := L.CheckUserData(1)
+	L.Push(L.NewFunction(func(L *LState) int {
+		container := info.deref(reflect.ValueOf(ud.Value))
+		i := L.CheckInt(2) + 1
+		if i > container.Len() {
+			L.Push(LNil)
+			return 1
+		}
+		L.Push(LNumber(i))
+		L.Push(L.reflectToLua(addressable(container.Index(i - 1))))
+		return 2
+	}))
+	L.Push(ud)
+	L.Push(LNumber(0))
+	return 3
+}
+
+func reflectToString(L *LState) int {
+	ud := L.CheckUserData(1)
+	if s, ok := ud.Value.(fmt.Stringer); ok {
+		L.Push(LString(s.String()))
+	} else {
+		L.Push(LString(fmt.Sprintf("%T: %p", ud.Value, ud)))
+	}
+	return 1
+}
+
+func reflectEq(L *LState) int {
+	lhs, rhs := L.CheckUserData(1), L.CheckUserData(2)
+	lt, rt := reflect.TypeOf(lhs.Value), reflect.TypeOf(rhs.Value)
+	// userdata without a value are equal like nil interfaces
+	L.Push(LBool(lt == rt && (lt == nil || lt.Comparable() && reflectValuesEqual(lhs.Value, rhs.Value))))
+	return 1
+}
+
+// reflectValuesEqual compares values of a comparable type with ==, which still
+// panics if interface fields hold incomparable values. Those values are not equal.
+func reflectValuesEqual(lhs, rhs interface{}) (eq bool) {
+	defer func() {
+		if recover() != nil {
+			eq = false
+		}
+	}()
+	return lhs == rhs
+}
+
+/* }}} */
+
+/* Go function calls {{{ */
+
+// goFuncToLua returns an LGFunction calling the Go func fn. If method is true, fn is a
+// method expression whose receiver is the first argument.
+func goFuncToLua(fn reflect.Value, method bool) LGFunction {
+	t := fn.Type()
+	return func(L *LState) int {
+		numIn := t.NumIn()
+		fixed := numIn
+		if t.IsVariadic() {
+			fixed--
+		}
+		args := make([]reflect.Value, 0, numIn)
+		n := 1
+		for i := 0; i < fixed; i++ {
+			in := t.In(i)
+			if in == lstateType && !(method && i == 0) {
+				args = append(args, reflect.ValueOf(L))
+				continue
+			}
+			args = append(args, checkGoValue(L, n, L.Get(n), in))
+			n++
+		}
+		if t.IsVariadic() {
+			elemType := t.In(numIn - 1).Elem()
+			for ; n <= L.GetTop(); n++ {
+				args = append(args, checkGoValue(L, n, L.Get(n), elemType))
+			}
+		}
+		results := fn.Call(args)
+		if t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType {
+			if err := results[len(results)-1]; !err.IsNil() {
+				L.RaiseError("%s", err.Interface().(error).Error())
+			}
+			results = results[:len(results)-1]
+		}
+		for _, result := range results {
+			L.Push(L.reflectToLua(result))
+		}
+		return len(results)
+	}
+}
+
+// checkGoValue converts the n-th argument to the Go type t or raises an argument error.
+func checkGoValue(L *LState, n int, lv LValue, t reflect.Type) reflect.Value {
+	rv, err := luaToGo(L, lv, t)
+	if err != nil {
+		L.ArgError(n, err.Error())
+	}
+	return rv
+}
+
+// luaToGo converts a Lua value to a value of the Go type t.
+func luaToGo(L *LState, lv LValue, t reflect.Type) (reflect.Value, error) {
+	if t == lvalueType {
+		return reflect.ValueOf(&lv).Elem(), nil
+	}
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		v := luaToInterface(lv)
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(v).Convert(t), nil
+	}
+	if lvt := reflect.TypeOf(lv); lvt.AssignableTo(t) {
+		return reflect.ValueOf(lv), nil
+	}
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("%v expected, got %v", t, lv.Type())
+	}
+	switch v := lv.(type) {
+	case *LNilType:
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return mismatch()
+	case *LUserData:
+		value := reflect.ValueOf(v.Value)
+		if !value.IsValid() {
+			return mismatch()
+		}
+		if value.Type().AssignableTo(t) {
+			return value, nil
+		}
+		if value.Kind() == reflect.Ptr && value.Type().Elem().AssignableTo(t) && !value.IsNil() {
+			return value.Elem(), nil
+		}
+		if value.Type().ConvertibleTo(t) {
+			return value.Convert(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("%v expected, got %v", t, value.Type())
+	case *LFunction:
+		if t.Kind() == reflect.Func {
+			return luaFuncToGo(L, v, t), nil
+		}
+		return mismatch()
+	case *LTable:
+		return luaTableToGo(L, v, t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := lv.(LBool); ok {
+			return reflect.ValueOf(bool(b)).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		switch v := lv.(type) {
+		case LNumber:
+			return numberToGo(v, t)
+		case LString:
+			if num, err := parseNumber(string(v)); err == nil {
+				return numberToGo(num, t)
+			}
+		}
+	case reflect.String:
+		switch v := lv.(type) {
+		case LString:
+			return reflect.ValueOf(string(v)).Convert(t), nil
+		case LNumber:
+			return reflect.ValueOf(v.String()).Convert(t), nil
+		}
+	case reflect.Slice:
+		if s, ok := lv.(LString); ok && t.Elem().Kind() == reflect.Uint8 {
+			return reflect.ValueOf([]byte(s)).Convert(t), nil
+		}
+	}
+	return mismatch()
+}
+
+// luaToInterface converts a Lua value to a Go value without a target type.
+// numberToGo converts a number to the numeric Go type t. Integer types reject
+// fractions and numbers out of their range instead of truncating them.
+func numberToGo(n LNumber, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.New(t).Elem()
+	f := float64(n)
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f != math.Trunc(f) {
+			return reflect.Value{}, fmt.Errorf("integer expected, got %v", n)
+		}
+		if f < -(1<<63) || f >= 1<<63 || rv.OverflowInt(int64(f)) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %v", n, t)
+		}
+		rv.SetInt(int64(f))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if f != math.Trunc(f) || f < 0 {
+			return reflect.Value{}, fmt.Errorf("non-negative integer expected, got %v", n)
+		}
+		if f >= 1<<64 || rv.OverflowUint(uint64(f)) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %v", n, t)
+		}
+		rv.SetUint(uint64(f))
+	default:
+		rv.SetFloat(f)
+	}
+	return rv, nil
+}
+
+func luaToInterface(lv LValue) interface{} {
+	switch v := lv.(type) {
+	case *LNilType:
+		return nil
+	case LBool:
+		return bool(v)
+	case LNumber:
+		return float64(v)
+	case LString:
+		return string(v)
+	case *LUserData:
+		return v.Value
+	case *LTable:
+		n := v.Len()
+		if n > 0 && v.MaxN() == n {
+			count := 0
+			v.ForEach(func(LValue, LValue) { count++ })
+			if count == n {
+				slice := make([]interface{}, n)
+				for i := 1; i <= n; i++ {
+					slice[i-1] = luaToInterface(v.RawGetInt(i))
+				}
+				return slice
+			}
+		}
+		m := make(map[string]interface{})
+		v.ForEach(func(key, value LValue) {
+			m[key.String()] = luaToInterface(value)
+		})
+		return m
+	}
+	return lv
+}
+
+func luaTableToGo(L *LState, tb *LTable, t reflect.Type) (reflect.Value, error) {
+	switch t.Kind() {
+	case reflect.Slice:
+		n := tb.Len()
+		slice := reflect.MakeSlice(t, n, n)
+		for i := 1; i <= n; i++ {
+			elem, err := luaToGo(L, tb.RawGetInt(i), t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("[%v]: %v", i, err.Error())
+			}
+			slice.Index(i - 1).Set(elem)
+		}
+		return slice, nil
+	case reflect.Map:
+		m := reflect.MakeMap(t)
+		var err error
+		tb.ForEach(func(key, value LValue) {
+			if err != nil {
+				return
+			}
+			mkey, kerr := luaToGo(L, key, t.Key())
+			if kerr != nil {
+				err = kerr
+				return
+			}
+			mvalue, verr := luaToGo(L, value, t.Elem())
+			if verr != nil {
+				err = fmt.Errorf("[%v]: %v", strconv.Quote(key.String()), verr.Error())
+				return
+			}
+			m.SetMapIndex(mkey, mvalue)
+		})
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return m, nil
+	case reflect.Struct, reflect.Ptr:
+		rv := reflect.New(t).Elem()
+		if err := unmarshalValue(tb, rv, marshalRoot(t)); err != nil {
+			return reflect.Value{}, err
+		}
+		return rv, nil
+	}
+	return reflect.Value{}, fmt.Errorf("%v expected, got table", t)
+}
+
+// luaFuncToGo returns a Go func of type t calling the Lua function. If the last result of
+// t is an error, Lua errors are returned, otherwise they panic.
+func luaFuncToGo(L *LState, fn *LFunction, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		numOut := t.NumOut()
+		hasError := numOut > 0 && t.Out(numOut-1) == errorType
+		nret := numOut
+		if hasError {
+			nret--
+		}
+		results := make([]reflect.Value, numOut)
+		fail := func(err error) []reflect.Value {
+			if !hasError {
+				panic(err)
+			}
+			for i := 0; i < nret; i++ {
+				results[i] = reflect.Zero(t.Out(i))
+			}
+			results[nret] = reflect.ValueOf(&err).Elem()
+			return results
+		}
+		top := L.GetTop()
+		defer L.SetTop(top)
+		L.Push(fn)
+		for _, arg := range args {
+			if t.IsVariadic() && arg.Type() == t.In(t.NumIn()-1) {
+				for i := 0; i < arg.Len(); i++ {
+					L.Push(L.reflectToLua(arg.Index(i)))
+				}
+				continue
+			}
+			L.Push(L.reflectToLua(arg))
+		}
+		if err := L.PCall(L.GetTop()-top-1, nret, nil); err != nil {
+			return fail(err)
+		}
+		for i := 0; i < nret; i++ {
+			rv, err := luaToGo(L, L.Get(top+1+i), t.Out(i))
+			if err != nil {
+				return fail(err)
+			}
+			results[i] = rv
+		}
+		if hasError {
+			results[nret] = reflect.Zero(errorType)
+		}
+		return results
+	})
+}
+
+/* }}} */
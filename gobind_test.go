@@ -0,0 +1,151 @@
+package lua
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type bindAsset struct {
+	IP   string
+	Port int
+}
+
+type bindAlert struct {
+	Name   string
+	Score  float64 `lua:"score"`
+	Hidden string  `lua:"-"`
+	Asset  bindAsset
+	Tags   []string
+	Meta   map[string]int
+	secret string
+}
+
+func (a *bindAlert) Describe(prefix string) string {
+	return prefix + a.Name + "@" + a.Asset.IP
+}
+
+func (a bindAlert) Validate() error {
+	if a.Score < 0 {
+		return errors.New("negative score")
+	}
+	return nil
+}
+
+func TestToLuaStruct(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	alert := &bindAlert{
+		Name:  "brute",
+		Asset: bindAsset{IP: "10.0.0.1"},
+		Tags:  []string{"ssh", "login"},
+		Meta:  map[string]int{"a": 1, "b": 2},
+	}
+	L.SetGlobal("alert", L.ToLua(alert))
+	errorIfScriptFail(t, L, `
+	  assert(alert.Name == "brute")
+	  assert(alert.Hidden == nil and alert.secret == nil)
+	  assert(alert:Describe("> ") == "> brute@10.0.0.1")
+	  alert.score = 4.5
+	  alert.Asset.Port = 22
+	  assert(#alert.Tags == 2 and alert.Tags[2] == "login" and alert.Tags[3] == nil)
+	  alert.Tags[3] = "root"
+	  local tags = {}
+	  for i, tag in ipairs(alert.Tags) do tags[i] = tag end
+	  assert(table.concat(tags, ",") == "ssh,login,root")
+	  local sum = 0
+	  for k, v in pairs(alert.Meta) do sum = sum + v end
+	  assert(sum == 3 and #alert.Meta == 2)
+	  alert.Meta.c = 3
+	  alert.Meta.a = nil
+	  assert(alert:Validate() == nil)
+	`)
+	errorIfNotEqual(t, 4.5, alert.Score)
+	errorIfNotEqual(t, 22, alert.Asset.Port)
+	errorIfNotEqual(t, 3, len(alert.Tags))
+	errorIfNotEqual(t, 3, alert.Meta["c"])
+	_, ok := alert.Meta["a"]
+	errorIfFalse(t, !ok, "nil should delete the map key")
+
+	errorIfScriptNotFail(t, L, `alert.score = "x"`, "float64 expected, got string")
+	errorIfScriptNotFail(t, L, `alert.Unknown = 1`, "has no field Unknown")
+	alert.Score = -1
+	errorIfScriptNotFail(t, L, `alert:Validate()`, "negative score")
+
+	// values of structs are read only
+	L.SetGlobal("copy", L.ToLua(bindAlert{Name: "copy"}))
+	errorIfScriptFail(t, L, `assert(copy.Name == "copy")`)
+	errorIfScriptNotFail(t, L, `copy.Name = "x"`, "a pointer is required")
+
+	// metatables are cached per type
+	ud1 := L.ToLua(&bindAlert{}).(*LUserData)
+	ud2 := L.ToLua(&bindAlert{}).(*LUserData)
+	errorIfFalse(t, ud1.Metatable == ud2.Metatable, "metatables should be cached")
+
+	// struct values are compared with == unless they hold incomparable values
+	type tagged struct{ Value interface{} }
+	L.SetGlobal("a", L.ToLua(tagged{1}))
+	L.SetGlobal("b", L.ToLua(tagged{1}))
+	L.SetGlobal("c", L.ToLua(tagged{[]int{1}}))
+	L.SetGlobal("d", L.ToLua(tagged{[]int{1}}))
+	errorIfScriptFail(t, L, `assert(a == b and a ~= c and c ~= d)`)
+	empty1, empty2 := L.NewUserData(), L.NewUserData()
+	empty1.Metatable, empty2.Metatable = ud1.Metatable, ud1.Metatable
+	L.SetGlobal("empty1", empty1)
+	L.SetGlobal("empty2", empty2)
+	errorIfScriptFail(t, L, `assert(empty1 == empty2 and empty1 ~= a)`)
+}
+
+func TestToLuaFunc(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("join", L.ToLua(strings.Join))
+	L.SetGlobal("sum", L.ToLua(func(base int, values ...float64) (float64, int) {
+		s := float64(base)
+		for _, v := range values {
+			s += v
+		}
+		return s, len(values)
+	}))
+	L.SetGlobal("check", L.ToLua(func(L *LState, name string) error {
+		if name == "" {
+			return errors.New("empty name")
+		}
+		return nil
+	}))
+	L.SetGlobal("apply", L.ToLua(func(fn func(int) (int, error), v int) (int, error) {
+		return fn(v)
+	}))
+	L.SetGlobal("describe", L.ToLua(func(v interface{}) string {
+		switch v.(type) {
+		case []interface{}:
+			return "array"
+		case map[string]interface{}:
+			return "map"
+		case float64:
+			return "number"
+		}
+		return "other"
+	}))
+	errorIfScriptFail(t, L, `
+	  assert(join({"a", "b"}, "-") == "a-b")
+	  local s, n = sum(1, 2, 3.5)
+	  assert(s == 6.5 and n == 2)
+	  check("x")
+	  assert(apply(function(v) return v * 2 end, 21) == 42)
+	  assert(describe({1, 2}) == "array" and describe({a = 1}) == "map" and describe(1) == "number")
+	`)
+	errorIfScriptNotFail(t, L, `check("")`, "empty name")
+	errorIfScriptNotFail(t, L, `sum("x")`, `bad argument #1 to .*int expected, got string`)
+	errorIfScriptNotFail(t, L, `apply(function(v) error("boom") end, 1)`, "boom")
+
+	L.SetGlobal("empty", L.NewUserData())
+	errorIfScriptFail(t, L, `assert(describe(empty) == "other")`)
+	L.SetGlobal("int8", L.ToLua(func(v int8) int8 { return v }))
+	L.SetGlobal("uint", L.ToLua(func(v uint) uint { return v }))
+	errorIfScriptFail(t, L, `assert(int8(-128) == -128 and int8("127") == 127 and uint(2^53) == 2^53)`)
+	errorIfScriptNotFail(t, L, `int8(300)`, `bad argument #1 to .*300 overflows int8`)
+	errorIfScriptNotFail(t, L, `int8(1.7)`, `bad argument #1 to .*integer expected, got 1.7`)
+	errorIfScriptNotFail(t, L, `uint(-1)`, `non-negative integer expected, got -1`)
+	errorIfScriptNotFail(t, L, `uint(1e20)`, `overflows uint`)
+}
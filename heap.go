@@ -0,0 +1,413 @@
+package lua
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"unsafe"
+)
+
+/* HeapSnapshot {{{ */
+
+// HeapObject is an object reachable from the roots of an LState.
+type HeapObject struct {
+	// Path is the retaining path of the object found by a breadth first
+	// walk from the roots, for example `_G.grouper1.loglines`.
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// Size is the estimated size of the object itself in bytes, including
+	// the strings it holds directly.
+	Size int64 `json:"size"`
+	// Retained is the estimated size of the object and all objects
+	// whose retaining path passes through it.
+	Retained int64 `json:"retained"`
+	// Entries is the number of table entries, upvalues or stack slots.
+	Entries int `json:"entries"`
+}
+
+// HeapTypeStat summarises the objects of a single type.
+type HeapTypeStat struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+}
+
+// HeapSnapshot is a list of the objects reachable from an LState.
+type HeapSnapshot struct {
+	Taken   time.Time     `json:"taken"`
+	Objects []*HeapObject `json:"objects"`
+}
+
+// ReadHeapSnapshot reads a snapshot written by HeapSnapshot.WriteJSON.
+func ReadHeapSnapshot(r io.Reader) (*HeapSnapshot, error) {
+	snapshot := &HeapSnapshot{}
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Size returns the total estimated size of all objects.
+func (hs *HeapSnapshot) Size() int64 {
+	var size int64
+	for _, obj := range hs.Objects {
+		size += obj.Size
+	}
+	return size
+}
+
+// Types returns per type statistics sorted by size in descending order.
+func (hs *HeapSnapshot) Types() []HeapTypeStat {
+	stats := map[string]*HeapTypeStat{}
+	for _, obj := range hs.Objects {
+		stat, ok := stats[obj.Type]
+		if !ok {
+			stat = &HeapTypeStat{Type: obj.Type}
+			stats[obj.Type] = stat
+		}
+		stat.Count++
+		stat.Size += obj.Size
+	}
+	ret := make([]HeapTypeStat, 0, len(stats))
+	for _, stat := range stats {
+		ret = append(ret, *stat)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Size != ret[j].Size {
+			return ret[i].Size > ret[j].Size
+		}
+		return ret[i].Type < ret[j].Type
+	})
+	return ret
+}
+
+// Largest returns the n objects with the largest retained size.
+func (hs *HeapSnapshot) Largest(n int) []*HeapObject {
+	objs := make([]*HeapObject, len(hs.Objects))
+	copy(objs, hs.Objects)
+	sort.SliceStable(objs, func(i, j int) bool { return objs[i].Retained > objs[j].Retained })
+	if n >= 0 && n < len(objs) {
+		objs = objs[:n]
+	}
+	return objs
+}
+
+// Find returns the object with the given retaining path or nil.
+func (hs *HeapSnapshot) Find(path string) *HeapObject {
+	for _, obj := range hs.Objects {
+		if obj.Path == path {
+			return obj
+		}
+	}
+	return nil
+}
+
+// HeapDelta is the change of an object between two snapshots.
+type HeapDelta struct {
+	Path    string
+	Type    string
+	OldSize int64
+	NewSize int64
+	// OldEntries and NewEntries are the number of entries in each snapshot.
+	OldEntries int
+	NewEntries int
+}
+
+// Growth returns the size difference in bytes.
+func (hd *HeapDelta) Growth() int64 { return hd.NewSize - hd.OldSize }
+
+// Diff compares the snapshot with an older one by retaining path. Objects which
+// only exist in one of the snapshots have a zero size in the other. The result is
+// sorted by growth in descending order.
+func (hs *HeapSnapshot) Diff(old *HeapSnapshot) []*HeapDelta {
+	deltas := map[string]*HeapDelta{}
+	for _, obj := range old.Objects {
+		deltas[obj.Path] = &HeapDelta{Path: obj.Path, Type: obj.Type, OldSize: obj.Size, OldEntries: obj.Entries}
+	}
+	for _, obj := range hs.Objects {
+		delta, ok := deltas[obj.Path]
+		if !ok || delta.Type != obj.Type {
+			delta = &HeapDelta{Path: obj.Path, Type: obj.Type}
+			deltas[obj.Path] = delta
+		}
+		delta.NewSize = obj.Size
+		delta.NewEntries = obj.Entries
+	}
+	ret := make([]*HeapDelta, 0, len(deltas))
+	for _, delta := range deltas {
+		if delta.OldSize != delta.NewSize || delta.OldEntries != delta.NewEntries {
+			ret = append(ret, delta)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Growth() != ret[j].Growth() {
+			return ret[i].Growth() > ret[j].Growth()
+		}
+		return ret[i].Path < ret[j].Path
+	})
+	return ret
+}
+
+// WriteJSON writes the snapshot to w as JSON.
+func (hs *HeapSnapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(hs)
+}
+
+// WriteText writes a human readable summary of the snapshot to w, listing
+// the n objects with the largest retained size.
+func (hs *HeapSnapshot) WriteText(w io.Writer, n int) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "type\tcount\tsize\t\n")
+	for _, stat := range hs.Types() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t\n", stat.Type, stat.Count, stat.Size)
+	}
+	fmt.Fprintf(tw, "\t\t\t\n")
+	fmt.Fprintf(tw, "retained\tsize\tentries\t path\n")
+	for _, obj := range hs.Largest(n) {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t %s (%s)\n", obj.Retained, obj.Size, obj.Entries, obj.Path, obj.Type)
+	}
+	return tw.Flush()
+}
+
+/* }}} */
+
+/* heap walker {{{ */
+
+const heapInterfaceSize = int64(unsafe.Sizeof(LValue(nil)))
+const heapMapEntryOverhead = 8
+
+var luaIdentRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type heapNode struct {
+	obj    *HeapObject
+	parent *heapNode
+}
+
+type heapWalker struct {
+	seen  map[LValue]*heapNode
+	queue []heapQueued
+	nodes []*heapNode
+}
+
+type heapQueued struct {
+	value  LValue
+	path   string
+	parent *heapNode
+}
+
+// luaKeyPath formats a table key as a path element such as `.name`, `["a b"]` or `[1]`.
+func luaKeyPath(key LValue) string {
+	switch k := key.(type) {
+	case LString:
+		if luaIdentRegexp.MatchString(string(k)) {
+			return "." + string(k)
+		}
+		return "[" + strconv.Quote(string(k)) + "]"
+	case LNumber:
+		return "[" + k.String() + "]"
+	}
+	return "[" + key.String() + "]"
+}
+
+func heapStringSize(lv LValue) int64 {
+	if s, ok := lv.(LString); ok {
+		return int64(len(s))
+	}
+	return 0
+}
+
+func isHeapObject(lv LValue) bool {
+	switch lv.(type) {
+	case *LTable, *LFunction, *LUserData, *LState, LChannel:
+		return true
+	}
+	return false
+}
+
+func (hw *heapWalker) push(lv LValue, path string, parent *heapNode) {
+	if lv == nil || !isHeapObject(lv) {
+		return
+	}
+	if ch, ok := lv.(LChannel); ok && ch == nil {
+		return
+	}
+	if _, ok := hw.seen[lv]; ok {
+		return
+	}
+	hw.seen[lv] = nil
+	hw.queue = append(hw.queue, heapQueued{lv, path, parent})
+}
+
+func (hw *heapWalker) walk() {
+	for len(hw.queue) > 0 {
+		item := hw.queue[0]
+		hw.queue = hw.queue[1:]
+		obj := &HeapObject{Path: item.path, Type: item.value.Type().String()}
+		node := &heapNode{obj: obj, parent: item.parent}
+		hw.seen[item.value] = node
+		hw.nodes = append(hw.nodes, node)
+		switch v := item.value.(type) {
+		case *LTable:
+			hw.visitTable(v, node)
+		case *LFunction:
+			hw.visitFunction(v, node)
+		case *LUserData:
+			obj.Size = int64(unsafe.Sizeof(*v))
+			hw.push(v.Metatable, item.path+"<metatable>", node)
+			if v.Env != nil {
+				hw.push(v.Env, item.path+"<env>", node)
+			}
+		case *LState:
+			hw.visitThread(v, node)
+		case LChannel:
+			obj.Size = int64(unsafe.Sizeof(hchanHeader{})) + int64(cap(v))*heapInterfaceSize
+			obj.Entries = len(v)
+		}
+	}
+}
+
+// hchanHeader approximates the size of the runtime channel header.
+type hchanHeader struct {
+	qcount, dataqsiz uint
+	buf              unsafe.Pointer
+	elemsize         uint16
+	closed           uint32
+	elemtype         unsafe.Pointer
+	sendx, recvx     uint
+	recvq, sendq     [2]unsafe.Pointer
+	lock             uintptr
+}
+
+func (hw *heapWalker) visitTable(tb *LTable, node *heapNode) {
+	obj := node.obj
+	obj.Size = int64(unsafe.Sizeof(*tb)) + int64(cap(tb.array))*heapInterfaceSize +
+		int64(cap(tb.keys))*heapInterfaceSize + int64(len(tb.k2i))*(heapInterfaceSize+8+heapMapEntryOverhead)
+	for _, v := range tb.array {
+		obj.Size += heapStringSize(v)
+	}
+	for k, v := range tb.strdict {
+		obj.Size += int64(unsafe.Sizeof(k)) + heapInterfaceSize + heapMapEntryOverhead + int64(len(k)) + heapStringSize(v)
+	}
+	for k, v := range tb.dict {
+		obj.Size += 2*heapInterfaceSize + heapMapEntryOverhead + heapStringSize(k) + heapStringSize(v)
+	}
+
+	hw.push(tb.Metatable, obj.Path+"<metatable>", node)
+	type entry struct {
+		key, value LValue
+	}
+	entries := make([]entry, 0, 16)
+	tb.ForEach(func(k, v LValue) {
+		entries = append(entries, entry{k, v})
+	})
+	obj.Entries = len(entries)
+	// sort entries, so that retaining paths are stable between snapshots
+	sort.Slice(entries, func(i, j int) bool {
+		ki, kj := entries[i].key, entries[j].key
+		if ki.Type() != kj.Type() {
+			return ki.Type() < kj.Type()
+		}
+		if ni, ok := ki.(LNumber); ok {
+			return ni < kj.(LNumber)
+		}
+		return ki.String() < kj.String()
+	})
+	for _, e := range entries {
+		path := obj.Path + luaKeyPath(e.key)
+		hw.push(e.value, path, node)
+		hw.push(e.key, path+"<key>", node)
+	}
+}
+
+func (hw *heapWalker) visitFunction(fn *LFunction, node *heapNode) {
+	obj := node.obj
+	obj.Size = int64(unsafe.Sizeof(*fn)) + int64(cap(fn.Upvalues))*int64(unsafe.Sizeof(fn))
+	obj.Entries = len(fn.Upvalues)
+	if fn.Env != nil {
+		hw.push(fn.Env, obj.Path+"<env>", node)
+	}
+	for i, uv := range fn.Upvalues {
+		if uv == nil {
+			continue
+		}
+		obj.Size += int64(unsafe.Sizeof(*uv))
+		value := uv.Value()
+		obj.Size += heapStringSize(value)
+		name := strconv.Itoa(i + 1)
+		if !fn.IsG && i < len(fn.Proto.DbgUpvalues) {
+			name = fn.Proto.DbgUpvalues[i]
+		}
+		hw.push(value, obj.Path+"<upvalue "+name+">", node)
+	}
+}
+
+func (hw *heapWalker) visitThread(th *LState, node *heapNode) {
+	obj := node.obj
+	if th.reg == nil {
+		return
+	}
+	obj.Size = int64(unsafe.Sizeof(*th)) + int64(cap(th.reg.array))*heapInterfaceSize +
+		int64(th.Options.CallStackSize)*int64(unsafe.Sizeof(callFrame{}))
+	obj.Entries = th.reg.Top()
+	if th.Env != nil {
+		hw.push(th.Env, obj.Path+"<env>", node)
+	}
+	for i := 0; i < th.reg.Top(); i++ {
+		value := th.reg.Get(i)
+		obj.Size += heapStringSize(value)
+		hw.push(value, obj.Path+"<stack "+strconv.Itoa(i)+">", node)
+	}
+}
+
+func (hw *heapWalker) snapshot() *HeapSnapshot {
+	snapshot := &HeapSnapshot{Taken: time.Now(), Objects: make([]*HeapObject, 0, len(hw.nodes))}
+	// nodes are in breadth first order, so children always follow their parents
+	for i := len(hw.nodes) - 1; i >= 0; i-- {
+		node := hw.nodes[i]
+		node.obj.Retained += node.obj.Size
+		if node.parent != nil {
+			node.parent.obj.Retained += node.obj.Retained
+		}
+	}
+	for _, node := range hw.nodes {
+		snapshot.Objects = append(snapshot.Objects, node.obj)
+	}
+	return snapshot
+}
+
+/* }}} */
+
+/* api methods {{{ */
+
+// HeapSnapshot walks all objects reachable from the globals, the registry, the
+// builtin metatables and the stacks of the main and current threads.
+func (ls *LState) HeapSnapshot() *HeapSnapshot {
+	hw := &heapWalker{seen: make(map[LValue]*heapNode)}
+	hw.push(ls.G.Global, "_G", nil)
+	hw.push(ls.G.Registry, "registry", nil)
+	types := make([]int, 0, len(ls.G.builtinMts))
+	for typ := range ls.G.builtinMts {
+		types = append(types, typ)
+	}
+	sort.Ints(types)
+	for _, typ := range types {
+		hw.push(ls.G.builtinMts[typ], "<"+LValueType(typ).String()+" metatable>", nil)
+	}
+	main := ls.G.MainThread
+	if main == nil {
+		main = ls
+	}
+	hw.push(main, "<main thread>", nil)
+	hw.push(ls, "<current thread>", nil)
+	hw.walk()
+	return hw.snapshot()
+}
+
+/* }}} */
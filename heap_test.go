@@ -0,0 +1,54 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHeapSnapshot(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  grouper1 = {loglines = {}}
+	  local cache = {}
+	  function remember(k, v) cache[k] = v end
+	  remember("a b", {1, 2, 3})
+	`)
+	snapshot := L.HeapSnapshot()
+	errorIfNil(t, snapshot.Find("_G.grouper1.loglines"))
+	errorIfNil(t, snapshot.Find(`_G.remember<upvalue cache>["a b"]`))
+	errorIfNil(t, snapshot.Find("registry"))
+
+	g := snapshot.Find("_G")
+	errorIfFalse(t, g.Retained >= g.Size && g.Retained > snapshot.Find("_G.grouper1").Retained, "unexpected retained size")
+	found := false
+	for _, stat := range snapshot.Types() {
+		if stat.Type == "table" && stat.Count > 0 {
+			found = true
+		}
+	}
+	errorIfFalse(t, found, "tables should be reported")
+
+	errorIfScriptFail(t, L, `
+	  for i = 1, 100 do
+	    table.insert(grouper1.loglines, "line " .. i)
+	  end
+	`)
+	deltas := L.HeapSnapshot().Diff(snapshot)
+	errorIfFalse(t, len(deltas) > 0, "diff should not be empty")
+	errorIfNotEqual(t, "_G.grouper1.loglines", deltas[0].Path)
+	errorIfNotEqual(t, 100, deltas[0].NewEntries)
+	errorIfFalse(t, deltas[0].Growth() > 0, "loglines should grow")
+
+	var buf bytes.Buffer
+	errorIfNotNil(t, snapshot.WriteJSON(&buf))
+	read, err := ReadHeapSnapshot(&buf)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, len(snapshot.Objects), len(read.Objects))
+	errorIfNotEqual(t, 0, len(read.Diff(snapshot)))
+
+	buf.Reset()
+	errorIfNotNil(t, snapshot.WriteText(&buf, 5))
+	errorIfFalse(t, strings.Contains(buf.String(), "_G (table)"), "unexpected text output: %v", buf.String())
+}
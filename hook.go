@@ -0,0 +1,27 @@
+package lua
+
+// vmHook receives instruction events from mainLoopWithHooks. Hooks are shared
+// by all threads of a Global and run on the goroutine executing the VM.
+type vmHook interface {
+	// beforeInst is called before the instruction at pc of cf is executed.
+	beforeInst(L *LState, cf *callFrame, pc int, inst uint32)
+	// afterInst is called after the instruction at pc of cf has been executed
+	// unless the instruction left the VM loop.
+	afterInst(L *LState, cf *callFrame, pc int, inst uint32)
+}
+
+func (ls *LState) addHook(hook vmHook) {
+	ls.G.hooks = append(ls.G.hooks, hook)
+	ls.updateMainLoop()
+}
+
+func (ls *LState) removeHook(hook vmHook) {
+	hooks := make([]vmHook, 0, len(ls.G.hooks))
+	for _, h := range ls.G.hooks {
+		if h != hook {
+			hooks = append(hooks, h)
+		}
+	}
+	ls.G.hooks = hooks
+	ls.updateMainLoop()
+}
@@ -0,0 +1,694 @@
+package lua
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+/* JSON encoding {{{ */
+
+// JSONSparseMode controls how tables with integer keys and holes are encoded.
+type JSONSparseMode int
+
+const (
+	// JSONSparseNull encodes holes as null unless the array is excessively sparse,
+	// in which case the table is encoded as an object. Arrays with holes and
+	// indexes greater than JSONMaxArrayIndex raise an error.
+	JSONSparseNull JSONSparseMode = iota
+	// JSONSparseObject encodes tables with holes as objects.
+	JSONSparseObject
+	// JSONSparseError raises an error for tables with holes.
+	JSONSparseError
+)
+
+// JSONOptions controls EncodeJSON.
+type JSONOptions struct {
+	// Indent enables pretty printing with the given indentation.
+	Indent string
+	// SortKeys writes object keys in sorted order.
+	SortKeys bool
+	// Sparse controls how arrays with holes are encoded.
+	Sparse JSONSparseMode
+	// An array with holes is excessively sparse if its largest index is greater than
+	// SparseSafe and greater than SparseRatio times the number of its elements.
+	SparseRatio int
+	SparseSafe  int
+	// Null is encoded as null, for example the json.null sentinel.
+	Null LValue
+	// Tables with ArrayMetatable are always encoded as arrays, even if they are empty,
+	// tables with ObjectMetatable always as objects.
+	ArrayMetatable  LValue
+	ObjectMetatable LValue
+}
+
+// DefaultJSONOptions are the defaults of json.encode.
+var DefaultJSONOptions = JSONOptions{
+	SparseRatio: 2,
+	SparseSafe:  10,
+}
+
+// JSONMaxDepth is the maximum nesting depth of encoded and decoded values.
+var JSONMaxDepth = 1000
+
+type jsonEncoder struct {
+	w     *bufio.Writer
+	opts  *JSONOptions
+	stack []*LTable
+	path  []LValue
+}
+
+// EncodeJSON writes lv as JSON to w. Tables are written directly to w while they are
+// traversed.
+func EncodeJSON(w io.Writer, lv LValue, opts JSONOptions) error {
+	enc := &jsonEncoder{w: bufio.NewWriter(w), opts: &opts}
+	if err := enc.encode(lv, 0); err != nil {
+		return err
+	}
+	return enc.w.Flush()
+}
+
+func (enc *jsonEncoder) errorf(format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	if len(enc.path) > 0 {
+		var b strings.Builder
+		b.WriteString("value")
+		for _, key := range enc.path {
+			b.WriteString(luaKeyPath(key))
+		}
+		message += " at " + b.String()
+	}
+	return fmt.Errorf("json: %s", message)
+}
+
+func (enc *jsonEncoder) newline(depth int) {
+	if len(enc.opts.Indent) == 0 {
+		return
+	}
+	enc.w.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		enc.w.WriteString(enc.opts.Indent)
+	}
+}
+
+func (enc *jsonEncoder) encode(lv LValue, depth int) error {
+	if enc.opts.Null != nil && lv == enc.opts.Null {
+		enc.w.WriteString("null")
+		return nil
+	}
+	switch v := lv.(type) {
+	case *LNilType:
+		enc.w.WriteString("null")
+	case LBool:
+		if v {
+			enc.w.WriteString("true")
+		} else {
+			enc.w.WriteString("false")
+		}
+	case LNumber:
+		return enc.encodeNumber(v)
+	case LString:
+		enc.encodeString(string(v))
+	case *LTable:
+		return enc.encodeTable(v, depth)
+	default:
+		return enc.errorf("cannot encode a %v", lv.Type())
+	}
+	return nil
+}
+
+func (enc *jsonEncoder) encodeNumber(n LNumber) error {
+	f := float64(n)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return enc.errorf("cannot encode %v", n)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		enc.w.WriteString(strconv.FormatInt(int64(f), 10))
+	} else {
+		enc.w.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return nil
+}
+
+const jsonHex = "0123456789abcdef"
+
+func (enc *jsonEncoder) encodeString(s string) {
+	w := enc.w
+	w.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' && c != 0x7f {
+			continue
+		}
+		w.WriteString(s[start:i])
+		switch c {
+		case '"', '\\':
+			w.WriteByte('\\')
+			w.WriteByte(c)
+		case '\n':
+			w.WriteString(`\n`)
+		case '\r':
+			w.WriteString(`\r`)
+		case '\t':
+			w.WriteString(`\t`)
+		default:
+			w.WriteString(`\u00`)
+			w.WriteByte(jsonHex[c>>4])
+			w.WriteByte(jsonHex[c&0xf])
+		}
+		start = i + 1
+	}
+	w.WriteString(s[start:])
+	w.WriteByte('"')
+}
+
+// JSONMaxArrayIndex is the largest index of an array with holes the encoder
+// pads with nulls.
+const JSONMaxArrayIndex = math.MaxInt32
+
+// arrayLength returns the length of tb if it should be encoded as an array, -1 otherwise.
+func (enc *jsonEncoder) arrayLength(tb *LTable) (int, error) {
+	mt := tb.Metatable
+	if mt != LNil && mt == enc.opts.ObjectMetatable {
+		return -1, nil
+	}
+	if mt != LNil && mt == enc.opts.ArrayMetatable {
+		return tb.Len(), nil
+	}
+	max, count := 0, 0
+	isArray, huge := true, false
+	tb.ForEach(func(key, value LValue) {
+		if !isArray {
+			return
+		}
+		n, ok := key.(LNumber)
+		if !ok || n < 1 || float64(n) != math.Trunc(float64(n)) {
+			isArray = false
+			return
+		}
+		if n > JSONMaxArrayIndex {
+			huge = true
+		} else if int(n) > max {
+			max = int(n)
+		}
+		count++
+	})
+	if !isArray || count == 0 {
+		return -1, nil
+	}
+	if max == count && !huge {
+		return max, nil
+	}
+	switch enc.opts.Sparse {
+	case JSONSparseObject:
+		return -1, nil
+	case JSONSparseError:
+		return -1, enc.errorf("cannot encode a sparse array")
+	}
+	if huge {
+		return -1, enc.errorf("cannot encode an excessively sparse array")
+	}
+	if max > enc.opts.SparseSafe && max > enc.opts.SparseRatio*count {
+		return -1, nil
+	}
+	return max, nil
+}
+
+func (enc *jsonEncoder) encodeTable(tb *LTable, depth int) error {
+	for _, parent := range enc.stack {
+		if parent == tb {
+			return enc.errorf("cannot encode a table with cycles")
+		}
+	}
+	if len(enc.stack) >= JSONMaxDepth {
+		return enc.errorf("nesting too deep")
+	}
+	enc.stack = append(enc.stack, tb)
+	defer func() { enc.stack = enc.stack[:len(enc.stack)-1] }()
+
+	n, err := enc.arrayLength(tb)
+	if err != nil {
+		return err
+	}
+	if n >= 0 {
+		enc.w.WriteByte('[')
+		for i := 1; i <= n; i++ {
+			if i > 1 {
+				enc.w.WriteByte(',')
+			}
+			enc.newline(depth + 1)
+			enc.path = append(enc.path, LNumber(i))
+			if err := enc.encode(tb.RawGetInt(i), depth+1); err != nil {
+				return err
+			}
+			enc.path = enc.path[:len(enc.path)-1]
+		}
+		if n > 0 {
+			enc.newline(depth)
+		}
+		enc.w.WriteByte(']')
+		return nil
+	}
+
+	var keys []LValue
+	var keyErr error
+	tb.ForEach(func(key, value LValue) {
+		switch key.(type) {
+		case LString, LNumber:
+			keys = append(keys, key)
+		default:
+			if keyErr == nil {
+				keyErr = enc.errorf("cannot encode a table key of type %v", key.Type())
+			}
+		}
+	})
+	if keyErr != nil {
+		return keyErr
+	}
+	if enc.opts.SortKeys {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	}
+	enc.w.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			enc.w.WriteByte(',')
+		}
+		enc.newline(depth + 1)
+		enc.encodeString(key.String())
+		enc.w.WriteByte(':')
+		if len(enc.opts.Indent) > 0 {
+			enc.w.WriteByte(' ')
+		}
+		enc.path = append(enc.path, key)
+		if err := enc.encode(tb.RawGet(key), depth+1); err != nil {
+			return err
+		}
+		enc.path = enc.path[:len(enc.path)-1]
+	}
+	if len(keys) > 0 {
+		enc.newline(depth)
+	}
+	enc.w.WriteByte('}')
+	return nil
+}
+
+/* }}} */
+
+/* JSON decoding {{{ */
+
+type jsonDecoder struct {
+	L       *LState
+	data    string
+	pos     int
+	null    LValue
+	arrayMt LValue
+	depth   int
+}
+
+// DecodeJSON decodes a JSON document. JSON null is decoded to null, empty arrays get the
+// metatable arrayMt unless it is nil.
+func DecodeJSON(L *LState, data string, null LValue, arrayMt LValue) (LValue, error) {
+	dec := &jsonDecoder{L: L, data: data, null: null, arrayMt: arrayMt}
+	dec.skipSpace()
+	lv, err := dec.value()
+	if err != nil {
+		return LNil, err
+	}
+	dec.skipSpace()
+	if dec.pos < len(dec.data) {
+		return LNil, dec.errorf("unexpected %q", dec.data[dec.pos])
+	}
+	return lv, nil
+}
+
+func (dec *jsonDecoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("json: %s at position %d", fmt.Sprintf(format, args...), dec.pos+1)
+}
+
+func (dec *jsonDecoder) skipSpace() {
+	for dec.pos < len(dec.data) {
+		switch dec.data[dec.pos] {
+		case ' ', '\t', '\n', '\r':
+			dec.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (dec *jsonDecoder) expect(c byte) error {
+	dec.skipSpace()
+	if dec.pos >= len(dec.data) {
+		return dec.errorf("unexpected end of input, expected %q", c)
+	}
+	if dec.data[dec.pos] != c {
+		return dec.errorf("unexpected %q, expected %q", dec.data[dec.pos], c)
+	}
+	dec.pos++
+	return nil
+}
+
+func (dec *jsonDecoder) value() (LValue, error) {
+	if dec.pos >= len(dec.data) {
+		return LNil, dec.errorf("unexpected end of input")
+	}
+	switch c := dec.data[dec.pos]; {
+	case c == '{':
+		return dec.object()
+	case c == '[':
+		return dec.array()
+	case c == '"':
+		s, err := dec.str()
+		return LString(s), err
+	case c == '-' || (c >= '0' && c <= '9'):
+		return dec.number()
+	case strings.HasPrefix(dec.data[dec.pos:], "true"):
+		dec.pos += 4
+		return LTrue, nil
+	case strings.HasPrefix(dec.data[dec.pos:], "false"):
+		dec.pos += 5
+		return LFalse, nil
+	case strings.HasPrefix(dec.data[dec.pos:], "null"):
+		dec.pos += 4
+		return dec.null, nil
+	default:
+		return LNil, dec.errorf("unexpected %q", c)
+	}
+}
+
+func (dec *jsonDecoder) enter() error {
+	dec.depth++
+	if dec.depth > JSONMaxDepth {
+		return dec.errorf("nesting too deep")
+	}
+	return nil
+}
+
+func (dec *jsonDecoder) object() (LValue, error) {
+	if err := dec.enter(); err != nil {
+		return LNil, err
+	}
+	dec.pos++
+	tb := dec.L.NewTable()
+	dec.skipSpace()
+	if dec.pos < len(dec.data) && dec.data[dec.pos] == '}' {
+		dec.pos++
+		dec.depth--
+		return tb, nil
+	}
+	for {
+		dec.skipSpace()
+		if dec.pos >= len(dec.data) || dec.data[dec.pos] != '"' {
+			return LNil, dec.errorf("object key expected")
+		}
+		key, err := dec.str()
+		if err != nil {
+			return LNil, err
+		}
+		if err := dec.expect(':'); err != nil {
+			return LNil, err
+		}
+		dec.skipSpace()
+		value, err := dec.value()
+		if err != nil {
+			return LNil, err
+		}
+		tb.RawSetString(key, value)
+		dec.skipSpace()
+		if dec.pos < len(dec.data) && dec.data[dec.pos] == ',' {
+			dec.pos++
+			continue
+		}
+		if err := dec.expect('}'); err != nil {
+			return LNil, err
+		}
+		dec.depth--
+		return tb, nil
+	}
+}
+
+func (dec *jsonDecoder) array() (LValue, error) {
+	if err := dec.enter(); err != nil {
+		return LNil, err
+	}
+	dec.pos++
+	tb := dec.L.NewTable()
+	dec.skipSpace()
+	if dec.pos < len(dec.data) && dec.data[dec.pos] == ']' {
+		dec.pos++
+		dec.depth--
+		if dec.arrayMt != nil && dec.arrayMt != LNil {
+			tb.Metatable = dec.arrayMt
+		}
+		return tb, nil
+	}
+	for i := 1; ; i++ {
+		dec.skipSpace()
+		value, err := dec.value()
+		if err != nil {
+			return LNil, err
+		}
+		tb.RawSetInt(i, value)
+		dec.skipSpace()
+		if dec.pos < len(dec.data) && dec.data[dec.pos] == ',' {
+			dec.pos++
+			continue
+		}
+		if err := dec.expect(']'); err != nil {
+			return LNil, err
+		}
+		dec.depth--
+		return tb, nil
+	}
+}
+
+func (dec *jsonDecoder) number() (LValue, error) {
+	start := dec.pos
+	for dec.pos < len(dec.data) {
+		c := dec.data[dec.pos]
+		if (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E' {
+			dec.pos++
+			continue
+		}
+		break
+	}
+	f, err := strconv.ParseFloat(dec.data[start:dec.pos], 64)
+	if err != nil {
+		text := dec.data[start:dec.pos]
+		dec.pos = start
+		return LNil, dec.errorf("invalid number %q", text)
+	}
+	return LNumber(f), nil
+}
+
+func (dec *jsonDecoder) str() (string, error) {
+	dec.pos++
+	start := dec.pos
+	// fast path for strings without escapes
+	for dec.pos < len(dec.data) {
+		c := dec.data[dec.pos]
+		if c == '"' {
+			s := dec.data[start:dec.pos]
+			dec.pos++
+			return s, nil
+		}
+		if c == '\\' || c < 0x20 {
+			break
+		}
+		dec.pos++
+	}
+	buf := []byte(dec.data[start:dec.pos])
+	for dec.pos < len(dec.data) {
+		c := dec.data[dec.pos]
+		switch {
+		case c == '"':
+			dec.pos++
+			return string(buf), nil
+		case c < 0x20:
+			return "", dec.errorf("control character in string")
+		case c != '\\':
+			buf = append(buf, c)
+			dec.pos++
+			continue
+		}
+		if dec.pos+1 >= len(dec.data) {
+			break
+		}
+		dec.pos++
+		switch esc := dec.data[dec.pos]; esc {
+		case '"', '\\', '/':
+			buf = append(buf, esc)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, ok := dec.hex4(dec.pos + 1)
+			if !ok {
+				return "", dec.errorf("invalid unicode escape")
+			}
+			dec.pos += 4
+			if utf16.IsSurrogate(r) {
+				if r2, ok := dec.hex4(dec.pos + 3); ok && strings.HasPrefix(dec.data[dec.pos+1:], `\u`) {
+					if dr := utf16.DecodeRune(r, r2); dr != utf8.RuneError {
+						r = dr
+						dec.pos += 6
+					}
+				}
+			}
+			buf = append(buf, string(r)...)
+		default:
+			return "", dec.errorf("invalid escape '\\%c'", esc)
+		}
+		dec.pos++
+	}
+	return "", dec.errorf("unterminated string")
+}
+
+func (dec *jsonDecoder) hex4(pos int) (rune, bool) {
+	if pos+4 > len(dec.data) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(dec.data[pos:pos+4], 16, 32)
+	return rune(n), err == nil
+}
+
+/* }}} */
+
+/* json library {{{ */
+
+type jsonLib struct {
+	null     *LUserData
+	arrayMt  *LTable
+	objectMt *LTable
+}
+
+// OpenJson opens the json library. It is not opened by OpenLibs.
+func OpenJson(L *LState) int {
+	lib := &jsonLib{null: L.NewUserData(), arrayMt: L.NewTable(), objectMt: L.NewTable()}
+	lib.null.Metatable = L.SetFuncs(L.NewTable(), map[string]LGFunction{
+		"__tostring": func(L *LState) int {
+			L.Push(LString("null"))
+			return 1
+		},
+	})
+	mod := L.RegisterModule(JsonLibName, map[string]LGFunction{
+		"encode": lib.encode,
+		"decode": lib.decode,
+		"write":  lib.write,
+		"array":  lib.array,
+		"object": lib.object,
+	})
+	L.SetField(mod, "null", lib.null)
+	L.SetField(mod, "array_mt", lib.arrayMt)
+	L.SetField(mod, "object_mt", lib.objectMt)
+	L.Push(mod)
+	return 1
+}
+
+// options reads the options table at index n.
+func (lib *jsonLib) options(L *LState, n int) JSONOptions {
+	opts := DefaultJSONOptions
+	opts.Null = lib.null
+	opts.ArrayMetatable = lib.arrayMt
+	opts.ObjectMetatable = lib.objectMt
+	tb := L.OptTable(n, nil)
+	if tb == nil {
+		return opts
+	}
+	switch indent := tb.RawGetString("indent").(type) {
+	case LNumber:
+		opts.Indent = strings.Repeat(" ", int(indent))
+	case LString:
+		opts.Indent = string(indent)
+	case LBool:
+		if indent {
+			opts.Indent = "  "
+		}
+	}
+	opts.SortKeys = LVAsBool(tb.RawGetString("sort_keys"))
+	switch sparse := tb.RawGetString("sparse"); sparse {
+	case LNil, LString("null"):
+	case LString("object"):
+		opts.Sparse = JSONSparseObject
+	case LString("error"):
+		opts.Sparse = JSONSparseError
+	default:
+		L.ArgError(n, "invalid sparse mode "+sparse.String())
+	}
+	if ratio, ok := tb.RawGetString("sparse_ratio").(LNumber); ok {
+		opts.SparseRatio = int(ratio)
+	}
+	if safe, ok := tb.RawGetString("sparse_safe").(LNumber); ok {
+		opts.SparseSafe = int(safe)
+	}
+	return opts
+}
+
+func (lib *jsonLib) encode(L *LState) int {
+	value := L.CheckAny(1)
+	var b strings.Builder
+	if err := EncodeJSON(&b, value, lib.options(L, 2)); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.Push(LString(b.String()))
+	return 1
+}
+
+// json.write(file, value [, options]) encodes the value directly to a file.
+func (lib *jsonLib) write(L *LState) int {
+	file, ok := L.CheckUserData(1).Value.(*lFile)
+	if !ok {
+		L.ArgError(1, "file expected")
+	}
+	errorIfFileIsClosed(L, file)
+	if file.writer == nil {
+		L.ArgError(1, "file is not writable")
+	}
+	if err := EncodeJSON(file.writer, L.CheckAny(2), lib.options(L, 3)); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	return 0
+}
+
+func (lib *jsonLib) decode(L *LState) int {
+	null := LValue(lib.null)
+	if tb := L.OptTable(2, nil); tb != nil && LVAsBool(tb.RawGetString("null_as_nil")) {
+		null = LNil
+	}
+	lv, err := DecodeJSON(L, L.CheckString(1), null, lib.arrayMt)
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.Push(lv)
+	return 1
+}
+
+func (lib *jsonLib) array(L *LState) int {
+	tb := L.OptTable(1, L.NewTable())
+	L.SetMetatable(tb, lib.arrayMt)
+	L.Push(tb)
+	return 1
+}
+
+func (lib *jsonLib) object(L *LState) int {
+	tb := L.OptTable(1, L.NewTable())
+	L.SetMetatable(tb, lib.objectMt)
+	L.Push(tb)
+	return 1
+}
+
+/* }}} */
@@ -0,0 +1,108 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func newJsonState() *LState {
+	L := NewState()
+	L.PreloadModule(JsonLibName, OpenJson)
+	return L
+}
+
+func TestJsonEncode(t *testing.T) {
+	L := newJsonState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local json = require("json")
+	  assert(json.encode({1, 2, "a\n\"b\""}) == '[1,2,"a\\n\\"b\\""]')
+	  assert(json.encode({b = 1, a = {true, false}, c = json.null}, {sort_keys = true}) == '{"a":[true,false],"b":1,"c":null}')
+	  assert(json.encode({}) == '{}')
+	  assert(json.encode(json.array()) == '[]')
+	  assert(json.encode(setmetatable({}, json.array_mt)) == '[]')
+	  assert(json.encode(json.object({1, 2})) == '{"1":1,"2":2}')
+	  assert(json.encode(1.5) == '1.5' and json.encode(1e20) == '1e+20' and json.encode(-3) == '-3')
+	  assert(json.encode("\1\127") == '"\\u0001\\u007f"')
+
+	  -- sparse arrays
+	  assert(json.encode({1, nil, 3}) == '[1,null,3]')
+	  assert(json.encode({[1] = 1, [20] = 2}, {sort_keys = true}) == '{"1":1,"20":2}')
+	  assert(json.encode({1, nil, 3}, {sparse = "object", sort_keys = true}) == '{"1":1,"3":3}')
+	  assert(not pcall(json.encode, {1, nil, 3}, {sparse = "error"}))
+	  local ok, err = pcall(json.encode, {1, [2^63] = 2}, {sparse_safe = 2^40})
+	  assert(not ok and err:find("cannot encode an excessively sparse array"), err)
+	  assert(not pcall(json.encode, {[2^31] = 1}))
+	  assert(json.encode({[2^31] = 1}, {sparse = "object"}) == '{"2147483648":1}')
+
+	  assert(json.encode({a = {1}}, {indent = 2}) == '{\n  "a": [\n    1\n  ]\n}')
+
+	  local t = {a = {}}
+	  t.a.b = t
+	  local ok, err = pcall(json.encode, t)
+	  assert(not ok and err:find("cannot encode a table with cycles at value.a.b"), err)
+	  ok, err = pcall(json.encode, {f = print})
+	  assert(not ok and err:find("cannot encode a function at value.f"), err)
+	  ok, err = pcall(json.encode, 0/0)
+	  assert(not ok)
+	`)
+}
+
+func TestJsonDecode(t *testing.T) {
+	L := newJsonState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local json = require("json")
+	  local v = json.decode(' {"a": [1, 2.5, -3e2], "b": {"c": null}, "d": "x\\u00e9\\ud83d\\ude00\\n", "e": [], "f": true} ')
+	  assert(v.a[1] == 1 and v.a[2] == 2.5 and v.a[3] == -300)
+	  assert(v.b.c == json.null and tostring(json.null) == "null")
+	  assert(v.d == "xé😀\n")
+	  assert(getmetatable(v.e) == json.array_mt and json.encode(v.e) == "[]")
+	  assert(v.f == true)
+	  assert(json.decode('{"c": null}', {null_as_nil = true}).c == nil)
+	  assert(json.encode(json.decode('{"a":[1,{"b":null}]}')) == '{"a":[1,{"b":null}]}')
+
+	  for _, s in ipairs({'{', '[1,]', '{"a" 1}', '"abc', 'nul', '1 2', '"\\q"'}) do
+	    local ok, err = pcall(json.decode, s)
+	    assert(not ok and err:find("json: .* at position %d+"), s)
+	  end
+	`)
+}
+
+func TestJsonWrite(t *testing.T) {
+	L := newJsonState()
+	defer L.Close()
+	var b strings.Builder
+	errorIfNotNil(t, EncodeJSON(&b, LString("x"), DefaultJSONOptions))
+	errorIfNotEqual(t, `"x"`, b.String())
+
+	errorIfScriptFail(t, L, `
+	  local json = require("json")
+	  local path = os.tmpname()
+	  local f = io.open(path, "w")
+	  json.write(f, {1, 2, 3})
+	  f:close()
+	  f = io.open(path, "r")
+	  assert(f:read("*a") == "[1,2,3]")
+	  f:close()
+	  os.remove(path)
+	`)
+}
+
+func TestJsonSnapshot(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule(JsonLibName, OpenJson)
+	errorIfScriptFail(t, L, `
+	  json = require("json")
+	  list, map = {}, {}
+	`)
+	snapshot := L.Snapshot()
+	errorIfScriptFail(t, L, `
+	  json.array(list)
+	  json.object(map)
+	  assert(json.encode(list) == "[]" and json.encode(map) == "{}")
+	`)
+	errorIfNotNil(t, L.Restore(snapshot))
+	errorIfScriptFail(t, L, `assert(getmetatable(list) == nil and getmetatable(map) == nil)`)
+}
@@ -13,6 +13,8 @@ const (
 	OsLibName = "os"
 	// StringLibName is the name of the string Library.
 	StringLibName = "string"
+	// Utf8LibName is the name of the utf8 Library.
+	Utf8LibName = "utf8"
 	// MathLibName is the name of the math Library.
 	MathLibName = "math"
 	// DebugLibName is the name of the debug Library.
@@ -21,6 +23,14 @@ const (
 	ChannelLibName = "channel"
 	// CoroutineLibName is the name of the coroutine Library.
 	CoroutineLibName = "coroutine"
+	// JsonLibName is the name of the json Library. It is not opened by OpenLibs.
+	JsonLibName = "json"
+	// TimeLibName is the name of the time Library. It is not opened by OpenLibs.
+	TimeLibName = "time"
+	// RegexpLibName is the name of the regexp Library. It is not opened by OpenLibs.
+	RegexpLibName = "regexp"
+	// StringsLibName is the name of the strings Library. It is not opened by OpenLibs.
+	StringsLibName = "strings"
 )
 
 type luaLib struct {
@@ -35,6 +45,7 @@ var luaLibs = []luaLib{
 	luaLib{IoLibName, OpenIo},
 	luaLib{OsLibName, OpenOs},
 	luaLib{StringLibName, OpenString},
+	luaLib{Utf8LibName, OpenUtf8},
 	luaLib{MathLibName, OpenMath},
 	luaLib{DebugLibName, OpenDebug},
 	luaLib{ChannelLibName, OpenChannel},
@@ -2,6 +2,7 @@ package lua
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,6 +11,7 @@ import (
 /* load lib {{{ */
 
 var loLoaders = []LGFunction{loLoaderPreload, loLoaderLua}
+var loLoaderPriorities = []int{PreloadSearcherPriority, PathSearcherPriority}
 
 func loGetPath(env string, defpath string) string {
 	path := os.Getenv(env)
@@ -37,7 +39,7 @@ func loFindFile(L *LState, name, pname string) (string, string) {
 	messages := []string{}
 	for _, pattern := range strings.Split(string(path), ";") {
 		luapath := strings.Replace(pattern, "?", name, -1)
-		if _, err := os.Stat(luapath); err == nil {
+		if _, err := fs.Stat(L.fileSystem(), luapath); err == nil {
 			return luapath, ""
 		} else {
 			messages = append(messages, err.Error())
@@ -52,11 +54,11 @@ func OpenPackage(L *LState) int {
 	L.SetField(packagemod, "preload", L.NewTable())
 
 	loaders := L.CreateTable(len(loLoaders), 0)
+	L.SetField(L.Get(RegistryIndex), "_LOADERS", loaders)
 	for i, loader := range loLoaders {
-		L.RawSetInt(loaders, i+1, L.NewFunction(loader))
+		L.addLoader(loLoaderPriorities[i], L.NewFunction(loader))
 	}
 	L.SetField(packagemod, "loaders", loaders)
-	L.SetField(L.Get(RegistryIndex), "_LOADERS", loaders)
 
 	loaded := L.NewTable()
 	L.SetField(packagemod, "loaded", loaded)
@@ -73,8 +75,9 @@ func OpenPackage(L *LState) int {
 }
 
 var loFuncs = map[string]LGFunction{
-	"loadlib": loLoadLib,
-	"seeall":  loSeeAll,
+	"loadlib":    loLoadLib,
+	"seeall":     loSeeAll,
+	"searchpath": loSearchPath,
 }
 
 func loLoaderPreload(L *LState) int {
@@ -0,0 +1,328 @@
+package luatest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// ModuleName is the name of the module available to the tests via require.
+const ModuleName = "luatest"
+
+// testEnv is the host side of a single test case.
+type testEnv struct {
+	L      *lua.LState
+	dir    string
+	clock  time.Time
+	start  time.Time
+	calls  map[string]*lua.LTable
+	cancel context.CancelFunc
+}
+
+func newTestEnv(L *lua.LState, dir string, clock time.Time) *testEnv {
+	return &testEnv{
+		L:     L,
+		dir:   dir,
+		clock: clock,
+		start: clock,
+		calls: make(map[string]*lua.LTable),
+	}
+}
+
+func (env *testEnv) close() {
+	if env.cancel != nil {
+		env.cancel()
+	}
+	env.L.Close()
+}
+
+// install replaces os.time and os.clock by the fake clock, installs the mocks,
+// preloads the luatest and testing modules and lets require find modules next to the test file.
+func (env *testEnv) install(mocks []string) error {
+	L := env.L
+	if pkg, ok := L.GetGlobal("package").(*lua.LTable); ok {
+		path := filepath.Join(env.dir, "?.lua")
+		L.SetField(pkg, "path", lua.LString(path+";"+lua.LVAsString(L.GetField(pkg, "path"))))
+	}
+	if os, ok := L.GetGlobal("os").(*lua.LTable); ok {
+		if fn, ok := L.GetField(os, "time").(*lua.LFunction); ok && fn.IsG {
+			L.SetField(os, "time", L.NewFunction(env.osTime(fn.GFunction)))
+		}
+		L.SetField(os, "clock", L.NewFunction(env.osClock))
+	}
+	for _, name := range mocks {
+		if err := env.setPath(name, env.newMock(name, nil)); err != nil {
+			return err
+		}
+	}
+	L.PreloadModule(ModuleName, env.loader)
+	L.PreloadModule(TestingModuleName, Loader)
+	return nil
+}
+
+// setPath sets a global or a field of a global table given as a dotted path.
+func (env *testEnv) setPath(path string, value lua.LValue) error {
+	names := strings.Split(path, ".")
+	var tb lua.LValue = env.L.Get(lua.GlobalsIndex)
+	for _, name := range names[:len(names)-1] {
+		next := env.L.GetField(tb, name)
+		if next == lua.LNil {
+			next = env.L.NewTable()
+			env.L.SetField(tb, name, next)
+		}
+		if _, ok := next.(*lua.LTable); !ok {
+			return fmt.Errorf("can not mock %s: %s is a %s", path, name, next.Type().String())
+		}
+		tb = next
+	}
+	env.L.SetField(tb, names[len(names)-1], value)
+	return nil
+}
+
+func (env *testEnv) getPath(path string) lua.LValue {
+	var value lua.LValue = env.L.Get(lua.GlobalsIndex)
+	for _, name := range strings.Split(path, ".") {
+		if _, ok := value.(*lua.LTable); !ok {
+			return lua.LNil
+		}
+		value = env.L.GetField(value, name)
+	}
+	return value
+}
+
+// newMock returns a function which records its arguments and returns the given values.
+// If the first value is a function, the mock calls it and returns its results.
+func (env *testEnv) newMock(name string, returns []lua.LValue) *lua.LFunction {
+	var impl lua.LValue
+	if len(returns) > 0 {
+		if fn, ok := returns[0].(*lua.LFunction); ok {
+			impl = fn
+		}
+	}
+	return newRecorder(env.L, func() *lua.LTable { return env.callsOf(name) }, impl, returns)
+}
+
+func (env *testEnv) callsOf(name string) *lua.LTable {
+	calls, ok := env.calls[name]
+	if !ok {
+		calls = env.L.NewTable()
+		env.calls[name] = calls
+	}
+	return calls
+}
+
+func (env *testEnv) osTime(orig lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		if L.GetTop() == 0 || L.Get(1) == lua.LNil {
+			L.Push(lua.LNumber(env.clock.Unix()))
+			return 1
+		}
+		return orig(L)
+	}
+}
+
+func (env *testEnv) osClock(L *lua.LState) int {
+	L.Push(lua.LNumber(env.clock.Sub(env.start).Seconds()))
+	return 1
+}
+
+// resolve returns the path of a fixture relative to the directory of the test file.
+func (env *testEnv) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(env.dir, path)
+}
+
+// loadFixture reads a JSON file. Files with the .jsonl or .ndjson extension
+// contain one JSON value per line and are returned as an array.
+func (env *testEnv) loadFixture(path string) (lua.LValue, error) {
+	data, err := ioutil.ReadFile(env.resolve(path))
+	if err != nil {
+		return lua.LNil, err
+	}
+	switch filepath.Ext(path) {
+	case ".jsonl", ".ndjson":
+		tb := env.L.NewTable()
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			value, err := env.decodeJSON(scanner.Bytes())
+			if err != nil {
+				return lua.LNil, fmt.Errorf("%s:%d: %s", path, line, err.Error())
+			}
+			tb.Append(value)
+		}
+		return tb, scanner.Err()
+	}
+	value, err := env.decodeJSON(data)
+	if err != nil {
+		return lua.LNil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return value, nil
+}
+
+func (env *testEnv) decodeJSON(data []byte) (lua.LValue, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return lua.LNil, err
+	}
+	return env.fromJSON(value), nil
+}
+
+func (env *testEnv) fromJSON(value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		tb := env.L.CreateTable(len(v), 0)
+		for i, elem := range v {
+			tb.RawSetInt(i+1, env.fromJSON(elem))
+		}
+		return tb
+	case map[string]interface{}:
+		tb := env.L.CreateTable(0, len(v))
+		for key, elem := range v {
+			tb.RawSetString(key, env.fromJSON(elem))
+		}
+		return tb
+	}
+	return lua.LNil
+}
+
+/* luatest module {{{ */
+
+func (env *testEnv) loader(L *lua.LState) int {
+	mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"mock":    env.luaMock,
+		"calls":   env.luaCalls,
+		"reset":   env.luaReset,
+		"fixture": env.luaFixture,
+		"feed":    env.luaFeed,
+		"time":    env.luaTime,
+		"settime": env.luaSetTime,
+		"advance": env.luaAdvance,
+	})
+	L.Push(mod)
+	return 1
+}
+
+func (env *testEnv) luaMock(L *lua.LState) int {
+	name := L.CheckString(1)
+	returns := make([]lua.LValue, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		returns = append(returns, L.Get(i))
+	}
+	mock := env.newMock(name, returns)
+	if err := env.setPath(name, mock); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.Push(mock)
+	return 1
+}
+
+func (env *testEnv) luaCalls(L *lua.LState) int {
+	L.Push(env.callsOf(L.CheckString(1)))
+	return 1
+}
+
+func (env *testEnv) luaReset(L *lua.LState) int {
+	if L.GetTop() == 0 {
+		env.calls = make(map[string]*lua.LTable)
+	} else {
+		delete(env.calls, L.CheckString(1))
+	}
+	return 0
+}
+
+func (env *testEnv) luaFixture(L *lua.LState) int {
+	value, err := env.loadFixture(L.CheckString(1))
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.Push(value)
+	return 1
+}
+
+func (env *testEnv) luaFeed(L *lua.LState) int {
+	var entry lua.LValue
+	switch fn := L.CheckAny(1).(type) {
+	case lua.LString:
+		entry = env.getPath(string(fn))
+		if _, ok := entry.(*lua.LFunction); !ok {
+			L.RaiseError("entry point %s is not a function", string(fn))
+		}
+	case *lua.LFunction:
+		entry = fn
+	default:
+		L.TypeError(1, lua.LTFunction)
+	}
+
+	var events lua.LValue
+	switch fixture := L.CheckAny(2).(type) {
+	case lua.LString:
+		value, err := env.loadFixture(string(fixture))
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		events = value
+	case *lua.LTable:
+		events = fixture
+	default:
+		L.TypeError(2, lua.LTTable)
+	}
+
+	count := 0
+	feed := func(event lua.LValue) {
+		L.CallByParam(lua.P{Fn: entry, NRet: 0, Protect: false}, event)
+		count++
+	}
+	if tb, ok := events.(*lua.LTable); ok && tb.Len() > 0 {
+		for i := 1; i <= tb.Len(); i++ {
+			feed(tb.RawGetInt(i))
+		}
+	} else {
+		feed(events)
+	}
+	L.Push(lua.LNumber(count))
+	return 1
+}
+
+func (env *testEnv) luaTime(L *lua.LState) int {
+	L.Push(lua.LNumber(env.clock.Unix()))
+	return 1
+}
+
+func (env *testEnv) luaSetTime(L *lua.LState) int {
+	sec, frac := math.Modf(float64(L.CheckNumber(1)))
+	clock := time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC()
+	// setting the time does not change os.clock
+	env.start = env.start.Add(clock.Sub(env.clock))
+	env.clock = clock
+	return 0
+}
+
+func (env *testEnv) luaAdvance(L *lua.LState) int {
+	env.clock = env.clock.Add(time.Duration(float64(L.CheckNumber(1)) * float64(time.Second)))
+	return 0
+}
+
+/* }}} */
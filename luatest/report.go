@@ -0,0 +1,137 @@
+package luatest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Report is the result of a test run.
+type Report struct {
+	Results []*Result
+}
+
+// Failed returns the number of failed test cases.
+func (r *Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if !result.Passed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Passed reports whether all test cases passed.
+func (r *Report) Passed() bool { return r.Failed() == 0 }
+
+func (result *Result) title() string {
+	if len(result.Name) == 0 {
+		return result.File
+	}
+	return result.File + ": " + result.Name
+}
+
+// WriteTAP writes the report in the Test Anything Protocol version 13 format.
+func (r *Report) WriteTAP(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", len(r.Results))
+	for i, result := range r.Results {
+		if result.Passed {
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, result.title())
+			continue
+		}
+		fmt.Fprintf(&b, "not ok %d - %s\n", i+1, result.title())
+		b.WriteString("  ---\n")
+		fmt.Fprintf(&b, "  message: %q\n", result.Message)
+		if len(result.Trace) != 0 {
+			b.WriteString("  stack: |\n")
+			for _, line := range strings.Split(strings.TrimRight(result.Trace, "\n"), "\n") {
+				b.WriteString("    " + line + "\n")
+			}
+		}
+		b.WriteString("  ...\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Tests   int               `xml:"tests,attr"`
+	Failed  int               `xml:"failures,attr"`
+	Time    string            `xml:"time,attr"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failed   int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+	duration time.Duration
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the report in the JUnit XML format. Every test file is a test suite.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	report := &junitTestSuites{}
+	suites := map[string]*junitTestSuite{}
+	var total time.Duration
+	for _, result := range r.Results {
+		suite, ok := suites[result.File]
+		if !ok {
+			suite = &junitTestSuite{Name: result.File}
+			suites[result.File] = suite
+			report.Suites = append(report.Suites, suite)
+		}
+		name := result.Name
+		if len(name) == 0 {
+			name = result.File
+		}
+		tc := &junitTestCase{Name: name, ClassName: result.File, Time: junitTime(result.Duration)}
+		if !result.Passed {
+			tc.Failure = &junitFailure{Message: result.Message, Text: result.Trace}
+			suite.Failed++
+			report.Failed++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		suite.duration += result.Duration
+		report.Tests++
+		total += result.Duration
+	}
+	for _, suite := range report.Suites {
+		suite.Time = junitTime(suite.duration)
+	}
+	report.Time = junitTime(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
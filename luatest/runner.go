@@ -0,0 +1,212 @@
+// Package luatest implements a runner for Lua unit tests.
+//
+// Tests live in files named *_test.lua. Every global function whose name starts
+// with "test" is a test case; a file without test functions is a single test
+// case. Each test case runs in a fresh LState which has the "luatest" module
+// preloaded, the configured host functions replaced by recording mocks and
+// os.time/os.clock bound to a fake clock.
+package luatest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// DefaultClock is the time the fake clock of every test case starts at.
+var DefaultClock = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// TestFileSuffix is the suffix of the files Discover looks for.
+const TestFileSuffix = "_test.lua"
+
+// Runner runs Lua test files.
+type Runner struct {
+	// Mocks are the names of host functions which are replaced by recording
+	// mocks in every test case, for example "alert" or "http.post".
+	Mocks []string
+	// Setup is called for every new LState before the test file is loaded.
+	// It can be used to register host functions and modules.
+	Setup func(L *lua.LState) error
+	// Options are passed to lua.NewState.
+	Options lua.Options
+	// Clock is the start time of the fake clock. DefaultClock is used if it is zero.
+	Clock time.Time
+	// Timeout limits the run time of a single test case if it is not zero.
+	Timeout time.Duration
+}
+
+// Result is the result of a single test case.
+type Result struct {
+	File     string
+	Name     string
+	Passed   bool
+	Message  string
+	Trace    string
+	Duration time.Duration
+}
+
+// Discover returns the sorted paths of all test files in the given files and directories.
+func Discover(paths ...string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(info.Name(), TestFileSuffix) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Run discovers the test files in the given paths and runs them.
+func (r *Runner) Run(paths ...string) (*Report, error) {
+	files, err := Discover(paths...)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	for _, file := range files {
+		report.Results = append(report.Results, r.RunFile(file)...)
+	}
+	return report, nil
+}
+
+// RunFile runs all test cases of a single file.
+func (r *Runner) RunFile(path string) []*Result {
+	names, result := r.listTests(path)
+	if result != nil {
+		return []*Result{result}
+	}
+	if len(names) == 0 {
+		// the file itself is the test
+		return []*Result{r.runTest(path, "")}
+	}
+	results := make([]*Result, 0, len(names))
+	for _, name := range names {
+		results = append(results, r.runTest(path, name))
+	}
+	return results
+}
+
+func (r *Runner) listTests(path string) ([]string, *Result) {
+	env, result := r.load(path, "")
+	if result != nil {
+		return nil, result
+	}
+	defer env.close()
+	type test struct {
+		name string
+		line int
+	}
+	var tests []test
+	env.L.G.Global.ForEach(func(k, v lua.LValue) {
+		name, ok := k.(lua.LString)
+		fn, ok2 := v.(*lua.LFunction)
+		if !ok || !ok2 || fn.IsG || !strings.HasPrefix(string(name), "test") {
+			return
+		}
+		tests = append(tests, test{string(name), fn.Proto.LineDefined})
+	})
+	sort.Slice(tests, func(i, j int) bool {
+		if tests[i].line != tests[j].line {
+			return tests[i].line < tests[j].line
+		}
+		return tests[i].name < tests[j].name
+	})
+	names := make([]string, len(tests))
+	for i, t := range tests {
+		names[i] = t.name
+	}
+	return names, nil
+}
+
+// load creates a new test environment and runs the file in it. The returned
+// result is not nil if loading the file failed.
+func (r *Runner) load(path, name string) (*testEnv, *Result) {
+	started := time.Now()
+	fail := func(env *testEnv, err error) (*testEnv, *Result) {
+		if env != nil {
+			env.close()
+		}
+		result := &Result{File: path, Name: name, Duration: time.Since(started)}
+		result.Message, result.Trace = errorMessage(err)
+		return nil, result
+	}
+	clock := r.Clock
+	if clock.IsZero() {
+		clock = DefaultClock
+	}
+	L := lua.NewState(r.Options)
+	env := newTestEnv(L, filepath.Dir(path), clock)
+	if r.Setup != nil {
+		if err := r.Setup(L); err != nil {
+			return fail(env, err)
+		}
+	}
+	if err := env.install(r.Mocks); err != nil {
+		return fail(env, err)
+	}
+	if r.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+		env.cancel = cancel
+		L.SetContext(ctx)
+	}
+	if err := L.DoFile(path); err != nil {
+		return fail(env, err)
+	}
+	return env, nil
+}
+
+func (r *Runner) runTest(path, name string) *Result {
+	started := time.Now()
+	env, result := r.load(path, name)
+	if result != nil {
+		return result
+	}
+	defer env.close()
+	result = &Result{File: path, Name: name, Passed: true}
+	if len(name) != 0 {
+		err := env.L.CallByParam(lua.P{
+			Fn:      env.L.GetGlobal(name),
+			NRet:    0,
+			Protect: true,
+		})
+		if err != nil {
+			result.Passed = false
+			result.Message, result.Trace = errorMessage(err)
+		}
+	}
+	result.Duration = time.Since(started)
+	return result
+}
+
+func errorMessage(err error) (string, string) {
+	if aerr, ok := err.(*lua.ApiError); ok {
+		if aerr.Cause != nil {
+			return aerr.Cause.Error(), aerr.StackTrace
+		}
+		return aerr.Object.String(), aerr.StackTrace
+	}
+	return err.Error(), ""
+}
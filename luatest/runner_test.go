@@ -0,0 +1,67 @@
+package luatest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestRunnerPass(t *testing.T) {
+	runner := &Runner{Mocks: []string{"alert"}}
+	report, err := runner.Run("testdata/pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 4 {
+		t.Fatalf("4 tests expected, but got %d", len(report.Results))
+	}
+	names := []string{"test_alert", "test_no_alert", "test_clock", "test_mock"}
+	for i, result := range report.Results {
+		if result.Name != names[i] {
+			t.Errorf("test %s expected, but got %s", names[i], result.Name)
+		}
+		if !result.Passed {
+			t.Errorf("%s failed: %s", result.Name, result.Message)
+		}
+	}
+}
+
+func TestRunnerFail(t *testing.T) {
+	report, err := (&Runner{}).Run("testdata/fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 3 || report.Failed() != 2 {
+		t.Fatalf("3 tests with 2 failures expected, but got %d with %d", len(report.Results), report.Failed())
+	}
+	if result := report.Results[0]; result.Name != "test_fails" || !strings.Contains(result.Message, "fail_test.lua:2: boom") {
+		t.Errorf("unexpected result %v: %s", result.Name, result.Message)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteTAP(&buf); err != nil {
+		t.Fatal(err)
+	}
+	tap := buf.String()
+	for _, expected := range []string{"1..3\n", "not ok 1 - testdata/fail/fail_test.lua: test_fails\n", "ok 2 - ", "not ok 3 - testdata/fail/syntax_test.lua\n"} {
+		if !strings.Contains(tap, expected) {
+			t.Errorf("TAP output should contain %q:\n%s", expected, tap)
+		}
+	}
+
+	buf.Reset()
+	if err := report.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatal(err)
+	}
+	if suites.Tests != 3 || suites.Failed != 2 || len(suites.Suites) != 2 {
+		t.Errorf("unexpected JUnit report:\n%s", buf.String())
+	}
+	if failure := suites.Suites[0].Cases[0].Failure; failure == nil || !strings.Contains(failure.Message, "boom") {
+		t.Errorf("unexpected failure %v", failure)
+	}
+}
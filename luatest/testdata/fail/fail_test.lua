@@ -0,0 +1,6 @@
+function test_fails()
+  error("boom")
+end
+
+function test_passes()
+end
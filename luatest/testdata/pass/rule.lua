@@ -0,0 +1,9 @@
+local counts = {}
+
+function on_logline(event)
+  local key = event.src_ip
+  counts[key] = (counts[key] or 0) + 1
+  if counts[key] == 3 then
+    alert({ip = key, at = os.time(), tags = event.tags})
+  end
+end
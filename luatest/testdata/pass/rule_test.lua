@@ -0,0 +1,36 @@
+local luatest = require("luatest")
+require("rule")
+
+function test_alert()
+  luatest.advance(60)
+  assert(luatest.feed("on_logline", "events.json") == 4)
+  local calls = luatest.calls("alert")
+  assert(#calls == 1, "one alert expected")
+  local alert = calls[1][1]
+  assert(alert.ip == "10.0.0.1")
+  assert(alert.at == luatest.time())
+  assert(alert.tags[2] == "brute")
+end
+
+function test_no_alert()
+  assert(luatest.feed(on_logline, "events.jsonl") == 2)
+  assert(#luatest.calls("alert") == 0)
+end
+
+function test_clock()
+  assert(os.clock() == 0)
+  luatest.advance(1.5)
+  assert(os.clock() == 1.5)
+  luatest.settime(1000)
+  assert(os.time() == 1000 and os.clock() == 1.5)
+  luatest.settime(1000.75)
+  luatest.advance(0.5)
+  assert(os.time() == 1001 and os.clock() == 2)
+  assert(os.time({year = 2020, month = 1, day = 1, hour = 12}) ~= 1000)
+end
+
+function test_mock()
+  luatest.mock("http.post", function(url) return url == "ok" end)
+  assert(http.post("ok") == true)
+  assert(luatest.calls("http.post")[1].n == 1)
+end
@@ -0,0 +1,42 @@
+local testing = require("testing")
+local assert = testing.assert
+
+function test_equal()
+  assert.equal(1 + 1, 2)
+  assert.not_equal("a", "b")
+  assert.same({a = {1, 2}, b = "x"}, {b = "x", a = {1, 2}})
+  assert.not_same({1}, {2})
+  assert.truthy(0)
+  assert.falsy(nil)
+end
+
+function test_error()
+  local msg = assert.error(function() error("bad input: 42") end, "input: %d+")
+  assert.truthy(msg:find("assert_test.lua"))
+  assert.error(function() error({}) end)
+  assert.error(function() error("a.b") end, "a.b", true)
+end
+
+function test_spy()
+  local log = {}
+  function notify(msg) table.insert(log, msg) return #log end
+  local spy = testing.spy(_G, "notify")
+  assert.equal(notify("hi"), 1)
+  assert.called(spy, 1)
+  assert.called_with(spy, "hi")
+  testing.revert(spy)
+  assert.equal(notify("again"), 2)
+  assert.called(spy, 1)
+
+  local t = {get = function() return "real" end}
+  local stub = testing.stub(t, "get", "fake", 2)
+  local a, b = t.get(1)
+  assert.same({a, b}, {"fake", 2})
+  assert.same(testing.calls(stub)[1], {1, n = 1})
+  testing.revert_all()
+  assert.equal(t.get(), "real")
+end
+
+function test_snapshot()
+  testing.snapshot("alert", {ip = "10.0.0.1", tags = {"ssh", "brute"}, score = 7.5, ["x-y"] = true})
+end
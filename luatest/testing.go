@@ -0,0 +1,574 @@
+package luatest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/pm"
+)
+
+// TestingModuleName is the name of the assertion module preloaded by the runner.
+const TestingModuleName = "testing"
+
+// UpdateSnapshots makes testing.snapshot overwrite golden files instead of comparing them.
+var UpdateSnapshots = false
+
+// SnapshotDir is the directory next to the test file golden files are stored in.
+const SnapshotDir = "__snapshots__"
+
+// Loader is the loader of the testing module:
+//
+//	L.PreloadModule("testing", luatest.Loader)
+//
+// Failed assertions raise errors prefixed with the position of the caller.
+func Loader(L *lua.LState) int {
+	tm := &testingModule{spies: make(map[*lua.LFunction]*spy)}
+	mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"spy":        tm.luaSpy,
+		"stub":       tm.luaStub,
+		"calls":      tm.luaCalls,
+		"revert":     tm.luaRevert,
+		"revert_all": tm.luaRevertAll,
+		"snapshot":   tm.luaSnapshot,
+		"serialize":  testingSerialize,
+	})
+	L.SetField(mod, "assert", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"equal":       assertEqual,
+		"not_equal":   assertNotEqual,
+		"same":        assertSame,
+		"not_same":    assertNotSame,
+		"truthy":      assertTruthy,
+		"falsy":       assertFalsy,
+		"is_nil":      assertIsNil,
+		"not_nil":     assertNotNil,
+		"error":       assertError,
+		"called":      tm.assertCalled,
+		"called_with": tm.assertCalledWith,
+		"fail":        assertFail,
+	}))
+	L.Push(mod)
+	return 1
+}
+
+// fail raises an assertion error at the position of the Lua function calling the assertion.
+// msgIdx is the index of the optional user message.
+func fail(L *lua.LState, msgIdx int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if msgIdx > 0 {
+		if msg, ok := L.Get(msgIdx).(lua.LString); ok {
+			message = string(msg) + ": " + message
+		}
+	}
+	L.Error(lua.LString(L.Where(1)+" "+message), 0)
+}
+
+/* assertions {{{ */
+
+func assertEqual(L *lua.LState) int {
+	actual, expected := L.CheckAny(1), L.CheckAny(2)
+	if !L.Equal(actual, expected) {
+		fail(L, 3, "expected %s, got %s", reprValue(expected), reprValue(actual))
+	}
+	return 0
+}
+
+func assertNotEqual(L *lua.LState) int {
+	actual, expected := L.CheckAny(1), L.CheckAny(2)
+	if L.Equal(actual, expected) {
+		fail(L, 3, "values should differ, got %s", reprValue(actual))
+	}
+	return 0
+}
+
+func assertSame(L *lua.LState) int {
+	diffs := diffValues(L.CheckAny(1), L.CheckAny(2))
+	if len(diffs) > 0 {
+		fail(L, 3, "values differ:\n\t%s", strings.Join(diffs, "\n\t"))
+	}
+	return 0
+}
+
+func assertNotSame(L *lua.LState) int {
+	if len(diffValues(L.CheckAny(1), L.CheckAny(2))) == 0 {
+		fail(L, 3, "values should differ, got %s", reprValue(L.Get(1)))
+	}
+	return 0
+}
+
+func assertTruthy(L *lua.LState) int {
+	if !lua.LVAsBool(L.Get(1)) {
+		fail(L, 2, "expected a truthy value, got %s", reprValue(L.Get(1)))
+	}
+	return 0
+}
+
+func assertFalsy(L *lua.LState) int {
+	if lua.LVAsBool(L.Get(1)) {
+		fail(L, 2, "expected a falsy value, got %s", reprValue(L.Get(1)))
+	}
+	return 0
+}
+
+func assertIsNil(L *lua.LState) int {
+	if L.Get(1) != lua.LNil {
+		fail(L, 2, "expected nil, got %s", reprValue(L.Get(1)))
+	}
+	return 0
+}
+
+func assertNotNil(L *lua.LState) int {
+	if L.Get(1) == lua.LNil {
+		fail(L, 2, "expected a non-nil value")
+	}
+	return 0
+}
+
+func assertFail(L *lua.LState) int {
+	fail(L, 0, "%s", L.OptString(1, "failed"))
+	return 0
+}
+
+// assert.error(fn, [pattern [, plain]]) calls fn and checks that it raises an error
+// whose message matches the pattern. It returns the error message.
+func assertError(L *lua.LState) int {
+	fn := L.CheckFunction(1)
+	pattern, hasPattern := L.Get(2).(lua.LString)
+	plain := lua.LVAsBool(L.Get(3))
+	L.Push(fn)
+	err := L.PCall(0, 0, nil)
+	if err == nil {
+		fail(L, 0, "error expected")
+	}
+	message := err.Error()
+	if aerr, ok := err.(*lua.ApiError); ok {
+		message = lua.LVAsString(aerr.Object)
+		if aerr.Object.Type() != lua.LTString && aerr.Object.Type() != lua.LTNumber {
+			message = aerr.Object.String()
+		}
+	}
+	if hasPattern {
+		matched := false
+		if plain {
+			matched = strings.Contains(message, string(pattern))
+		} else {
+			mds, perr := pm.Find(string(pattern), []byte(message), 0, 1)
+			if perr != nil {
+				L.RaiseError("%s", perr.Error())
+			}
+			matched = len(mds) > 0
+		}
+		if !matched {
+			fail(L, 0, "error %q does not match %q", message, string(pattern))
+		}
+	}
+	L.Push(lua.LString(message))
+	return 1
+}
+
+/* }}} */
+
+/* deep comparison {{{ */
+
+func reprValue(lv lua.LValue) string {
+	switch v := lv.(type) {
+	case lua.LString:
+		return strconv.Quote(string(v))
+	case *lua.LTable:
+		s, err := serialize(v, "", " ")
+		if err != nil || len(s) > 80 {
+			return v.String()
+		}
+		return s
+	}
+	return lv.String()
+}
+
+func keyPath(path string, key lua.LValue) string {
+	if s, ok := key.(lua.LString); ok && isIdentifier(string(s)) {
+		if len(path) == 0 {
+			return string(s)
+		}
+		return path + "." + string(s)
+	}
+	return path + "[" + reprValue(key) + "]"
+}
+
+// diffValues compares two values recursively and returns the differences.
+func diffValues(actual, expected lua.LValue) []string {
+	var diffs []string
+	seen := map[[2]*lua.LTable]bool{}
+	var diff func(path string, actual, expected lua.LValue)
+	diff = func(path string, actual, expected lua.LValue) {
+		at, ok1 := actual.(*lua.LTable)
+		et, ok2 := expected.(*lua.LTable)
+		if !ok1 || !ok2 {
+			if actual.Type() != expected.Type() || actual.String() != expected.String() {
+				where := path
+				if len(where) == 0 {
+					where = "value"
+				}
+				switch {
+				case actual == lua.LNil:
+					diffs = append(diffs, fmt.Sprintf("%s: missing, expected %s", where, reprValue(expected)))
+				case expected == lua.LNil:
+					diffs = append(diffs, fmt.Sprintf("%s: unexpected %s", where, reprValue(actual)))
+				default:
+					diffs = append(diffs, fmt.Sprintf("%s: expected %s, got %s", where, reprValue(expected), reprValue(actual)))
+				}
+			}
+			return
+		}
+		pair := [2]*lua.LTable{at, et}
+		if at == et || seen[pair] {
+			return
+		}
+		seen[pair] = true
+		keys := sortedKeys(et)
+		for _, key := range sortedKeys(at) {
+			if et.RawGet(key) == lua.LNil {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			diff(keyPath(path, key), at.RawGet(key), et.RawGet(key))
+		}
+	}
+	diff("", actual, expected)
+	return diffs
+}
+
+func sortedKeys(tb *lua.LTable) []lua.LValue {
+	var keys []lua.LValue
+	tb.ForEach(func(k, v lua.LValue) {
+		keys = append(keys, k)
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if ri, rj := keyRank(ki), keyRank(kj); ri != rj {
+			return ri < rj
+		}
+		if ni, ok := ki.(lua.LNumber); ok {
+			return ni < kj.(lua.LNumber)
+		}
+		return ki.String() < kj.String()
+	})
+	return keys
+}
+
+// keyRank orders numbers before strings before other keys.
+func keyRank(key lua.LValue) int {
+	switch key.Type() {
+	case lua.LTNumber:
+		return 0
+	case lua.LTString:
+		return 1
+	}
+	return 2 + int(key.Type())
+}
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	switch s {
+	case "and", "break", "do", "else", "elseif", "end", "false", "for", "function", "goto", "if", "in",
+		"local", "nil", "not", "or", "repeat", "return", "then", "true", "until", "while":
+		return false
+	}
+	return true
+}
+
+/* }}} */
+
+/* serialization {{{ */
+
+// serialize returns a deterministic Lua literal for the value. Tables are written one
+// entry per line indented by indent, or on a single line if indent is a single space.
+// Functions, userdata and threads are written as strings such as "<function>".
+func serialize(lv lua.LValue, prefix, indent string) (string, error) {
+	var b strings.Builder
+	var write func(lv lua.LValue, prefix string, stack []*lua.LTable) error
+	write = func(lv lua.LValue, prefix string, stack []*lua.LTable) error {
+		switch v := lv.(type) {
+		case lua.LString:
+			b.WriteString(strconv.Quote(string(v)))
+		case lua.LNumber, lua.LBool, *lua.LNilType:
+			b.WriteString(lv.String())
+		case *lua.LTable:
+			for _, tb := range stack {
+				if tb == v {
+					return fmt.Errorf("can not serialize a table with cycles")
+				}
+			}
+			stack = append(stack, v)
+			keys := sortedKeys(v)
+			if len(keys) == 0 {
+				b.WriteString("{}")
+				return nil
+			}
+			inline := indent == " "
+			b.WriteString("{")
+			n := v.Len()
+			for i, key := range keys {
+				if inline {
+					b.WriteString(" ")
+				} else {
+					b.WriteString("\n" + prefix + indent)
+				}
+				if num, ok := key.(lua.LNumber); !ok || float64(num) != float64(i+1) || i >= n {
+					if s, ok := key.(lua.LString); ok && isIdentifier(string(s)) {
+						b.WriteString(string(s))
+					} else {
+						b.WriteString("[")
+						if err := write(key, prefix+indent, stack); err != nil {
+							return err
+						}
+						b.WriteString("]")
+					}
+					b.WriteString(" = ")
+				}
+				if err := write(v.RawGet(key), prefix+indent, stack); err != nil {
+					return err
+				}
+				if i < len(keys)-1 || !inline {
+					b.WriteString(",")
+				}
+			}
+			if inline {
+				b.WriteString(" }")
+			} else {
+				b.WriteString("\n" + prefix + "}")
+			}
+		default:
+			b.WriteString(strconv.Quote("<" + lv.Type().String() + ">"))
+		}
+		return nil
+	}
+	err := write(lv, prefix, nil)
+	return b.String(), err
+}
+
+func testingSerialize(L *lua.LState) int {
+	s, err := serialize(L.CheckAny(1), "", "  ")
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.Push(lua.LString(s))
+	return 1
+}
+
+// testing.snapshot(name, value) compares the value with the golden file
+// __snapshots__/<name>.snap next to the calling file. Missing golden files are created.
+func (tm *testingModule) luaSnapshot(L *lua.LState) int {
+	name := L.CheckString(1)
+	value := L.CheckAny(2)
+	data, err := serialize(value, "", "  ")
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	dir := "."
+	if dbg, ok := L.GetStack(1); ok {
+		if _, err := L.GetInfo("S", dbg, lua.LNil); err == nil && !strings.HasPrefix(dbg.Source, "<") {
+			dir = filepath.Dir(dbg.Source)
+		}
+	}
+	path := filepath.Join(dir, SnapshotDir, name+".snap")
+	golden, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || UpdateSnapshots {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte(data+"\n"), 0644); err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		return 0
+	} else if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	if strings.TrimRight(string(golden), "\n") == data {
+		return 0
+	}
+	fn, err := L.LoadString("return " + string(golden))
+	if err != nil {
+		fail(L, 0, "snapshot %s does not match %s", name, path)
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		fail(L, 0, "snapshot %s does not match %s", name, path)
+	}
+	expected := L.Get(-1)
+	L.Pop(1)
+	diffs := diffValues(value, expected)
+	if len(diffs) == 0 {
+		// only the formatting differs
+		diffs = []string{"formatting differs"}
+	}
+	fail(L, 0, "snapshot %s does not match %s:\n\t%s", name, path, strings.Join(diffs, "\n\t"))
+	return 0
+}
+
+/* }}} */
+
+/* spies {{{ */
+
+type spy struct {
+	fn       *lua.LFunction
+	target   *lua.LTable
+	key      lua.LValue
+	original lua.LValue
+	calls    *lua.LTable
+}
+
+type testingModule struct {
+	spies map[*lua.LFunction]*spy
+	order []*spy
+}
+
+// newRecorder returns a function which appends a table of its arguments to the
+// table returned by calls, then calls impl or, if impl is nil, returns values.
+func newRecorder(L *lua.LState, calls func() *lua.LTable, impl lua.LValue, values []lua.LValue) *lua.LFunction {
+	return L.NewFunction(func(L *lua.LState) int {
+		top := L.GetTop()
+		args := L.CreateTable(top, 1)
+		for i := 1; i <= top; i++ {
+			args.RawSetInt(i, L.Get(i))
+		}
+		args.RawSetString("n", lua.LNumber(top))
+		calls().Append(args)
+		if impl != nil && impl != lua.LNil {
+			L.Insert(impl, 1)
+			L.Call(top, lua.MultRet)
+			return L.GetTop()
+		}
+		for _, value := range values {
+			L.Push(value)
+		}
+		return len(values)
+	})
+}
+
+// newSpy returns a function which records its arguments and then calls impl or returns values.
+func (tm *testingModule) newSpy(L *lua.LState, impl lua.LValue, values []lua.LValue) *spy {
+	s := &spy{calls: L.NewTable()}
+	s.fn = newRecorder(L, func() *lua.LTable { return s.calls }, impl, values)
+	tm.spies[s.fn] = s
+	tm.order = append(tm.order, s)
+	return s
+}
+
+func (tm *testingModule) install(L *lua.LState, s *spy, target *lua.LTable, key lua.LValue) {
+	s.target = target
+	s.key = key
+	s.original = L.GetTable(target, key)
+	L.SetTable(target, key, s.fn)
+}
+
+func (tm *testingModule) checkSpy(L *lua.LState, n int) *spy {
+	fn := L.CheckFunction(n)
+	s, ok := tm.spies[fn]
+	if !ok {
+		L.ArgError(n, "spy expected")
+	}
+	return s
+}
+
+// testing.spy(fn) or testing.spy(table, key) wraps a function and records its calls.
+func (tm *testingModule) luaSpy(L *lua.LState) int {
+	if tb, ok := L.Get(1).(*lua.LTable); ok {
+		key := L.CheckAny(2)
+		original := L.GetTable(tb, key)
+		if _, ok := original.(*lua.LFunction); !ok {
+			L.ArgError(2, "function expected, got "+original.Type().String())
+		}
+		s := tm.newSpy(L, original, nil)
+		tm.install(L, s, tb, key)
+		L.Push(s.fn)
+		return 1
+	}
+	L.Push(tm.newSpy(L, L.CheckFunction(1), nil).fn)
+	return 1
+}
+
+// testing.stub(table, key, ...) replaces table[key] by a function returning the given values.
+func (tm *testingModule) luaStub(L *lua.LState) int {
+	tb := L.CheckTable(1)
+	key := L.CheckAny(2)
+	values := make([]lua.LValue, 0, L.GetTop()-2)
+	for i := 3; i <= L.GetTop(); i++ {
+		values = append(values, L.Get(i))
+	}
+	s := tm.newSpy(L, nil, values)
+	tm.install(L, s, tb, key)
+	L.Push(s.fn)
+	return 1
+}
+
+func (tm *testingModule) luaCalls(L *lua.LState) int {
+	L.Push(tm.checkSpy(L, 1).calls)
+	return 1
+}
+
+func (tm *testingModule) revert(L *lua.LState, s *spy) {
+	if s.target != nil {
+		L.SetTable(s.target, s.key, s.original)
+		s.target = nil
+	}
+}
+
+func (tm *testingModule) luaRevert(L *lua.LState) int {
+	tm.revert(L, tm.checkSpy(L, 1))
+	return 0
+}
+
+func (tm *testingModule) luaRevertAll(L *lua.LState) int {
+	for i := len(tm.order) - 1; i >= 0; i-- {
+		tm.revert(L, tm.order[i])
+	}
+	return 0
+}
+
+func (tm *testingModule) assertCalled(L *lua.LState) int {
+	s := tm.checkSpy(L, 1)
+	count := s.calls.Len()
+	if L.Get(2) == lua.LNil {
+		if count == 0 {
+			fail(L, 0, "expected to be called")
+		}
+		return 0
+	}
+	if expected := L.CheckInt(2); count != expected {
+		fail(L, 3, "expected to be called %d times, got %d", expected, count)
+	}
+	return 0
+}
+
+func (tm *testingModule) assertCalledWith(L *lua.LState) int {
+	s := tm.checkSpy(L, 1)
+	top := L.GetTop()
+	expected := L.CreateTable(top-1, 1)
+	for i := 2; i <= top; i++ {
+		expected.RawSetInt(i-1, L.Get(i))
+	}
+	expected.RawSetString("n", lua.LNumber(top-1))
+	for i := 1; i <= s.calls.Len(); i++ {
+		if len(diffValues(s.calls.RawGetInt(i), expected)) == 0 {
+			return 0
+		}
+	}
+	args, _ := serialize(expected, "", " ")
+	fail(L, 0, "no call with arguments %s among %d calls", args, s.calls.Len())
+	return 0
+}
+
+/* }}} */
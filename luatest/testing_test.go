@@ -0,0 +1,64 @@
+package luatest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestTestingModule(t *testing.T) {
+	report, err := (&Runner{}).Run("testdata/testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range report.Results {
+		if !result.Passed {
+			t.Errorf("%s failed: %s", result.Name, result.Message)
+		}
+	}
+	if len(report.Results) != 4 {
+		t.Errorf("4 tests expected, but got %d", len(report.Results))
+	}
+}
+
+func TestTestingFailures(t *testing.T) {
+	cases := []struct {
+		script  string
+		message string
+	}{
+		{`assert.equal(1, 2, "sum")`, `<string>:1: sum: expected 2, got 1`},
+		{`assert.same({a = {1, 2}}, {a = {1, 3}, b = "x"})`, "<string>:1: values differ:\n\ta[2]: expected 3, got 2\n\tb: missing, expected \"x\""},
+		{`assert.error(function() end)`, `<string>:1: error expected`},
+		{`assert.error(function() error("x", 0) end, "y")`, `<string>:1: error "x" does not match "y"`},
+		{"local s = testing.spy(print)\n\nassert.called(s)", `<string>:3: expected to be called`},
+	}
+	for _, c := range cases {
+		L := lua.NewState()
+		L.PreloadModule(TestingModuleName, Loader)
+		err := L.DoString(`testing = require("testing"); assert = testing.assert; ` + c.script)
+		if err == nil {
+			t.Errorf("%s should fail", c.script)
+		} else if msg := err.(*lua.ApiError).Object.String(); !strings.HasPrefix(msg, c.message) {
+			t.Errorf("%q expected, but got %q", c.message, msg)
+		}
+		L.Close()
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoString(`t = {1, 2, x = {y = "z"}, [10] = false, ["a b"] = 1}; t.self = t`); err != nil {
+		t.Fatal(err)
+	}
+	tb := L.GetGlobal("t").(*lua.LTable)
+	if _, err := serialize(tb, "", "  "); err == nil {
+		t.Error("cycles should be detected")
+	}
+	tb.RawSetString("self", lua.LNil)
+	s, _ := serialize(tb, "", " ")
+	if expected := `{ 1, 2, [10] = false, ["a b"] = 1, x = { y = "z" } }`; s != expected {
+		t.Errorf("%s expected, but got %s", expected, s)
+	}
+}
@@ -0,0 +1,424 @@
+package lua
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// MarshalError is returned by Marshal and Unmarshal. Path is the Lua style path of the
+// offending value, for example `alert.meta.asset_ip`.
+type MarshalError struct {
+	Path    string
+	Message string
+}
+
+func (e *MarshalError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+func marshalErrorf(path string, format string, args ...interface{}) error {
+	return &MarshalError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// marshalRoot returns the root of error paths: the name of the Go type with a
+// lower case first letter, e.g. `alert` for `Alert`, or `value` for unnamed types.
+func marshalRoot(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := t.Name()
+	if len(name) == 0 {
+		return "value"
+	}
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+func fieldPath(path, name string) string {
+	return path + luaKeyPath(LString(name))
+}
+
+/* Unmarshal {{{ */
+
+// Unmarshal stores the Lua value lv in the value pointed to by v.
+//
+// Tables are stored in structs, slices, arrays and maps. Struct fields are looked up
+// by the name given in a `lua:"name"` tag or by their Go name; fields tagged with
+// `lua:"-"` are ignored. A time.Time is read from an RFC 3339 string or from a
+// number of seconds since the Unix epoch, types implementing
+// encoding.TextUnmarshaler are read from strings. Struct fields which are
+// missing from the table are left unchanged.
+func Unmarshal(lv LValue, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("lua: Unmarshal requires a non-nil pointer, got %T", v)
+	}
+	return unmarshalValue(lv, rv.Elem(), marshalRoot(rv.Type()))
+}
+
+func unmarshalValue(lv LValue, rv reflect.Value, path string) error {
+	t := rv.Type()
+	if lv == LNil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			rv.Set(reflect.Zero(t))
+		}
+		return nil
+	}
+	if t == lvalueType {
+		rv.Set(reflect.ValueOf(&lv).Elem())
+		return nil
+	}
+	if ud, ok := lv.(*LUserData); ok {
+		if value := reflect.ValueOf(ud.Value); value.IsValid() && value.Type().AssignableTo(t) {
+			rv.Set(value)
+			return nil
+		}
+	}
+	if t == timeType {
+		tm, err := unmarshalTime(lv)
+		if err != nil {
+			return marshalErrorf(path, "%s", err.Error())
+		}
+		rv.Set(reflect.ValueOf(tm))
+		return nil
+	}
+	if t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(textUnmarshalerType) && rv.CanAddr() {
+		s, ok := lv.(LString)
+		if !ok {
+			return marshalErrorf(path, "string expected, got %v", lv.Type())
+		}
+		if err := rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return marshalErrorf(path, "%s", err.Error())
+		}
+		return nil
+	}
+
+	mismatch := func(expected string) error {
+		return marshalErrorf(path, "%s expected, got %v", expected, lv.Type())
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := unmarshalValue(lv, elem.Elem(), path); err != nil {
+			return err
+		}
+		rv.Set(elem)
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return marshalErrorf(path, "cannot unmarshal %v into %v", lv.Type(), t)
+		}
+		if v := luaToInterface(lv); v != nil {
+			rv.Set(reflect.ValueOf(v))
+		} else {
+			rv.Set(reflect.Zero(t))
+		}
+	case reflect.Bool:
+		b, ok := lv.(LBool)
+		if !ok {
+			return mismatch("boolean")
+		}
+		rv.SetBool(bool(b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := lv.(LNumber)
+		if !ok {
+			return mismatch("number")
+		}
+		v, err := numberToGo(n, t)
+		if err != nil {
+			return marshalErrorf(path, "%s", err.Error())
+		}
+		rv.Set(v)
+	case reflect.Float32, reflect.Float64:
+		n, ok := lv.(LNumber)
+		if !ok {
+			return mismatch("number")
+		}
+		rv.SetFloat(float64(n))
+	case reflect.String:
+		s, ok := lv.(LString)
+		if !ok {
+			return mismatch("string")
+		}
+		rv.SetString(string(s))
+	case reflect.Struct:
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return mismatch("table")
+		}
+		for _, field := range marshalFields(t) {
+			value := tb.RawGetString(field.name)
+			if value == LNil {
+				continue
+			}
+			if err := unmarshalValue(value, rv.FieldByIndex(field.index), fieldPath(path, field.name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if s, ok := lv.(LString); ok && t.Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes([]byte(s))
+			return nil
+		}
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return mismatch("table")
+		}
+		n := tb.Len()
+		slice := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			if err := unmarshalValue(tb.RawGetInt(i+1), slice.Index(i), fmt.Sprintf("%s[%d]", path, i+1)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+	case reflect.Array:
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return mismatch("table")
+		}
+		if n := tb.Len(); n > rv.Len() {
+			return marshalErrorf(path, "at most %d elements expected, got %d", rv.Len(), n)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := unmarshalValue(tb.RawGetInt(i+1), rv.Index(i), fmt.Sprintf("%s[%d]", path, i+1)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return mismatch("table")
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(t))
+		}
+		var err error
+		tb.ForEach(func(key, value LValue) {
+			if err != nil {
+				return
+			}
+			elemPath := path + luaKeyPath(key)
+			mkey := reflect.New(t.Key()).Elem()
+			if err = unmarshalValue(key, mkey, elemPath); err != nil {
+				return
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err = unmarshalValue(value, elem, elemPath); err != nil {
+				return
+			}
+			rv.SetMapIndex(mkey, elem)
+		})
+		return err
+	default:
+		return marshalErrorf(path, "cannot unmarshal %v into %v", lv.Type(), t)
+	}
+	return nil
+}
+
+func unmarshalTime(lv LValue) (time.Time, error) {
+	switch v := lv.(type) {
+	case LNumber:
+		sec, frac := math.Modf(float64(v))
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))), nil
+	case LString:
+		return time.Parse(time.RFC3339Nano, string(v))
+	}
+	return time.Time{}, fmt.Errorf("string or number expected, got %v", lv.Type())
+}
+
+/* }}} */
+
+/* Marshal {{{ */
+
+type marshalField struct {
+	name      string
+	index     []int
+	omitempty bool
+}
+
+func marshalFields(t reflect.Type) []marshalField {
+	indices := make(map[string][]int)
+	collectFields(t, nil, indices)
+	fields := make([]marshalField, 0, len(indices))
+	for name, index := range indices {
+		_, opts := parseLuaTag(t.FieldByIndex(index))
+		field := marshalField{name: name, index: index}
+		for _, opt := range opts {
+			if opt == "omitempty" {
+				field.omitempty = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	// marshal fields in declaration order so errors are deterministic
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return fields
+}
+
+// Marshal converts a Go value to a Lua value.
+//
+// Structs, slices, arrays and maps become tables, pointers are followed and nil values
+// become nil. Struct fields honour the same `lua:"name"` tags as Unmarshal;
+// fields tagged with `lua:"name,omitempty"` are left out if they have a zero value.
+// A time.Time becomes an RFC 3339 string and types implementing encoding.TextMarshaler
+// become strings. LValues are returned unchanged.
+func Marshal(L *LState, v interface{}) (LValue, error) {
+	if v == nil {
+		return LNil, nil
+	}
+	rv := reflect.ValueOf(v)
+	return marshalValue(L, rv, marshalRoot(rv.Type()), nil)
+}
+
+// marshalRef identifies a pointer, a map or a slice on the path being marshaled.
+// The type and the length tell apart a struct and its first field, or a slice
+// and its prefix, which share the address.
+type marshalRef struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// visit adds a reference to the path being marshaled, failing if it is already on it.
+func visit(visiting []marshalRef, ref marshalRef, path string) ([]marshalRef, error) {
+	for _, r := range visiting {
+		if r == ref {
+			return nil, marshalErrorf(path, "cycle detected")
+		}
+	}
+	return append(visiting, ref), nil
+}
+
+func marshalValue(L *LState, rv reflect.Value, path string, visiting []marshalRef) (LValue, error) {
+	if !rv.IsValid() {
+		return LNil, nil
+	}
+	t := rv.Type()
+	if t.Implements(lvalueType) {
+		if (rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr) && rv.IsNil() {
+			return LNil, nil
+		}
+		return rv.Interface().(LValue), nil
+	}
+	if t == timeType {
+		return LString(rv.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+	}
+	if t.Implements(textMarshalerType) && !(t.Kind() == reflect.Ptr && rv.IsNil()) {
+		text, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return LNil, marshalErrorf(path, "%s", err.Error())
+		}
+		return LString(text), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return LBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return LNumber(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return LNumber(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return LNumber(rv.Float()), nil
+	case reflect.String:
+		return LString(rv.String()), nil
+	case reflect.Interface:
+		if rv.IsNil() {
+			return LNil, nil
+		}
+		return marshalValue(L, rv.Elem(), path, visiting)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return LNil, nil
+		}
+		visiting, err := visit(visiting, marshalRef{rv.Pointer(), 0, t}, path)
+		if err != nil {
+			return LNil, err
+		}
+		return marshalValue(L, rv.Elem(), path, visiting)
+	case reflect.Struct:
+		tb := L.NewTable()
+		for _, field := range marshalFields(t) {
+			fv := rv.FieldByIndex(field.index)
+			if field.omitempty && fv.IsZero() {
+				continue
+			}
+			value, err := marshalValue(L, fv, fieldPath(path, field.name), visiting)
+			if err != nil {
+				return LNil, err
+			}
+			tb.RawSetString(field.name, value)
+		}
+		return tb, nil
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice {
+			if rv.IsNil() {
+				return LNil, nil
+			}
+			if t.Elem().Kind() == reflect.Uint8 {
+				return LString(rv.Bytes()), nil
+			}
+			var err error
+			if visiting, err = visit(visiting, marshalRef{rv.Pointer(), rv.Len(), t}, path); err != nil {
+				return LNil, err
+			}
+		}
+		tb := L.CreateTable(rv.Len(), 0)
+		for i := 0; i < rv.Len(); i++ {
+			value, err := marshalValue(L, rv.Index(i), fmt.Sprintf("%s[%d]", path, i+1), visiting)
+			if err != nil {
+				return LNil, err
+			}
+			tb.RawSetInt(i+1, value)
+		}
+		return tb, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return LNil, nil
+		}
+		visiting, err := visit(visiting, marshalRef{rv.Pointer(), 0, t}, path)
+		if err != nil {
+			return LNil, err
+		}
+		tb := L.CreateTable(0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := marshalValue(L, iter.Key(), path, visiting)
+			if err != nil {
+				return LNil, err
+			}
+			if key == LNil {
+				return LNil, marshalErrorf(path, "nil map key")
+			}
+			value, err := marshalValue(L, iter.Value(), path+luaKeyPath(key), visiting)
+			if err != nil {
+				return LNil, err
+			}
+			tb.RawSet(key, value)
+		}
+		return tb, nil
+	}
+	return LNil, marshalErrorf(path, "cannot marshal %v", t)
+}
+
+/* }}} */
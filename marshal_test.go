@@ -0,0 +1,149 @@
+package lua
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+type marshalMeta struct {
+	AssetIP net.IP `lua:"asset_ip"`
+	Port    uint16 `lua:"port,omitempty"`
+}
+
+type Alert struct {
+	Name    string            `lua:"name"`
+	Score   float64           `lua:"score"`
+	Tags    []string          `lua:"tags,omitempty"`
+	Meta    *marshalMeta      `lua:"meta"`
+	Labels  map[string]int    `lua:"labels,omitempty"`
+	At      time.Time         `lua:"at"`
+	Raw     LValue            `lua:"raw,omitempty"`
+	Extra   interface{}       `lua:"extra,omitempty"`
+	Ignored string            `lua:"-"`
+	Counts  [2]int            `lua:"counts"`
+	ByID    map[int]*struct{} `lua:"by_id,omitempty"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  alert = {
+	    name = "brute", score = 7.5, tags = {"ssh", "login"},
+	    meta = {asset_ip = "10.0.0.1", port = 22},
+	    labels = {a = 1}, at = "2020-01-02T03:04:05Z",
+	    raw = function() end, extra = {1, 2}, Ignored = "x", counts = {1, 2},
+	    by_id = {[3] = {}},
+	  }
+	`)
+	var alert Alert
+	errorIfNotNil(t, Unmarshal(L.GetGlobal("alert"), &alert))
+	errorIfNotEqual(t, "brute", alert.Name)
+	errorIfNotEqual(t, 7.5, alert.Score)
+	errorIfNotEqual(t, 2, len(alert.Tags))
+	errorIfNotEqual(t, "10.0.0.1", alert.Meta.AssetIP.String())
+	errorIfNotEqual(t, uint16(22), alert.Meta.Port)
+	errorIfNotEqual(t, 1, alert.Labels["a"])
+	errorIfNotEqual(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), alert.At)
+	errorIfNotEqual(t, LTFunction, alert.Raw.Type())
+	errorIfNotEqual(t, 2, len(alert.Extra.([]interface{})))
+	errorIfNotEqual(t, "", alert.Ignored)
+	errorIfNotEqual(t, [2]int{1, 2}, alert.Counts)
+	_, ok := alert.ByID[3]
+	errorIfFalse(t, ok, "by_id[3] should be set")
+
+	cases := []struct {
+		script string
+		err    string
+	}{
+		{`return {meta = {asset_ip = 10}}`, "alert.meta.asset_ip: string expected, got number"},
+		{`return {meta = {asset_ip = "x"}}`, "alert.meta.asset_ip: invalid IP address: x"},
+		{`return {tags = {"a", {}}}`, "alert.tags[2]: string expected, got table"},
+		{`return {labels = {["a b"] = "x"}}`, `alert.labels["a b"]: number expected, got string`},
+		{`return {meta = {port = 1.5}}`, "alert.meta.port: non-negative integer expected, got 1.5"},
+		{`return {meta = {port = 70000}}`, "alert.meta.port: 70000 overflows uint16"},
+		{`return {meta = {port = 2^64}}`, "alert.meta.port: 1.8446744073709552e+19 overflows uint16"},
+		{`return {counts = {1e19}}`, "alert.counts[1]: 1e+19 overflows int"},
+		{`return {counts = {1, 2, 3}}`, "alert.counts: at most 2 elements expected, got 3"},
+		{`return "x"`, "alert: table expected, got string"},
+	}
+	for _, c := range cases {
+		errorIfNotNil(t, L.DoString(c.script))
+		var alert Alert
+		err := Unmarshal(L.Get(-1), &alert)
+		L.Pop(1)
+		if err == nil {
+			t.Errorf("%s should fail", c.script)
+			continue
+		}
+		errorIfNotEqual(t, c.err, err.Error())
+	}
+	errorIfNil(t, Unmarshal(LNil, alert))
+
+	var big struct{ N int64 }
+	errorIfNotNil(t, L.DoString(`return {N = -2^63}`))
+	errorIfNotNil(t, Unmarshal(L.Get(-1), &big))
+	errorIfNotEqual(t, int64(math.MinInt64), big.N)
+	errorIfNotNil(t, L.DoString(`return {N = 2^63}`))
+	errorIfNil(t, Unmarshal(L.Get(-1), &big))
+	L.Pop(2)
+
+	var iface interface{} = 1
+	errorIfNotNil(t, Unmarshal(L.NewUserData(), &iface))
+	errorIfFalse(t, iface == nil, "userdata without a value should unmarshal to nil")
+}
+
+func TestMarshal(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	alert := &Alert{
+		Name:   "brute",
+		Meta:   &marshalMeta{AssetIP: net.ParseIP("10.0.0.1")},
+		At:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Extra:  map[string]interface{}{"k": []int{1}},
+		Counts: [2]int{1, 2},
+	}
+	lv, err := Marshal(L, alert)
+	errorIfNotNil(t, err)
+	L.SetGlobal("alert", lv)
+	errorIfScriptFail(t, L, `
+	  assert(alert.name == "brute" and alert.score == 0)
+	  assert(alert.tags == nil and alert.labels == nil and alert.meta.port == nil)
+	  assert(alert.meta.asset_ip == "10.0.0.1")
+	  assert(alert.at == "2020-01-02T03:04:05Z")
+	  assert(alert.extra.k[1] == 1)
+	  assert(alert.counts[2] == 2)
+	  assert(alert.Ignored == nil)
+	`)
+
+	var back Alert
+	errorIfNotNil(t, Unmarshal(lv, &back))
+	errorIfNotEqual(t, alert.At, back.At)
+	errorIfNotEqual(t, "10.0.0.1", back.Meta.AssetIP.String())
+
+	type node struct {
+		Next *node
+		Fn   func()
+	}
+	n := &node{}
+	n.Next = n
+	_, err = Marshal(L, n)
+	errorIfNotEqual(t, "node.Next: cycle detected", err.Error())
+	_, err = Marshal(L, &node{Fn: func() {}})
+	errorIfNotEqual(t, "node.Fn: cannot marshal func()", err.Error())
+
+	m := map[string]interface{}{}
+	m["self"] = m
+	_, err = Marshal(L, m)
+	errorIfNotEqual(t, "value.self: cycle detected", err.Error())
+	s := []interface{}{nil, 1}
+	s[0] = s
+	_, err = Marshal(L, s)
+	errorIfNotEqual(t, "value[1]: cycle detected", err.Error())
+	// a prefix of a slice is not a cycle
+	s[0] = s[:0]
+	_, err = Marshal(L, s)
+	errorIfNotNil(t, err)
+}
@@ -2,7 +2,6 @@ package lua
 
 import (
 	"math"
-	"math/rand"
 )
 
 func OpenMath(L *LState) int {
@@ -183,23 +182,34 @@ func mathRad(L *LState) int {
 	return 1
 }
 
+// math.random follows Lua 5.4: random(m, n) returns an integer in [m, n] and
+// random(0) returns an integer of 53 random bits, as numbers are float64.
 func mathRandom(L *LState) int {
+	r := L.random()
+	v := r.Uint64()
+	var low, up int64
 	switch L.GetTop() {
 	case 0:
-		L.Push(LNumber(rand.Float64()))
+		L.Push(LNumber(randomFloat(v)))
+		return 1
 	case 1:
-		n := L.CheckInt(1)
-		L.Push(LNumber(rand.Intn(n) + 1))
+		low, up = 1, L.CheckInt64(1)
+		if up == 0 {
+			L.Push(LNumber(int64(v) >> 11))
+			return 1
+		}
 	default:
-		min := L.CheckInt(1)
-		max := L.CheckInt(2) + 1
-		L.Push(LNumber(rand.Intn(max-min) + min))
+		low, up = L.CheckInt64(1), L.CheckInt64(2)
+	}
+	if low > up {
+		L.ArgError(L.GetTop(), "interval is empty")
 	}
+	L.Push(LNumber(int64(randomProject(r, v, uint64(up)-uint64(low)) + uint64(low))))
 	return 1
 }
 
 func mathRandomseed(L *LState) int {
-	rand.Seed(L.CheckInt64(1))
+	L.random().Seed(uint64(L.CheckInt64(1)), uint64(L.OptInt64(2, 0)))
 	return 0
 }
 
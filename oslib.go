@@ -1,9 +1,9 @@
 package lua
 
 import (
-	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -47,6 +47,23 @@ func getBoolField(L *LState, tb *LTable, key string, v bool) bool {
 	return v
 }
 
+var locations sync.Map
+
+// loadLocation returns the IANA time zone with the given name, e.g. "Europe/Moscow".
+// The zones are read from the time zone database of the system unless the host
+// program imports time/tzdata.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
 func OpenOs(L *LState) int {
 	osmod := L.RegisterModule(OsLibName, osFuncs)
 	L.Push(osmod)
@@ -114,9 +131,17 @@ func osDate(L *LState) int {
 			cfmt = strings.TrimLeft(cfmt, "!")
 			isUTC = true
 		}
-		if L.GetTop() >= 2 {
+		if L.GetTop() >= 2 && L.Get(2) != LNil {
 			t = time.Unix(L.CheckInt64(2), 0)
 		}
+		zone := L.OptString(3, "")
+		if len(zone) != 0 {
+			loc, err := loadLocation(zone)
+			if err != nil {
+				L.ArgError(3, "unknown time zone '"+zone+"'")
+			}
+			t = t.In(loc)
+		}
 		if isUTC {
 			t = t.UTC()
 		}
@@ -129,9 +154,11 @@ func osDate(L *LState) int {
 			ret.RawSetString("min", LNumber(t.Minute()))
 			ret.RawSetString("sec", LNumber(t.Second()))
 			ret.RawSetString("wday", LNumber(t.Weekday()+1))
-			// TODO yday & dst
-			ret.RawSetString("yday", LNumber(0))
-			ret.RawSetString("isdst", LFalse)
+			ret.RawSetString("yday", LNumber(t.YearDay()))
+			ret.RawSetString("isdst", LBool(t.IsDST()))
+			if len(zone) != 0 && !isUTC {
+				ret.RawSetString("tz", LString(zone))
+			}
 			L.Push(ret)
 			return 1
 		}
@@ -151,7 +178,7 @@ func osGetEnv(L *LState) int {
 }
 
 func osRemove(L *LState) int {
-	err := os.Remove(L.CheckString(1))
+	err := L.fileSystem().Remove(L.CheckString(1))
 	if err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
@@ -163,7 +190,7 @@ func osRemove(L *LState) int {
 }
 
 func osRename(L *LState) int {
-	err := os.Rename(L.CheckString(1), L.CheckString(2))
+	err := L.fileSystem().Rename(L.CheckString(1), L.CheckString(2))
 	if err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
@@ -211,7 +238,14 @@ func osTime(L *LState) int {
 			month := getIntField(L, tbl, "month", -1)
 			year := getIntField(L, tbl, "year", -1)
 			isdst := getBoolField(L, tbl, "isdst", false)
-			t := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+			loc := time.Local
+			if zone, ok := tbl.RawGetString("tz").(LString); ok {
+				var err error
+				if loc, err = loadLocation(string(zone)); err != nil {
+					L.RaiseError("field 'tz' is not a valid time zone: '%s'", string(zone))
+				}
+			}
+			t := time.Date(year, time.Month(month), day, hour, min, sec, 0, loc)
 			// TODO dst
 			if false {
 				print(isdst)
@@ -223,12 +257,13 @@ func osTime(L *LState) int {
 }
 
 func osTmpname(L *LState) int {
-	file, err := ioutil.TempFile("", "")
+	fsys := L.fileSystem()
+	file, err := fsys.CreateTemp("", "")
 	if err != nil {
 		L.RaiseError("unable to generate a unique filename")
 	}
 	file.Close()
-	os.Remove(file.Name()) // ignore errors
+	fsys.Remove(file.Name()) // ignore errors
 	L.Push(LString(file.Name()))
 	return 1
 }
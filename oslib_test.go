@@ -2,6 +2,7 @@ package lua
 
 import (
 	"testing"
+	"time"
 )
 
 // correctly gc-ed. There was a bug in gopher lua where local vars were not being gc-ed in all circumstances.
@@ -31,3 +32,45 @@ func TestOsWrite(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestStrftime(t *testing.T) {
+	tm := time.Date(2021, 1, 3, 7, 5, 9, 0, time.UTC)
+	cases := map[string]string{
+		"%a %A %b %B %h":    "Sun Sunday Jan January Jan",
+		"%c":                "Sun Jan  3 07:05:09 2021",
+		"%C %y %Y %g %G":    "20 21 2021 20 2020",
+		"%d %e %j %m":       "03  3 003 01",
+		"%D %F %x":          "01/03/21 2021-01-03 01/03/21",
+		"%H %I %k %l %M %S": "07 07  7  7 05 09",
+		"%p %P %r":          "AM am 07:05:09 AM",
+		"%R %T %X":          "07:05 07:05:09 07:05:09",
+		"%s":                "1609657509",
+		"%u %w %U %W %V":    "7 0 01 00 53",
+		"%z %Z":             "+0000 UTC",
+		"%n%t%%":            "\n\t%",
+		"%Ey %Od %q %":      "21 03 %q %",
+	}
+	for cfmt, expected := range cases {
+		errorIfNotEqual(t, expected, strftime(tm, cfmt))
+	}
+	errorIfNotEqual(t, "12 PM 52 52", strftime(time.Date(2022, 12, 31, 12, 0, 0, 0, time.UTC), "%I %p %U %V"))
+}
+
+func TestOsDateTimeZone(t *testing.T) {
+	if _, err := loadLocation("Europe/Moscow"); err != nil {
+		t.Skip("no time zone database")
+	}
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(os.date("%H:%M %Z", 0, "Europe/Moscow") == "03:00 MSK")
+	  assert(os.date("!%H:%M", 0, "Europe/Moscow") == "00:00")
+	  local date = os.date("*t", 86400 * 200, "America/New_York")
+	  assert(date.tz == "America/New_York" and date.isdst and date.yday == 200 and date.hour == 20)
+	  assert(os.time(date) == 86400 * 200)
+	  assert(os.time({year = 1970, month = 1, day = 1, hour = 0, tz = "UTC"}) == 0)
+	  assert(os.date("%Y", nil, "Asia/Tokyo") == os.date("!%Y", os.time() + 9 * 3600))
+	`)
+	errorIfScriptNotFail(t, L, `os.date("%c", 0, "Mars/Olympus")`, "unknown time zone 'Mars/Olympus'")
+	errorIfScriptNotFail(t, L, `os.time({year = 2000, month = 1, day = 1, tz = "Nowhere"})`, "field 'tz' is not a valid time zone")
+}
@@ -2,36 +2,91 @@
 package pm
 
 import (
+	"container/list"
 	"fmt"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 const EOS = -1
 const _UNKNOWN = -2
 
+// maxCaptures is the maximum number of captures in a pattern, LUA_MAXCAPTURES of the reference implementation.
+const maxCaptures = 32
+
+// invalidByte is added to the bytes of invalid UTF-8 sequences in Unicode mode,
+// so that they do not match the characters with the same code points.
+const invalidByte = -0x200
+
+// Flags change how patterns are matched.
+type Flags int
+
+const (
+	// Unicode treats patterns and subjects as UTF-8 encoded characters instead of
+	// bytes: '.', sets and ranges match whole characters and the classes %a, %l,
+	// %u and %w use the unicode tables. Invalid UTF-8 sequences are matched
+	// byte by byte. A range with an invalid UTF-8 byte as an endpoint matches
+	// the characters whose first byte lies in the range, so that byte oriented
+	// patterns like utf8.charpattern match whole characters.
+	Unicode Flags = 1 << iota
+)
+
+// decode returns the character at pos and its length in bytes.
+func decode(src []byte, pos int, flags Flags) (int, int) {
+	if flags&Unicode == 0 || src[pos] < utf8.RuneSelf {
+		return int(src[pos]), 1
+	}
+	r, size := utf8.DecodeRune(src[pos:])
+	if r == utf8.RuneError && size == 1 {
+		return invalidByte + int(src[pos]), 1
+	}
+	return int(r), size
+}
+
+// decodeLast returns the character before pos.
+func decodeLast(src []byte, pos int, flags Flags) int {
+	if flags&Unicode == 0 || src[pos-1] < utf8.RuneSelf {
+		return int(src[pos-1])
+	}
+	r, size := utf8.DecodeLastRune(src[:pos])
+	if r == utf8.RuneError && size == 1 {
+		return invalidByte + int(src[pos-1])
+	}
+	return int(r)
+}
+
+// leadByte returns the first byte of the UTF-8 encoding of a character
+// returned by decode.
+func leadByte(ch int) int {
+	switch {
+	case ch < 0:
+		return ch - invalidByte
+	case ch < utf8.RuneSelf:
+		return ch
+	}
+	var buf [utf8.UTFMax]byte
+	utf8.EncodeRune(buf[:], rune(ch))
+	return int(buf[0])
+}
+
 /* Error {{{ */
 
+// Error is a pattern error. Its message is worded like the errors of the
+// reference implementation.
 type Error struct {
 	Pos     int
 	Message string
 }
 
-func newError(pos int, message string, args ...interface{}) *Error {
-	if len(args) == 0 {
-		return &Error{pos, message}
-	}
-	return &Error{pos, fmt.Sprintf(message, args...)}
+func newError(pos int, format string, args ...interface{}) *Error {
+	return &Error{pos, fmt.Sprintf(format, args...)}
 }
 
-func (e *Error) Error() string {
-	switch e.Pos {
-	case EOS:
-		return fmt.Sprintf("%s at EOS", e.Message)
-	case _UNKNOWN:
-		return fmt.Sprintf("%s", e.Message)
-	default:
-		return fmt.Sprintf("%s at %d", e.Message, e.Pos)
-	}
-}
+// ErrStepLimit is returned by Pattern.Find if matching executes more steps than allowed.
+var ErrStepLimit = &Error{_UNKNOWN, "pattern too complex (step limit exceeded)"}
+
+func (e *Error) Error() string { return e.Message }
 
 /* }}} */
 
@@ -83,13 +138,15 @@ type scannerState struct {
 
 type scanner struct {
 	src   []byte
+	flags Flags
 	State scannerState
 	saved scannerState
 }
 
-func newScanner(src []byte) *scanner {
+func newScanner(src []byte, flags Flags) *scanner {
 	return &scanner{
-		src: src,
+		src:   src,
+		flags: flags,
 		State: scannerState{
 			Pos:     0,
 			started: false,
@@ -112,7 +169,8 @@ func (sc *scanner) Next() int {
 	if sc.State.Pos == EOS {
 		return EOS
 	}
-	return int(sc.src[sc.State.Pos])
+	ch, _ := decode(sc.src, sc.State.Pos, sc.flags)
+	return ch
 }
 
 func (sc *scanner) CurrentPos() int {
@@ -120,30 +178,24 @@ func (sc *scanner) CurrentPos() int {
 }
 
 func (sc *scanner) NextPos() int {
-	if sc.State.Pos == EOS || sc.State.Pos >= len(sc.src)-1 {
+	if sc.State.Pos == EOS {
 		return EOS
 	}
-	if !sc.State.started {
-		return 0
-	} else {
-		return sc.State.Pos + 1
+	next := 0
+	if sc.State.started {
+		_, size := decode(sc.src, sc.State.Pos, sc.flags)
+		next = sc.State.Pos + size
+	}
+	if next >= len(sc.src) {
+		return EOS
 	}
+	return next
 }
 
 func (sc *scanner) Peek() int {
-	cureof := sc.State.Pos == EOS
+	state := sc.State
 	ch := sc.Next()
-	if !cureof {
-		if sc.State.Pos == EOS {
-			sc.State.Pos = len(sc.src) - 1
-		} else {
-			sc.State.Pos--
-			if sc.State.Pos < 0 {
-				sc.State.Pos = 0
-				sc.State.started = false
-			}
-		}
-	}
+	sc.State = state
 	return ch
 }
 
@@ -167,6 +219,7 @@ const (
 	opPSave
 	opBrace
 	opNumber
+	opFrontier
 )
 
 type inst struct {
@@ -233,6 +286,31 @@ func (pn *singleClass) Matches(ch int) bool {
 	return ret
 }
 
+// unicodeClass is a class of the Unicode mode using the unicode tables.
+type unicodeClass struct {
+	Class int
+}
+
+func (pn *unicodeClass) Matches(ch int) bool {
+	ret := false
+	if ch >= 0 {
+		switch r := rune(ch); pn.Class {
+		case 'a', 'A':
+			ret = unicode.IsLetter(r)
+		case 'l', 'L':
+			ret = unicode.IsLower(r)
+		case 'u', 'U':
+			ret = unicode.IsUpper(r)
+		case 'w', 'W':
+			ret = unicode.IsLetter(r) || unicode.IsDigit(r)
+		}
+	}
+	if 'A' <= pn.Class && pn.Class <= 'Z' {
+		return !ret
+	}
+	return ret
+}
+
 type setClass struct {
 	IsNot   bool
 	Classes []class
@@ -259,6 +337,10 @@ func (pn *rangeClass) Matches(ch int) bool {
 		if !ok {
 			return false
 		}
+		if begin.Ch < 0 || end.Ch < 0 {
+			b := leadByte(ch)
+			return leadByte(begin.Ch) <= b && b <= leadByte(end.Ch)
+		}
 		return begin.Ch <= ch && ch <= end.Ch
 	}
 	return false
@@ -300,6 +382,10 @@ type bracePattern struct {
 	End   int
 }
 
+type frontierPattern struct {
+	Class class
+}
+
 // }}}
 
 /* parse {{{ */
@@ -308,7 +394,20 @@ func parseClass(sc *scanner, allowset bool) class {
 	ch := sc.Next()
 	switch ch {
 	case '%':
-		return &singleClass{sc.Next()}
+		ch = sc.Next()
+		if ch == EOS {
+			if !allowset {
+				panic(newError(EOS, "malformed pattern (missing ']')"))
+			}
+			panic(newError(EOS, "malformed pattern (ends with '%%')"))
+		}
+		if sc.flags&Unicode != 0 {
+			switch ch {
+			case 'a', 'A', 'l', 'L', 'u', 'U', 'w', 'W':
+				return &unicodeClass{ch}
+			}
+		}
+		return &singleClass{ch}
 	case '.':
 		if allowset {
 			return &dotClass{}
@@ -334,44 +433,31 @@ func parseClassSet(sc *scanner) class {
 		set.IsNot = true
 		sc.Next()
 	}
-	isrange := false
 	for {
-		ch := sc.Peek()
-		switch ch {
-		// case '[':
-		// 	panic(newError(sc.CurrentPos(), "'[' can not be nested"))
+		switch sc.Peek() {
 		case EOS:
-			panic(newError(sc.CurrentPos(), "unexpected EOS"))
+			panic(newError(EOS, "malformed pattern (missing ']')"))
 		case ']':
+			// a ']' right after the '[' or '[^' is a literal
 			if len(set.Classes) > 0 {
 				sc.Next()
-				goto exit
+				return set
 			}
-			fallthrough
-		case '-':
-			if len(set.Classes) > 0 {
-				sc.Next()
-				isrange = true
-				continue
-			}
-			fallthrough
-		default:
-			set.Classes = append(set.Classes, parseClass(sc, false))
 		}
-		if isrange {
-			begin := set.Classes[len(set.Classes)-2]
-			end := set.Classes[len(set.Classes)-1]
-			set.Classes = set.Classes[0 : len(set.Classes)-2]
-			set.Classes = append(set.Classes, &rangeClass{begin, end})
-			isrange = false
+		cls := parseClass(sc, false)
+		// like the reference implementation, a '-' after a plain character is a
+		// range unless it is the last character of the set; its end is never a class.
+		if begin, ok := cls.(*charClass); ok && sc.Peek() == '-' {
+			sc.Save()
+			sc.Next()
+			if end := sc.Peek(); end != ']' && end != EOS {
+				cls = &rangeClass{begin, &charClass{sc.Next()}}
+			} else {
+				sc.Restore()
+			}
 		}
+		set.Classes = append(set.Classes, cls)
 	}
-exit:
-	if isrange {
-		set.Classes = append(set.Classes, &charClass{'-'})
-	}
-
-	return set
 }
 
 func parsePattern(sc *scanner, toplevel bool) *seqPattern {
@@ -389,24 +475,30 @@ func parsePattern(sc *scanner, toplevel bool) *seqPattern {
 			sc.Save()
 			sc.Next()
 			switch sc.Peek() {
-			case '0':
-				panic(newError(sc.CurrentPos(), "invalid capture index"))
-			case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 				pat.Patterns = append(pat.Patterns, &numberPattern{sc.Next() - 48})
 			case 'b':
 				sc.Next()
-				pat.Patterns = append(pat.Patterns, &bracePattern{sc.Next(), sc.Next()})
+				begin, end := sc.Next(), sc.Next()
+				if begin == EOS || end == EOS {
+					panic(newError(EOS, "malformed pattern (missing arguments to '%%b')"))
+				}
+				pat.Patterns = append(pat.Patterns, &bracePattern{begin, end})
+			case 'f':
+				sc.Next()
+				if sc.Next() != '[' {
+					panic(newError(sc.CurrentPos(), "missing '[' after '%%f' in pattern"))
+				}
+				pat.Patterns = append(pat.Patterns, &frontierPattern{parseClassSet(sc)})
 			default:
 				sc.Restore()
 				pat.Patterns = append(pat.Patterns, &singlePattern{parseClass(sc, true)})
 			}
 		case '.', '[', ']':
 			pat.Patterns = append(pat.Patterns, &singlePattern{parseClass(sc, true)})
-		//case ']':
-		//	panic(newError(sc.CurrentPos(), "invalid ']'"))
 		case ')':
 			if toplevel {
-				panic(newError(sc.CurrentPos(), "invalid ')'"))
+				panic(newError(sc.CurrentPos(), "invalid pattern capture"))
 			}
 			return pat
 		case '(':
@@ -455,6 +547,8 @@ exit:
 type iptr struct {
 	insts   []inst
 	capture int
+	// closed reports whether the capture n+1 is closed at the current instruction
+	closed []bool
 }
 
 func compilePattern(p pattern, ps ...*iptr) []inst {
@@ -462,7 +556,7 @@ func compilePattern(p pattern, ps ...*iptr) []inst {
 	toplevel := false
 	if len(ps) == 0 {
 		toplevel = true
-		ptr = &iptr{[]inst{inst{opSave, nil, 0, -1}}, 2}
+		ptr = &iptr{[]inst{inst{opSave, nil, 0, -1}}, 2, nil}
 	} else {
 		ptr = ps[0]
 	}
@@ -496,17 +590,26 @@ func compilePattern(p pattern, ps ...*iptr) []inst {
 				inst{opChar, pat.Class, -1, -1})
 		}
 	case *posCapPattern:
+		ptr.newCapture()
+		ptr.closed[len(ptr.closed)-1] = true
 		ptr.insts = append(ptr.insts, inst{opPSave, nil, ptr.capture, -1})
 		ptr.capture += 2
 	case *capPattern:
+		n := ptr.newCapture()
 		c0, c1 := ptr.capture, ptr.capture+1
 		ptr.capture += 2
 		ptr.insts = append(ptr.insts, inst{opSave, nil, c0, -1})
 		compilePattern(pat.Pattern, ptr)
 		ptr.insts = append(ptr.insts, inst{opSave, nil, c1, -1})
+		ptr.closed[n-1] = true
 	case *bracePattern:
 		ptr.insts = append(ptr.insts, inst{opBrace, nil, pat.Begin, pat.End})
+	case *frontierPattern:
+		ptr.insts = append(ptr.insts, inst{opFrontier, pat.Class, -1, -1})
 	case *numberPattern:
+		if pat.N < 1 || pat.N > len(ptr.closed) || !ptr.closed[pat.N-1] {
+			panic(newError(_UNKNOWN, "invalid capture index %%%d", pat.N))
+		}
 		ptr.insts = append(ptr.insts, inst{opNumber, nil, pat.N, -1})
 	}
 	if toplevel {
@@ -518,28 +621,55 @@ func compilePattern(p pattern, ps ...*iptr) []inst {
 	return ptr.insts
 }
 
+// newCapture opens a capture and returns its number.
+func (ptr *iptr) newCapture() int {
+	if len(ptr.closed) == maxCaptures {
+		panic(newError(_UNKNOWN, "too many captures"))
+	}
+	ptr.closed = append(ptr.closed, false)
+	return len(ptr.closed)
+}
+
 /* }}} parse */
 
 /* VM {{{ */
 
+type machine struct {
+	src      []byte
+	insts    []inst
+	flags    Flags
+	steps    int
+	maxSteps int
+}
+
 // Simple recursive virtual machine based on the
 // "Regular Expression Matching: the Virtual Machine Approach" (https://swtch.com/~rsc/regexp/regexp2.html)
-func recursiveVM(src []byte, insts []inst, pc, sp int, ms ...*MatchData) (bool, int, *MatchData) {
+func recursiveVM(mc *machine, pc, sp int, ms ...*MatchData) (bool, int, *MatchData) {
 	var m *MatchData
 	if len(ms) == 0 {
 		m = newMatchState()
 	} else {
 		m = ms[0]
 	}
+	src, flags := mc.src, mc.flags
 redo:
-	inst := insts[pc]
+	if mc.maxSteps > 0 {
+		if mc.steps++; mc.steps > mc.maxSteps {
+			panic(ErrStepLimit)
+		}
+	}
+	inst := mc.insts[pc]
 	switch inst.OpCode {
 	case opChar:
-		if sp >= len(src) || !inst.Class.Matches(int(src[sp])) {
+		if sp >= len(src) {
+			return false, sp, m
+		}
+		ch, size := decode(src, sp, flags)
+		if !inst.Class.Matches(ch) {
 			return false, sp, m
 		}
 		pc++
-		sp++
+		sp += size
 		goto redo
 	case opMatch:
 		return true, sp, m
@@ -549,14 +679,14 @@ redo:
 		pc = inst.Operand1
 		goto redo
 	case opSplit:
-		if ok, nsp, _ := recursiveVM(src, insts, inst.Operand1, sp, m); ok {
+		if ok, nsp, _ := recursiveVM(mc, inst.Operand1, sp, m); ok {
 			return true, nsp, m
 		}
 		pc = inst.Operand2
 		goto redo
 	case opSave:
 		s := m.setCapture(inst.Operand1, sp)
-		if ok, nsp, _ := recursiveVM(src, insts, pc+1, sp, m); ok {
+		if ok, nsp, _ := recursiveVM(mc, pc+1, sp, m); ok {
 			return true, nsp, m
 		}
 		m.restoreCapture(inst.Operand1, s)
@@ -566,20 +696,25 @@ redo:
 		pc++
 		goto redo
 	case opBrace:
-		if sp >= len(src) || int(src[sp]) != inst.Operand1 {
+		if sp >= len(src) {
+			return false, sp, m
+		}
+		ch, size := decode(src, sp, flags)
+		if ch != inst.Operand1 {
 			return false, sp, m
 		}
 		count := 1
-		for sp = sp + 1; sp < len(src); sp++ {
-			if int(src[sp]) == inst.Operand2 {
+		for sp = sp + size; sp < len(src); sp += size {
+			ch, size = decode(src, sp, flags)
+			if ch == inst.Operand2 {
 				count--
 			}
 			if count == 0 {
 				pc++
-				sp++
+				sp += size
 				goto redo
 			}
-			if int(src[sp]) == inst.Operand1 {
+			if ch == inst.Operand1 {
 				count++
 			}
 		}
@@ -598,6 +733,20 @@ redo:
 		pc++
 		sp += len(capture)
 		goto redo
+	case opFrontier:
+		// the subject is surrounded by '\0' characters
+		prev, cur := 0, 0
+		if sp > 0 {
+			prev = decodeLast(src, sp, flags)
+		}
+		if sp < len(src) {
+			cur, _ = decode(src, sp, flags)
+		}
+		if inst.Class.Matches(prev) || !inst.Class.Matches(cur) {
+			return false, sp, m
+		}
+		pc++
+		goto redo
 	}
 	panic("should not reach here")
 }
@@ -606,33 +755,140 @@ redo:
 
 /* API {{{ */
 
-func Find(p string, src []byte, offset, limit int) (matches []*MatchData, err error) {
-	defer func() {
-		if v := recover(); v != nil {
-			if perr, ok := v.(*Error); ok {
-				err = perr
-			} else {
-				panic(v)
-			}
+func recoverError(err *error) {
+	if v := recover(); v != nil {
+		if perr, ok := v.(*Error); ok {
+			*err = perr
+		} else {
+			panic(v)
 		}
-	}()
-	pat := parsePattern(newScanner([]byte(p)), true)
-	insts := compilePattern(pat)
+	}
+}
+
+// Pattern is a compiled pattern. It is safe for concurrent use.
+type Pattern struct {
+	source   string
+	flags    Flags
+	insts    []inst
+	mustHead bool
+}
+
+// Compile parses a pattern for repeated matching.
+func Compile(p string, flags ...Flags) (pat *Pattern, err error) {
+	defer recoverError(&err)
+	var fl Flags
+	for _, f := range flags {
+		fl |= f
+	}
+	parsed := parsePattern(newScanner([]byte(p), fl), true)
+	return &Pattern{source: p, flags: fl, insts: compilePattern(parsed), mustHead: parsed.MustHead}, nil
+}
+
+// String returns the source of the pattern.
+func (pat *Pattern) String() string { return pat.source }
+
+// Find returns at most limit matches of the pattern in src starting at offset.
+// A negative limit means no limit. If maxSteps is positive, Find returns
+// ErrStepLimit after executing more than maxSteps instructions of the matcher
+// in total.
+func (pat *Pattern) Find(src []byte, offset, limit, maxSteps int) (matches []*MatchData, err error) {
+	defer recoverError(&err)
+	mc := &machine{src: src, insts: pat.insts, flags: pat.flags, maxSteps: maxSteps}
 	matches = []*MatchData{}
 	for sp := offset; sp <= len(src); {
-		ok, nsp, ms := recursiveVM(src, insts, 0, sp)
-		sp++
+		ok, nsp, ms := recursiveVM(mc, 0, sp)
+		if sp < len(src) {
+			_, size := decode(src, sp, pat.flags)
+			sp += size
+		} else {
+			sp++
+		}
 		if ok {
 			if sp < nsp {
 				sp = nsp
 			}
 			matches = append(matches, ms)
 		}
-		if len(matches) == limit || pat.MustHead {
+		if len(matches) == limit || pat.mustHead {
 			break
 		}
 	}
 	return
 }
 
+// Find returns at most limit matches of the pattern p in src starting at offset.
+// A negative limit means no limit.
+func Find(p string, src []byte, offset, limit int, flags ...Flags) ([]*MatchData, error) {
+	pat, err := Compile(p, flags...)
+	if err != nil {
+		return nil, err
+	}
+	return pat.Find(src, offset, limit, 0)
+}
+
+/* }}} */
+
+/* Cache {{{ */
+
+type cacheKey struct {
+	pattern string
+	flags   Flags
+}
+
+// Cache is a LRU cache of compiled patterns. It is safe for concurrent use.
+type Cache struct {
+	capacity int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[cacheKey]*list.Element
+}
+
+// NewCache returns a cache holding at most capacity patterns.
+func NewCache(capacity int) *Cache {
+	return &Cache{capacity: capacity, lru: list.New(), items: make(map[cacheKey]*list.Element)}
+}
+
+// Compile returns the cached pattern or compiles it. Patterns with errors are not cached.
+func (c *Cache) Compile(p string, flags ...Flags) (*Pattern, error) {
+	var key cacheKey
+	key.pattern = p
+	for _, f := range flags {
+		key.flags |= f
+	}
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*Pattern), nil
+	}
+	c.mu.Unlock()
+
+	pat, err := Compile(p, key.flags)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*Pattern), nil
+	}
+	c.items[key] = c.lru.PushFront(pat)
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		old := oldest.Value.(*Pattern)
+		delete(c.items, cacheKey{old.source, old.flags})
+	}
+	return pat, nil
+}
+
+// Len returns the number of cached patterns.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
 /* }}} */
@@ -0,0 +1,155 @@
+package pm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	pat, err := Compile("(%a+)=(%d+)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pat.String() != "(%a+)=(%d+)" {
+		t.Errorf("unexpected source %q", pat.String())
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			matches, err := pat.Find([]byte("a=1, bc=23"), 0, -1, 0)
+			if err != nil || len(matches) != 2 || matches[1].Capture(2) != 5 || matches[1].Capture(5) != 10 {
+				t.Errorf("unexpected matches %v, %v", matches, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := Compile("(a"); err == nil || err.Error() != "unfinished capture" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	pat, err := Compile(strings.Repeat("a*", 20) + "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte(strings.Repeat("a", 30))
+	if _, err := pat.Find(src, 0, 1, 100000); err != ErrStepLimit {
+		t.Errorf("expected ErrStepLimit, got %v", err)
+	}
+	matches, err := pat.Find([]byte("aab"), 0, 1, 100000)
+	if err != nil || len(matches) != 1 {
+		t.Errorf("unexpected matches %v, %v", matches, err)
+	}
+
+	// the limit bounds the whole call, not each start position
+	pat, err = Compile(".-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pat.Find([]byte(strings.Repeat("a", 40000)), 0, 1, 1000000); err != ErrStepLimit {
+		t.Errorf("expected ErrStepLimit, got %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := NewCache(2)
+	p1, _ := cache.Compile("a")
+	p2, _ := cache.Compile("a")
+	if p1 != p2 {
+		t.Error("patterns should be cached")
+	}
+	if p3, _ := cache.Compile("a", Unicode); p3 == p1 {
+		t.Error("patterns should be cached per flags")
+	}
+	cache.Compile("b")
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 patterns, got %d", cache.Len())
+	}
+	if p4, _ := cache.Compile("a"); p4 == p1 {
+		t.Error("least recently used pattern should be evicted")
+	}
+	if _, err := cache.Compile("(a"); err == nil || cache.Len() != 2 {
+		t.Error("patterns with errors should not be cached")
+	}
+}
+
+func TestFrontier(t *testing.T) {
+	cases := []struct {
+		pattern, src string
+		flags        Flags
+		positions    []int
+	}{
+		{"%f[%w]", "hello, world", 0, []int{0, 7}},
+		{"%f[%W]", "hello, world", 0, []int{5, 12}},
+		{"%f[%a]", "мир и mir", Unicode, []int{0, 7, 10}},
+		{"%f[%A]", "мир и mir", Unicode, []int{6, 9, 13}},
+	}
+	for _, c := range cases {
+		matches, err := Find(c.pattern, []byte(c.src), 0, -1, c.flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions := []int{}
+		for _, m := range matches {
+			positions = append(positions, m.Capture(0))
+		}
+		if fmt.Sprint(positions) != fmt.Sprint(c.positions) {
+			t.Errorf("%q in %q: expected %v, got %v", c.pattern, c.src, c.positions, positions)
+		}
+	}
+}
+
+func TestUnicodeByteRanges(t *testing.T) {
+	// utf8.charpattern
+	charpattern := "[\x00-\x7F\xC2-\xFD][\x80-\xBF]*"
+	cases := []struct {
+		src   string
+		chars []string
+	}{
+		{"привет", []string{"п", "р", "и", "в", "е", "т"}},
+		{"a€😀", []string{"a", "€", "😀"}},
+		{"a\xffб\xd0", []string{"a", "б", "\xd0"}},
+	}
+	for _, c := range cases {
+		matches, err := Find(charpattern, []byte(c.src), 0, -1, Unicode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chars := []string{}
+		for _, m := range matches {
+			chars = append(chars, c.src[m.Capture(0):m.Capture(1)])
+		}
+		if fmt.Sprintf("%q", chars) != fmt.Sprintf("%q", c.chars) {
+			t.Errorf("%q: expected %q, got %q", c.src, c.chars, chars)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cases := []struct {
+		pattern, message string
+	}{
+		{"%", "malformed pattern (ends with '%')"},
+		{"[a", "malformed pattern (missing ']')"},
+		{"[%", "malformed pattern (missing ']')"},
+		{"%b(", "malformed pattern (missing arguments to '%b')"},
+		{"%fx", "missing '[' after '%f' in pattern"},
+		{"a)", "invalid pattern capture"},
+		{"(a", "unfinished capture"},
+		{"%0", "invalid capture index %0"},
+		{"(a%1)", "invalid capture index %1"},
+		{"()%2", "invalid capture index %2"},
+		{strings.Repeat("(a)", 33), "too many captures"},
+	}
+	for _, c := range cases {
+		if _, err := Compile(c.pattern); err == nil || err.Error() != c.message {
+			t.Errorf("%q: expected %q, got %v", c.pattern, c.message, err)
+		}
+	}
+}
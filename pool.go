@@ -0,0 +1,259 @@
+package lua
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+/* Pool {{{ */
+
+// ErrPoolClosed is returned by Pool.Get after the pool has been closed.
+var ErrPoolClosed = errors.New("lua: pool is closed")
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// Options are passed to NewState.
+	Options Options
+	// Preload modules are registered with PreloadModule in every new state.
+	Preload map[string]LGFunction
+	// Init is called for every new state after the modules are preloaded.
+	Init func(L *LState) error
+	// Protos are executed in order in every new state, e.g. compiled rules
+	// defining global entry points.
+	Protos []*FunctionProto
+	// MaxIdle is the maximum number of idle states, 0 means no limit.
+	MaxIdle int
+	// MaxAge is the maximum lifetime of a state, 0 means no limit.
+	MaxAge time.Duration
+	// IdleTimeout closes states which were not used for the given time, 0 means no limit.
+	IdleTimeout time.Duration
+}
+
+// PoolStats are the metrics of a Pool.
+type PoolStats struct {
+	// Created is the number of states created.
+	Created int64
+	// Reused is the number of times Get returned an idle state.
+	Reused int64
+	// Closed is the number of states closed by the pool.
+	Closed int64
+	// Expired is the number of states closed because of MaxAge or IdleTimeout.
+	Expired int64
+	// Idle and Active are the current numbers of idle states and states handed out.
+	Idle   int
+	Active int
+}
+
+// Pool is a concurrency-safe pool of LStates which have the same modules and
+// compiled chunks loaded. States returned with Put are reset to the state they
+// had after their initialization.
+type Pool struct {
+	opts   PoolOptions
+	mu     sync.Mutex
+	idle   []*pooledState
+	active map[*LState]*pooledState
+	stats  PoolStats
+	closed bool
+}
+
+type pooledState struct {
+	L        *LState
+	created  time.Time
+	lastUsed time.Time
+	pristine *poolSnapshot
+}
+
+// NewPool returns a new pool. States are created on demand.
+func NewPool(opts PoolOptions) *Pool {
+	return &Pool{opts: opts, active: make(map[*LState]*pooledState)}
+}
+
+// Get returns an idle state or a new one.
+func (p *Pool) Get() (*LState, error) {
+	now := time.Now()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	var expired []*pooledState
+	for len(p.idle) > 0 {
+		ps := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.expired(ps, now) {
+			expired = append(expired, ps)
+			p.stats.Expired++
+			p.stats.Closed++
+			continue
+		}
+		ps.lastUsed = now
+		p.active[ps.L] = ps
+		p.stats.Reused++
+		p.mu.Unlock()
+		closeStates(expired)
+		return ps.L, nil
+	}
+	p.mu.Unlock()
+	closeStates(expired)
+
+	ps, err := p.newState(now)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.active[ps.L] = ps
+	p.stats.Created++
+	p.mu.Unlock()
+	return ps.L, nil
+}
+
+// Put resets the state and returns it to the pool. States which can not be reset,
+// have expired or exceed MaxIdle are closed.
+func (p *Pool) Put(L *LState) {
+	now := time.Now()
+	p.mu.Lock()
+	ps, ok := p.active[L]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.active, L)
+	keep := !p.closed && !p.expired(ps, now) && (p.opts.MaxIdle <= 0 || len(p.idle) < p.opts.MaxIdle)
+	if !keep && !p.closed && p.expired(ps, now) {
+		p.stats.Expired++
+	}
+	p.mu.Unlock()
+
+	if keep && ps.reset() {
+		ps.lastUsed = now
+		p.mu.Lock()
+		if !p.closed && (p.opts.MaxIdle <= 0 || len(p.idle) < p.opts.MaxIdle) {
+			p.idle = append(p.idle, ps)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+	p.mu.Lock()
+	p.stats.Closed++
+	p.mu.Unlock()
+	L.Close()
+}
+
+// Stats returns the current metrics of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Idle = len(p.idle)
+	stats.Active = len(p.active)
+	return stats
+}
+
+// Close closes all idle states. States which are handed out are closed when they are put back.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.stats.Closed += int64(len(idle))
+	p.mu.Unlock()
+	closeStates(idle)
+}
+
+func (p *Pool) expired(ps *pooledState, now time.Time) bool {
+	return (p.opts.MaxAge > 0 && now.Sub(ps.created) > p.opts.MaxAge) ||
+		(p.opts.IdleTimeout > 0 && now.Sub(ps.lastUsed) > p.opts.IdleTimeout)
+}
+
+func closeStates(states []*pooledState) {
+	for _, ps := range states {
+		ps.L.Close()
+	}
+}
+
+func (p *Pool) newState(now time.Time) (*pooledState, error) {
+	L := NewState(p.opts.Options)
+	for name, loader := range p.opts.Preload {
+		L.PreloadModule(name, loader)
+	}
+	if p.opts.Init != nil {
+		if err := p.opts.Init(L); err != nil {
+			L.Close()
+			return nil, err
+		}
+	}
+	for _, proto := range p.opts.Protos {
+		L.Push(L.NewFunctionFromProto(proto))
+		if err := L.PCall(0, MultRet, nil); err != nil {
+			L.Close()
+			return nil, err
+		}
+		L.SetTop(0)
+	}
+	return &pooledState{L: L, created: now, lastUsed: now, pristine: newPoolSnapshot(L)}, nil
+}
+
+// reset restores the state to its pristine snapshot. It returns false if the
+// state can not be reused.
+func (ps *pooledState) reset() bool {
+	L := ps.L
+	if L.IsClosed() || L.Dead {
+		return false
+	}
+	L.RemoveContext()
+	L.G.CurrentThread = L
+	L.stack.SetSp(0)
+	L.currentFrame = nil
+	L.reg.SetTop(0)
+	L.hasErrorFunc = false
+	return ps.pristine.restore(L)
+}
+
+/* }}} */
+
+/* pool snapshots {{{ */
+
+// poolSnapshot holds the globals and the per-state hook and limit
+// configuration of a pooled state.
+type poolSnapshot struct {
+	globals  *Snapshot
+	options  Options
+	hooks    []vmHook
+	profiler *profiler
+	coverage *coverageHook
+}
+
+func newPoolSnapshot(L *LState) *poolSnapshot {
+	return &poolSnapshot{
+		globals:  L.Snapshot(),
+		options:  L.Options,
+		hooks:    append([]vmHook(nil), L.G.hooks...),
+		profiler: L.G.profiler,
+		coverage: L.G.coverage,
+	}
+}
+
+func (snapshot *poolSnapshot) restore(L *LState) bool {
+	if err := L.Restore(snapshot.globals); err != nil {
+		return false
+	}
+	L.Options = snapshot.options
+	if L.G.profiler != snapshot.profiler && L.G.profiler != nil {
+		L.StopProfile()
+	}
+	if L.G.coverage != snapshot.coverage && L.G.coverage != nil {
+		L.StopCoverage()
+	}
+	L.G.profiler = snapshot.profiler
+	L.G.coverage = snapshot.coverage
+	L.G.hooks = append(L.G.hooks[:0], snapshot.hooks...)
+	// math.randomseed must not leak into the next use, the generator is
+	// created again from the options when it is needed
+	L.G.random = nil
+	L.updateMainLoop()
+	return true
+}
+
+/* }}} */
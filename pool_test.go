@@ -0,0 +1,170 @@
+package lua
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua/parse"
+)
+
+func compilePoolProto(t *testing.T, src string) *FunctionProto {
+	chunk, err := parse.Parse(strings.NewReader(src), "<pool>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := Compile(chunk, "<pool>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proto
+}
+
+func TestPoolReset(t *testing.T) {
+	pool := NewPool(PoolOptions{
+		Preload: map[string]LGFunction{"answer": func(L *LState) int {
+			L.Push(L.NewTable())
+			L.SetField(L.Get(-1), "value", LNumber(42))
+			return 1
+		}},
+		Protos: []*FunctionProto{compilePoolProto(t, `
+		  counter = 0
+		  function handle() counter = counter + 1; return counter end
+		`)},
+	})
+	defer pool.Close()
+
+	L, err := pool.Get()
+	errorIfNotNil(t, err)
+	errorIfScriptFail(t, L, `
+	  assert(handle() == 1 and handle() == 2)
+	  assert(require("answer").value == 42)
+	  leaked = true
+	  string.leaked = true
+	  package.loaded.answer.value = 0
+	  setmetatable(_G, {__index = function() return 1 end})
+	`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	L.SetContext(ctx)
+	L.Push(LNumber(1))
+	pool.Put(L)
+
+	L2, err := pool.Get()
+	errorIfNotNil(t, err)
+	errorIfFalse(t, L == L2, "state should be reused")
+	errorIfNotEqual(t, 0, L2.GetTop())
+	errorIfFalse(t, L2.Context() == nil, "context should be removed")
+	errorIfScriptFail(t, L2, `
+	  assert(handle() == 1)
+	  assert(leaked == nil and string.leaked == nil and getmetatable(_G) == nil)
+	  assert(require("answer").value == 42)
+	`)
+	pool.Put(L2)
+
+	stats := pool.Stats()
+	errorIfNotEqual(t, int64(1), stats.Created)
+	errorIfNotEqual(t, int64(1), stats.Reused)
+	errorIfNotEqual(t, 1, stats.Idle)
+	errorIfNotEqual(t, 0, stats.Active)
+}
+
+func TestPoolResetLocals(t *testing.T) {
+	pool := NewPool(PoolOptions{
+		Protos: []*FunctionProto{compilePoolProto(t, `
+		  local seen = {}
+		  local count = 0
+		  function on_logline(e)
+		    count = count + 1
+		    if seen[e] then return count, true end
+		    seen[e] = true
+		    return count, false
+		  end
+		`)},
+	})
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		L, err := pool.Get()
+		errorIfNotNil(t, err)
+		errorIfScriptFail(t, L, `
+		  local count, dup = on_logline("login")
+		  assert(count == 1 and not dup, count)
+		  count, dup = on_logline("login")
+		  assert(count == 2 and dup, count)
+		`)
+		pool.Put(L)
+	}
+	errorIfNotEqual(t, int64(1), pool.Stats().Created)
+}
+
+func TestPoolRandom(t *testing.T) {
+	seed := int64(42)
+	pool := NewPool(PoolOptions{Options: Options{RandomSeed: &seed}})
+	defer pool.Close()
+	L := NewState(Options{RandomSeed: &seed})
+	defer L.Close()
+	expected := randomSequence(L, randomScript)
+
+	L1, err := pool.Get()
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, expected, randomSequence(L1, randomScript))
+	errorIfScriptFail(t, L1, `math.randomseed(7)`)
+	pool.Put(L1)
+
+	L2, err := pool.Get()
+	errorIfNotNil(t, err)
+	errorIfFalse(t, L1 == L2, "state should be reused")
+	errorIfNotEqual(t, expected, randomSequence(L2, randomScript))
+	pool.Put(L2)
+}
+
+func TestPoolLimits(t *testing.T) {
+	pool := NewPool(PoolOptions{MaxIdle: 1, MaxAge: 50 * time.Millisecond})
+	L1, _ := pool.Get()
+	L2, _ := pool.Get()
+	pool.Put(L1)
+	pool.Put(L2)
+	stats := pool.Stats()
+	errorIfNotEqual(t, 1, stats.Idle)
+	errorIfNotEqual(t, int64(1), stats.Closed)
+	errorIfFalse(t, L2.IsClosed(), "state exceeding MaxIdle should be closed")
+
+	time.Sleep(60 * time.Millisecond)
+	L3, _ := pool.Get()
+	errorIfFalse(t, L3 != L1, "expired state should not be reused")
+	errorIfNotEqual(t, int64(1), pool.Stats().Expired)
+	pool.Close()
+	_, err := pool.Get()
+	errorIfNotEqual(t, ErrPoolClosed, err)
+	pool.Put(L3)
+	errorIfFalse(t, L3.IsClosed(), "state put into a closed pool should be closed")
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	pool := NewPool(PoolOptions{Protos: []*FunctionProto{compilePoolProto(t, `function add(a, b) return a + b end`)}})
+	defer pool.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				L, err := pool.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := L.DoString(`x = add(1, 2)`); err != nil {
+					t.Error(err)
+				}
+				pool.Put(L)
+			}
+		}()
+	}
+	wg.Wait()
+	stats := pool.Stats()
+	errorIfNotEqual(t, int64(160), stats.Created+stats.Reused)
+}
@@ -0,0 +1,225 @@
+package lua
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/* protobuf encoder {{{ */
+
+// protoBuffer is a minimal protocol buffers encoder sufficient for profile.proto.
+type protoBuffer struct {
+	buf []byte
+}
+
+func (pb *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		pb.buf = append(pb.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	pb.buf = append(pb.buf, byte(x))
+}
+
+func (pb *protoBuffer) key(tag int, wire int) {
+	pb.varint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (pb *protoBuffer) uint64Field(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	pb.key(tag, 0)
+	pb.varint(x)
+}
+
+func (pb *protoBuffer) int64Field(tag int, x int64) {
+	pb.uint64Field(tag, uint64(x))
+}
+
+func (pb *protoBuffer) bytesField(tag int, b []byte) {
+	pb.key(tag, 2)
+	pb.varint(uint64(len(b)))
+	pb.buf = append(pb.buf, b...)
+}
+
+func (pb *protoBuffer) packedUint64Field(tag int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	packed := &protoBuffer{}
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	pb.bytesField(tag, packed.buf)
+}
+
+func (pb *protoBuffer) packedInt64Field(tag int, xs []int64) {
+	if len(xs) == 0 {
+		return
+	}
+	packed := &protoBuffer{}
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	pb.bytesField(tag, packed.buf)
+}
+
+/* }}} */
+
+/* pprof {{{ */
+
+type pprofStrings struct {
+	table []string
+	index map[string]int64
+}
+
+func (ps *pprofStrings) id(s string) int64 {
+	if id, ok := ps.index[s]; ok {
+		return id
+	}
+	id := int64(len(ps.table))
+	ps.table = append(ps.table, s)
+	ps.index[s] = id
+	return id
+}
+
+type pprofLocationKey struct {
+	fn   *ProfileFunction
+	line int
+}
+
+// sampleTypes returns the names and units of the values of samples.
+func (p *Profile) sampleTypes() [][2]string {
+	if p.Mode == ProfileInstrumenting {
+		return [][2]string{{"instructions", "count"}, {"wall", "nanoseconds"}}
+	}
+	return [][2]string{{"samples", "count"}, {"cpu", "nanoseconds"}}
+}
+
+func (p *Profile) sampleValues(s *ProfileSample) []int64 {
+	if p.Mode == ProfileInstrumenting {
+		return []int64{s.Instructions, s.Nanoseconds}
+	}
+	return []int64{s.Samples, s.Nanoseconds}
+}
+
+// WritePprof writes the profile to w as a gzip compressed pprof protocol buffer.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strs := &pprofStrings{index: map[string]int64{}}
+	strs.id("")
+	out := &protoBuffer{}
+
+	for _, st := range p.sampleTypes() {
+		vt := &protoBuffer{}
+		vt.int64Field(1, strs.id(st[0]))
+		vt.int64Field(2, strs.id(st[1]))
+		out.bytesField(1, vt.buf)
+	}
+
+	funcIds := map[*ProfileFunction]uint64{}
+	locIds := map[pprofLocationKey]uint64{}
+	functions := []*ProfileFunction{}
+	locations := []pprofLocationKey{}
+	for _, sample := range p.Samples {
+		ids := make([]uint64, 0, len(sample.Stack))
+		for _, frame := range sample.Stack {
+			if _, ok := funcIds[frame.Function]; !ok {
+				funcIds[frame.Function] = uint64(len(functions) + 1)
+				functions = append(functions, frame.Function)
+			}
+			key := pprofLocationKey{frame.Function, frame.Line}
+			id, ok := locIds[key]
+			if !ok {
+				id = uint64(len(locations) + 1)
+				locIds[key] = id
+				locations = append(locations, key)
+			}
+			ids = append(ids, id)
+		}
+		sp := &protoBuffer{}
+		sp.packedUint64Field(1, ids)
+		sp.packedInt64Field(2, p.sampleValues(sample))
+		out.bytesField(2, sp.buf)
+	}
+
+	for i, loc := range locations {
+		line := &protoBuffer{}
+		line.uint64Field(1, funcIds[loc.fn])
+		line.int64Field(2, int64(loc.line))
+		lp := &protoBuffer{}
+		lp.uint64Field(1, uint64(i+1))
+		lp.bytesField(4, line.buf)
+		out.bytesField(4, lp.buf)
+	}
+
+	for i, fn := range functions {
+		fp := &protoBuffer{}
+		fp.uint64Field(1, uint64(i+1))
+		fp.int64Field(2, strs.id(fn.Name))
+		fp.int64Field(3, strs.id(fn.Name))
+		fp.int64Field(4, strs.id(fn.Source))
+		fp.int64Field(5, int64(fn.LineDefined))
+		out.bytesField(5, fp.buf)
+	}
+
+	// period type must be interned before the string table is written.
+	periodType := &protoBuffer{}
+	if p.Mode == ProfileSampling {
+		periodType.int64Field(1, strs.id("cpu"))
+		periodType.int64Field(2, strs.id("nanoseconds"))
+	}
+
+	for _, s := range strs.table {
+		out.bytesField(6, []byte(s))
+	}
+	out.int64Field(9, p.Start.UnixNano())
+	out.int64Field(10, int64(p.Duration))
+	if p.Mode == ProfileSampling {
+		out.bytesField(11, periodType.buf)
+		out.int64Field(12, int64(p.Interval))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(out.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// WriteFolded writes the profile to w in the folded stack format used by flame graph tools.
+// Each line holds the semicolon separated frames from the outermost function and the sample count
+// (ProfileSampling) or the instruction count (ProfileInstrumenting).
+func (p *Profile) WriteFolded(w io.Writer) error {
+	folded := map[string]int64{}
+	for _, sample := range p.Samples {
+		names := make([]string, len(sample.Stack))
+		for i, frame := range sample.Stack {
+			names[len(names)-1-i] = strings.Replace(frame.Function.String(), ";", ":", -1)
+		}
+		value := sample.Samples
+		if p.Mode == ProfileInstrumenting {
+			value = sample.Instructions
+		}
+		if value != 0 {
+			folded[strings.Join(names, ";")] += value
+		}
+	}
+	stacks := make([]string, 0, len(folded))
+	for stack := range folded {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", stack, folded[stack]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+/* }}} */
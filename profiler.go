@@ -0,0 +1,365 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+/* ProfileMode {{{ */
+
+// ProfileMode selects how the Lua profiler attributes costs to Lua code.
+type ProfileMode int
+
+const (
+	// ProfileSampling records the Lua call stack every ProfileOptions.Interval.
+	// Time spent in Go functions is attributed to the first Lua instruction
+	// executed after the Go function returns.
+	ProfileSampling ProfileMode = iota
+	// ProfileInstrumenting counts every executed instruction. Time is measured
+	// on function calls and returns, so it is reported per function rather than per line.
+	ProfileInstrumenting
+)
+
+func (m ProfileMode) String() string {
+	switch m {
+	case ProfileSampling:
+		return "sampling"
+	case ProfileInstrumenting:
+		return "instrumenting"
+	}
+	return fmt.Sprintf("ProfileMode(%d)", int(m))
+}
+
+/* }}} */
+
+/* ProfileOptions {{{ */
+
+// ProfileOptions is a configuration that is used to start a Lua profiler.
+type ProfileOptions struct {
+	// Profiling mode. This defaults to `ProfileSampling`.
+	Mode ProfileMode
+	// Sampling interval. This defaults to `lua.ProfileSampleInterval`.
+	Interval time.Duration
+}
+
+/* }}} */
+
+/* Profile {{{ */
+
+// ProfileFunction describes a function which appeared in a profile.
+type ProfileFunction struct {
+	Name        string
+	Source      string
+	LineDefined int
+	IsG         bool
+
+	proto *FunctionProto
+}
+
+func (pf *ProfileFunction) String() string {
+	if pf.IsG {
+		return pf.Name
+	}
+	return fmt.Sprintf("%s (%s:%d)", pf.Name, pf.Source, pf.LineDefined)
+}
+
+// ProfileFrame is a function and the line being executed in this function.
+type ProfileFrame struct {
+	Function *ProfileFunction
+	Line     int
+}
+
+// ProfileSample is the cost attributed to a call stack.
+type ProfileSample struct {
+	// Stack frames, innermost first.
+	Stack        []ProfileFrame
+	Samples      int64
+	Nanoseconds  int64
+	Instructions int64
+}
+
+// Profile is the result of a Lua profiling session.
+type Profile struct {
+	Mode     ProfileMode
+	Start    time.Time
+	Duration time.Duration
+	Interval time.Duration
+	Samples  []*ProfileSample
+}
+
+/* }}} */
+
+/* profiler {{{ */
+
+type profileCounter struct {
+	samples      int64
+	nanoseconds  int64
+	instructions int64
+}
+
+type profileNodeKey struct {
+	fn     interface{}
+	callPc int
+}
+
+type profileNode struct {
+	parent   *profileNode
+	fn       *ProfileFunction
+	callPc   int
+	children map[profileNodeKey]*profileNode
+	// counters indexed by pc; G functions use a single counter.
+	counters []profileCounter
+	// function level costs, used for the time of instrumenting profiles.
+	self profileCounter
+}
+
+func (pn *profileNode) counter(pc int) *profileCounter {
+	if pc >= len(pn.counters) {
+		pc = len(pn.counters) - 1
+	}
+	return &pn.counters[pc]
+}
+
+type profiler struct {
+	mode     ProfileMode
+	interval time.Duration
+	start    time.Time
+	pending  int32
+	done     chan struct{}
+
+	funcs map[interface{}]*ProfileFunction
+	root  *profileNode
+	stack []*callFrame
+
+	lastTime  time.Time
+	lastFrame *callFrame
+	lastFn    *LFunction
+	lastNode  *profileNode
+}
+
+func newProfiler(opts ProfileOptions) *profiler {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = ProfileSampleInterval
+	}
+	now := time.Now()
+	return &profiler{
+		mode:     opts.Mode,
+		interval: interval,
+		start:    now,
+		done:     make(chan struct{}),
+		funcs:    make(map[interface{}]*ProfileFunction),
+		root:     &profileNode{children: make(map[profileNodeKey]*profileNode)},
+		stack:    make([]*callFrame, 0, 32),
+		lastTime: now,
+	}
+}
+
+func (pr *profiler) run() {
+	if pr.mode != ProfileSampling {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(pr.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				atomic.StoreInt32(&pr.pending, 1)
+			case <-pr.done:
+				return
+			}
+		}
+	}()
+}
+
+func (pr *profiler) stop() {
+	select {
+	case <-pr.done:
+	default:
+		close(pr.done)
+	}
+}
+
+func (pr *profiler) function(L *LState, cf *callFrame) (interface{}, *ProfileFunction) {
+	var key interface{} = cf.Fn
+	if !cf.Fn.IsG {
+		key = cf.Fn.Proto
+	}
+	if pf, ok := pr.funcs[key]; ok {
+		return key, pf
+	}
+	pf := &ProfileFunction{Name: L.rawFrameFuncName(cf), Source: "[G]", IsG: cf.Fn.IsG}
+	if !cf.Fn.IsG {
+		pf.proto = cf.Fn.Proto
+		pf.Source = cf.Fn.Proto.SourceName
+		pf.LineDefined = cf.Fn.Proto.LineDefined
+	}
+	pr.funcs[key] = pf
+	return key, pf
+}
+
+func (pr *profiler) node(L *LState, cf *callFrame) *profileNode {
+	stack := pr.stack[:0]
+	for frame := cf; frame != nil; frame = frame.Parent {
+		stack = append(stack, frame)
+	}
+	pr.stack = stack
+	node := pr.root
+	callPc := -1
+	for i := len(stack) - 1; i >= 0; i-- {
+		frame := stack[i]
+		key, pf := pr.function(L, frame)
+		nkey := profileNodeKey{key, callPc}
+		child, ok := node.children[nkey]
+		if !ok {
+			ncounters := 1
+			if !frame.Fn.IsG {
+				ncounters = len(frame.Fn.Proto.Code)
+			}
+			child = &profileNode{
+				parent:   node,
+				fn:       pf,
+				callPc:   callPc,
+				children: make(map[profileNodeKey]*profileNode),
+				counters: make([]profileCounter, ncounters),
+			}
+			node.children[nkey] = child
+		}
+		node = child
+		callPc = -1
+		if !frame.Fn.IsG {
+			callPc = frame.Pc - 1
+		}
+	}
+	return node
+}
+
+func (pr *profiler) beforeInst(L *LState, cf *callFrame, pc int, inst uint32) {
+	switch pr.mode {
+	case ProfileSampling:
+		if atomic.LoadInt32(&pr.pending) == 0 {
+			return
+		}
+		atomic.StoreInt32(&pr.pending, 0)
+		now := time.Now()
+		counter := pr.node(L, cf).counter(pc)
+		counter.samples++
+		counter.nanoseconds += int64(now.Sub(pr.lastTime))
+		pr.lastTime = now
+	case ProfileInstrumenting:
+		if cf != pr.lastFrame || cf.Fn != pr.lastFn {
+			now := time.Now()
+			if pr.lastNode != nil {
+				pr.lastNode.self.nanoseconds += int64(now.Sub(pr.lastTime))
+			}
+			pr.lastTime = now
+			pr.lastFrame = cf
+			pr.lastFn = cf.Fn
+			pr.lastNode = pr.node(L, cf)
+		}
+		pr.lastNode.counter(pc).instructions++
+	}
+}
+
+func (pr *profiler) afterInst(L *LState, cf *callFrame, pc int, inst uint32) {}
+
+func (pr *profiler) profile() *Profile {
+	now := time.Now()
+	if pr.mode == ProfileInstrumenting && pr.lastNode != nil {
+		pr.lastNode.self.nanoseconds += int64(now.Sub(pr.lastTime))
+	}
+	prof := &Profile{
+		Mode:     pr.mode,
+		Start:    pr.start,
+		Duration: now.Sub(pr.start),
+		Interval: pr.interval,
+		Samples:  []*ProfileSample{},
+	}
+	var walk func(node *profileNode)
+	walk = func(node *profileNode) {
+		if node != pr.root {
+			for pc, counter := range node.counters {
+				if counter.samples != 0 || counter.instructions != 0 || counter.nanoseconds != 0 {
+					prof.Samples = append(prof.Samples, newProfileSample(node, node.fn.line(pc), counter))
+				}
+			}
+			if node.self.nanoseconds != 0 {
+				prof.Samples = append(prof.Samples, newProfileSample(node, node.fn.LineDefined, node.self))
+			}
+		}
+		children := make([]*profileNode, 0, len(node.children))
+		for _, child := range node.children {
+			children = append(children, child)
+		}
+		sort.Slice(children, func(i, j int) bool {
+			if children[i].fn.Name != children[j].fn.Name {
+				return children[i].fn.Name < children[j].fn.Name
+			}
+			return children[i].callPc < children[j].callPc
+		})
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	walk(pr.root)
+	return prof
+}
+
+func newProfileSample(node *profileNode, line int, counter profileCounter) *ProfileSample {
+	sample := &ProfileSample{
+		Stack:        []ProfileFrame{{node.fn, line}},
+		Samples:      counter.samples,
+		Nanoseconds:  counter.nanoseconds,
+		Instructions: counter.instructions,
+	}
+	for n := node; n.parent != nil && n.parent.fn != nil; n = n.parent {
+		sample.Stack = append(sample.Stack, ProfileFrame{n.parent.fn, n.parent.fn.line(n.callPc)})
+	}
+	return sample
+}
+
+func (pf *ProfileFunction) line(pc int) int {
+	if pf.proto == nil || pc < 0 || pc >= len(pf.proto.DbgSourcePositions) {
+		return 0
+	}
+	return pf.proto.DbgSourcePositions[pc]
+}
+
+/* }}} */
+
+/* api methods {{{ */
+
+// StartProfile starts a Lua level profiler on this LState and all threads sharing its globals.
+func (ls *LState) StartProfile(opts ...ProfileOptions) error {
+	if ls.G.profiler != nil {
+		return errors.New("profiler is already running")
+	}
+	var opt ProfileOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	pr := newProfiler(opt)
+	ls.G.profiler = pr
+	ls.addHook(pr)
+	pr.run()
+	return nil
+}
+
+// StopProfile stops the profiler started by StartProfile and returns the collected profile.
+// StopProfile returns nil if the profiler is not running.
+func (ls *LState) StopProfile() *Profile {
+	pr := ls.G.profiler
+	if pr == nil {
+		return nil
+	}
+	pr.stop()
+	ls.removeHook(pr)
+	ls.G.profiler = nil
+	return pr.profile()
+}
+
+/* }}} */
@@ -0,0 +1,115 @@
+package lua
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const profilerTestScript = `
+local function fib(n)
+  if n < 2 then
+    return n
+  end
+  return fib(n - 1) + fib(n - 2)
+end
+
+function busy()
+  local s = 0
+  for i = 1, 10 do
+    s = s + fib(15)
+  end
+  return s
+end
+
+busy()
+`
+
+func TestProfileInstrumenting(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfNotNil(t, L.StartProfile(ProfileOptions{Mode: ProfileInstrumenting}))
+	errorIfNil(t, L.StartProfile())
+	errorIfScriptFail(t, L, profilerTestScript)
+	prof := L.StopProfile()
+	errorIfNil(t, prof)
+	errorIfNotNil(t, L.StopProfile())
+
+	var fibInsts, busyInsts int64
+	for _, sample := range prof.Samples {
+		switch sample.Stack[0].Function.Name {
+		case "fib":
+			fibInsts += sample.Instructions
+			errorIfFalse(t, sample.Stack[0].Function.LineDefined == 2, "unexpected line defined %v", sample.Stack[0].Function.LineDefined)
+		case "busy":
+			busyInsts += sample.Instructions
+		}
+		errorIfNotEqual(t, "main chunk", sample.Stack[len(sample.Stack)-1].Function.Name)
+	}
+	errorIfFalse(t, fibInsts > busyInsts, "fib should execute more instructions than busy: %v, %v", fibInsts, busyInsts)
+
+	var buf bytes.Buffer
+	errorIfNotNil(t, prof.WriteFolded(&buf))
+	errorIfFalse(t, strings.Contains(buf.String(), "main chunk (<string>:0);busy (<string>:9);fib (<string>:2) "), "unexpected folded stacks: %v", buf.String())
+
+	// the profiler hook must be removed
+	errorIfNotEqual(t, 0, len(L.G.hooks))
+}
+
+func TestProfileSampling(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	// the ticker never fires, the script requests the samples itself
+	errorIfNotNil(t, L.StartProfile(ProfileOptions{Interval: time.Hour}))
+	L.SetGlobal("tick", L.NewFunction(func(L *LState) int {
+		atomic.StoreInt32(&L.G.profiler.pending, 1)
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+	  local i = 0
+	  for n = 1, 3 do
+	    for j = 1, 1000 do i = i + j end
+	    tick()
+	  end
+	`)
+	prof := L.StopProfile()
+	var samples int64
+	for _, sample := range prof.Samples {
+		samples += sample.Samples
+		errorIfFalse(t, sample.Nanoseconds > 0, "sample should have a duration")
+	}
+	errorIfNotEqual(t, int64(3), samples)
+
+	var buf bytes.Buffer
+	errorIfNotNil(t, prof.WritePprof(&buf))
+	gz, err := gzip.NewReader(&buf)
+	errorIfNotNil(t, err)
+	data, err := ioutil.ReadAll(gz)
+	errorIfNotNil(t, err)
+	errorIfFalse(t, bytes.Contains(data, []byte("main chunk")), "function names should be in the string table")
+}
+
+func TestProfileCoroutine(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  co = coroutine.create(function()
+	    for i = 1, 3 do coroutine.yield(i) end
+	  end)
+	  coroutine.resume(co)
+	`)
+	errorIfNotNil(t, L.StartProfile(ProfileOptions{Mode: ProfileInstrumenting}))
+	errorIfScriptFail(t, L, `coroutine.resume(co)`)
+	prof := L.StopProfile()
+	found := false
+	for _, sample := range prof.Samples {
+		if sample.Stack[len(sample.Stack)-1].Function.Name == "corountine" {
+			found = true
+		}
+	}
+	errorIfFalse(t, found, "coroutine created before StartProfile should be profiled")
+}
@@ -0,0 +1,172 @@
+package lua
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+/* ProtoCache {{{ */
+
+// DefaultProtoCacheSize is the capacity of the cache for modules loaded by searchers
+// added with LState.AddSearcher if Options.ProtoCache is not set.
+var DefaultProtoCacheSize = 1024
+
+// ProtoCacheStats are the metrics of a ProtoCache.
+type ProtoCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Size is the current number of cached protos.
+	Size int
+}
+
+// ProtoCache is a concurrency-safe LRU cache of compiled chunks keyed by their
+// cleaned chunk name and a hash of their source, so "./rules/auth.lua" and
+// "rules/auth.lua" share a proto. Compiled protos are never modified,
+// so a cache can be shared by all states of a process through Options.ProtoCache.
+type ProtoCache struct {
+	capacity int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[protoCacheKey]*list.Element
+	stats ProtoCacheStats
+}
+
+type protoCacheKey [sha256.Size]byte
+
+type protoCacheEntry struct {
+	key   protoCacheKey
+	proto *FunctionProto
+}
+
+// NewProtoCache returns a cache holding at most capacity protos. A capacity
+// less than 1 means no limit.
+func NewProtoCache(capacity int) *ProtoCache {
+	return &ProtoCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[protoCacheKey]*list.Element),
+	}
+}
+
+// Compile returns the compiled Lua chunk, compiling it on a cache miss.
+func (c *ProtoCache) Compile(name string, source []byte) (*FunctionProto, error) {
+	return c.compile(ModuleLua, name, source)
+}
+
+func (c *ProtoCache) compile(format ModuleFormat, name string, source []byte) (*FunctionProto, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00", format, path.Clean(name))
+	h.Write(source)
+	var key protoCacheKey
+	copy(key[:], h.Sum(nil))
+
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return elem.Value.(*protoCacheEntry).proto, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// compile without holding the lock, concurrent misses compile twice
+	var chunk []ast.Stmt
+	var err error
+	switch format {
+	case ModuleRuleAST:
+		chunk, err = ast.ParseRule(source)
+	default:
+		chunk, err = parse.Parse(bytes.NewReader(source), name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	proto, err := Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*protoCacheEntry).proto, nil
+	}
+	c.items[key] = c.lru.PushFront(&protoCacheEntry{key: key, proto: proto})
+	for c.capacity > 0 && c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*protoCacheEntry).key)
+		c.stats.Evictions++
+	}
+	return proto, nil
+}
+
+// Warm compiles all *.lua files below dir. The chunk names are the paths of
+// the files, e.g. "rules/auth.lua" for the directory "rules", which LoadFile
+// and require find whether they are given "rules/auth.lua" or
+// "./rules/auth.lua". Use OSFS for the files of the operating system.
+func (c *ProtoCache) Warm(fsys fs.FS, dir string) (int, error) {
+	n := 0
+	err := fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(name, ".lua") {
+			return nil
+		}
+		source, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		if _, err := c.Compile(name, skipShebang(source)); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Stats returns the current metrics of the cache.
+func (c *ProtoCache) Stats() ProtoCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// Purge removes all protos from the cache.
+func (c *ProtoCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.items = make(map[protoCacheKey]*list.Element)
+}
+
+// skipShebang removes the first line of a source starting with '#' but keeps its
+// newline, just as LoadFile does.
+func skipShebang(source []byte) []byte {
+	if len(source) == 0 || source[0] != '#' {
+		return source
+	}
+	if i := bytes.IndexByte(source, '\n'); i >= 0 {
+		return source[i:]
+	}
+	return nil
+}
+
+/* }}} */
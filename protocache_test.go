@@ -0,0 +1,81 @@
+package lua
+
+import (
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProtoCache(t *testing.T) {
+	cache := NewProtoCache(2)
+	L1 := NewState(Options{ProtoCache: cache})
+	defer L1.Close()
+	L2 := NewState(Options{ProtoCache: cache})
+	defer L2.Close()
+
+	fn1, err := L1.LoadString(`return 1`)
+	errorIfNotNil(t, err)
+	fn2, err := L2.LoadString(`return 1`)
+	errorIfNotNil(t, err)
+	errorIfFalse(t, fn1.Proto == fn2.Proto, "protos should be shared")
+	errorIfFalse(t, fn1 != fn2, "functions should not be shared")
+	errorIfNotEqual(t, ProtoCacheStats{Hits: 1, Misses: 1, Size: 1}, cache.Stats())
+
+	_, err = L1.LoadString(`return (`)
+	errorIfNil(t, err)
+	errorIfNotEqual(t, 1, cache.Stats().Size)
+
+	// the script itself is cached too
+	errorIfScriptFail(t, L1, `assert(loadstring("return 2", "a")() == 2 and loadstring("return 2", "b")() == 2)`)
+	stats := cache.Stats()
+	errorIfNotEqual(t, 2, stats.Size)
+	errorIfNotEqual(t, int64(2), stats.Evictions)
+
+	cache.Purge()
+	errorIfNotEqual(t, 0, cache.Stats().Size)
+}
+
+func TestProtoCacheWarm(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rules/a.lua":       &fstest.MapFile{Data: []byte("#!/usr/bin/env glua\nreturn 'a'")},
+		"rules/lib/b.lua":   &fstest.MapFile{Data: []byte(`return 'b'`)},
+		"rules/readme.txt":  &fstest.MapFile{Data: []byte(`not lua`)},
+		"rules/broken.luac": &fstest.MapFile{Data: []byte("\x1bLua")},
+	}
+	cache := NewProtoCache(0)
+	n, err := cache.Warm(fsys, "rules")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, 2, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			L := NewState(Options{ProtoCache: cache, FS: NewReadOnlyFS(fsys)})
+			defer L.Close()
+			if err := L.DoString(`
+			  package.path = "rules/?.lua"
+			  assert(require("a") == "a" and require("lib.b") == "b")
+			`); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// the warmed files are hits, the script may miss more than once
+	stats := cache.Stats()
+	errorIfNotEqual(t, int64(14), stats.Misses+stats.Hits)
+	errorIfFalse(t, stats.Hits >= 8, "required modules should be hits")
+
+	// the default package.path finds the modules as "./rules/a.lua"
+	cache = NewProtoCache(0)
+	_, err = cache.Warm(fsys, "rules")
+	errorIfNotNil(t, err)
+	L := NewState(Options{ProtoCache: cache, FS: NewReadOnlyFS(fsys)})
+	defer L.Close()
+	errorIfScriptFail(t, L, `assert(require("rules.a") == "a" and require("rules.lib.b") == "b")`)
+	stats = cache.Stats()
+	errorIfNotEqual(t, int64(2), stats.Hits)
+	errorIfNotEqual(t, int64(2+1), stats.Misses) // the warmed files and the script
+}
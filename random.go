@@ -0,0 +1,139 @@
+package lua
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/bits"
+)
+
+// RandomAlgorithm is the algorithm of the random number generator of math.random.
+type RandomAlgorithm int
+
+const (
+	// RandomXoshiro is xoshiro256**, the algorithm of the generator of Lua 5.4.
+	RandomXoshiro RandomAlgorithm = iota
+	// RandomPCG is PCG-DXSM with 128 bits of state.
+	RandomPCG
+	// RandomSecure reads crypto/rand. It can not be seeded, math.randomseed does nothing.
+	RandomSecure
+)
+
+// randomSource generates the numbers of math.random.
+type randomSource interface {
+	Uint64() uint64
+	Seed(n1, n2 uint64)
+}
+
+type xoshiroSource struct {
+	s [4]uint64
+}
+
+func (r *xoshiroSource) Uint64() uint64 {
+	s := &r.s
+	result := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return result
+}
+
+// Seed initializes the state from the seed like math.randomseed of Lua 5.4.
+func (r *xoshiroSource) Seed(n1, n2 uint64) {
+	r.s = [4]uint64{n1, 0xff, n2, 0}
+	// discard the initial values to spread the seed
+	for i := 0; i < 16; i++ {
+		r.Uint64()
+	}
+}
+
+type pcgSource struct {
+	hi, lo uint64
+}
+
+func (r *pcgSource) Uint64() uint64 {
+	const (
+		mulHi = 2549297995355413924
+		mulLo = 4865540595714422341
+		incHi = 6364136223846793005
+		incLo = 1442695040888963407
+	)
+	// state = state * mul + inc
+	hi, lo := bits.Mul64(r.lo, mulLo)
+	hi += r.hi*mulLo + r.lo*mulHi
+	lo, c := bits.Add64(lo, incLo, 0)
+	hi, _ = bits.Add64(hi, incHi, c)
+	r.hi, r.lo = hi, lo
+
+	// DXSM output permutation
+	const cheapMul = 0xda942042e4dd58b5
+	hi ^= hi >> 32
+	hi *= cheapMul
+	hi ^= hi >> 48
+	hi *= lo | 1
+	return hi
+}
+
+func (r *pcgSource) Seed(n1, n2 uint64) { r.hi, r.lo = n1, n2 }
+
+type secureSource struct{}
+
+func (r secureSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (r secureSource) Seed(n1, n2 uint64) {}
+
+func newRandomSource(algorithm RandomAlgorithm, seed *int64) randomSource {
+	var r randomSource
+	switch algorithm {
+	case RandomPCG:
+		r = &pcgSource{}
+	case RandomSecure:
+		return secureSource{}
+	default:
+		r = &xoshiroSource{}
+	}
+	if seed == nil {
+		r.Seed(secureSource{}.Uint64(), secureSource{}.Uint64())
+	} else {
+		r.Seed(uint64(*seed), 0)
+	}
+	return r
+}
+
+// random returns the random number generator of the Global, creating it from the options.
+func (ls *LState) random() randomSource {
+	if ls.G.random == nil {
+		ls.G.random = newRandomSource(ls.Options.RandomAlgorithm, ls.Options.RandomSeed)
+	}
+	return ls.G.random
+}
+
+// randomFloat returns a float in [0, 1) made of the 53 upper bits of v.
+func randomFloat(v uint64) float64 {
+	return float64(v>>11) * (1.0 / (1 << 53))
+}
+
+// randomProject projects a random value into [0, n] like Lua 5.4: it masks the
+// value with the smallest 2^b-1 not less than n and draws again until it fits.
+func randomProject(r randomSource, v, n uint64) uint64 {
+	if n&(n+1) == 0 {
+		// n+1 is a power of 2
+		return v & n
+	}
+	lim := n
+	for shift := uint(1); shift < 64; shift <<= 1 {
+		lim |= lim >> shift
+	}
+	for v &= lim; v > n; v = r.Uint64() & lim {
+	}
+	return v
+}
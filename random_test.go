@@ -0,0 +1,85 @@
+package lua
+
+import (
+	"testing"
+)
+
+func randomSequence(L *LState, script string) string {
+	if err := L.DoString(script); err != nil {
+		panic(err)
+	}
+	return L.Get(-1).String()
+}
+
+const randomScript = `
+  local t = {}
+  for i = 1, 5 do t[#t + 1] = math.random(1, 1000) end
+  t[#t + 1] = math.random(0)
+  t[#t + 1] = math.random()
+  return table.concat(t, ",")
+`
+
+func TestRandomSeed(t *testing.T) {
+	seed := int64(42)
+	for _, algorithm := range []RandomAlgorithm{RandomXoshiro, RandomPCG} {
+		L1 := NewState(Options{RandomSeed: &seed, RandomAlgorithm: algorithm})
+		defer L1.Close()
+		L2 := NewState(Options{RandomSeed: &seed, RandomAlgorithm: algorithm})
+		defer L2.Close()
+		errorIfNotEqual(t, randomSequence(L1, randomScript), randomSequence(L2, randomScript))
+
+		// reseeding a state does not affect the others
+		errorIfScriptFail(t, L1, `math.randomseed(7)`)
+		s1 := randomSequence(L1, randomScript)
+		errorIfFalse(t, s1 != randomSequence(L2, randomScript), "states should have their own generators")
+		errorIfScriptFail(t, L2, `math.randomseed(7)`)
+		errorIfNotEqual(t, s1, randomSequence(L2, randomScript))
+	}
+
+	L1 := NewState(Options{RandomSeed: &seed})
+	defer L1.Close()
+	L2 := NewState(Options{RandomSeed: &seed, RandomAlgorithm: RandomPCG})
+	defer L2.Close()
+	errorIfFalse(t, randomSequence(L1, randomScript) != randomSequence(L2, randomScript), "algorithms should differ")
+
+	// 0 is a seed like any other
+	zero := int64(0)
+	L3 := NewState(Options{RandomSeed: &zero})
+	defer L3.Close()
+	L4 := NewState(Options{RandomSeed: &zero})
+	defer L4.Close()
+	errorIfNotEqual(t, randomSequence(L3, randomScript), randomSequence(L4, randomScript))
+	L5 := NewState()
+	defer L5.Close()
+	errorIfFalse(t, randomSequence(L3, randomScript) != randomSequence(L5, randomScript), "nil seed should be random")
+}
+
+func TestMathRandom(t *testing.T) {
+	for _, algorithm := range []RandomAlgorithm{RandomXoshiro, RandomPCG, RandomSecure} {
+		L := NewState(Options{RandomAlgorithm: algorithm})
+		defer L.Close()
+		errorIfScriptFail(t, L, `
+		  math.randomseed(1)
+		  local seen = {}
+		  for i = 1, 1000 do
+		    local f = math.random()
+		    assert(f >= 0 and f < 1)
+		    local n = math.random(-3, 3)
+		    assert(n >= -3 and n <= 3 and n % 1 == 0)
+		    seen[n] = true
+		    local m = math.random(6)
+		    assert(m >= 1 and m <= 6)
+		    local r = math.random(0)
+		    assert(r % 1 == 0 and math.abs(r) <= 2^52)
+		  end
+		  for i = -3, 3 do assert(seen[i]) end
+		  assert(math.random(5, 5) == 5)
+		  local big = math.random(-2^53, 2^53)
+		  assert(big % 1 == 0 and math.abs(big) <= 2^53)
+		  local ok, err = pcall(math.random, 2, 1)
+		  assert(not ok and err:find("interval is empty"), err)
+		  ok, err = pcall(math.random, -1)
+		  assert(not ok and err:find("interval is empty"), err)
+		`)
+	}
+}
@@ -0,0 +1,315 @@
+package lua
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/* regexp cache {{{ */
+
+// RegexpMaxLength is the maximum length of the patterns compiled by the regexp library.
+var RegexpMaxLength = 4096
+
+// RegexpCacheSize is the number of compiled patterns the regexp library caches per Global.
+var RegexpCacheSize = 256
+
+// regexpCache is a LRU cache of compiled patterns. A Global is used by one
+// goroutine at a time, so the cache is not locked.
+type regexpCache struct {
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+func (c *regexpCache) compile(pattern string) (*regexp.Regexp, error) {
+	if elem, ok := c.items[pattern]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*regexp.Regexp), nil
+	}
+	if len(pattern) > RegexpMaxLength {
+		return nil, fmt.Errorf("pattern too long (%d > %d bytes)", len(pattern), RegexpMaxLength)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.items[pattern] = c.lru.PushFront(re)
+	for c.lru.Len() > RegexpCacheSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*regexp.Regexp).String())
+	}
+	return re, nil
+}
+
+// compileRegexp compiles a pattern using the cache of the Global.
+func (ls *LState) compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if ls.G.regexps == nil {
+		ls.G.regexps = &regexpCache{lru: list.New(), items: make(map[string]*list.Element)}
+	}
+	return ls.G.regexps.compile(pattern)
+}
+
+/* }}} */
+
+/* regexp library {{{ */
+
+const regexpClass = "regexp.Regexp"
+
+type regexpLib struct {
+	mt *LTable
+}
+
+// OpenRegexp opens the regexp library. It is not opened by OpenLibs.
+//
+// The library uses the RE2 syntax of the regexp package of Go. Its functions
+// can be called with a pattern, as in regexp.find(s, pattern), or as methods of
+// a compiled pattern, as in regexp.compile(pattern):find(s). Positions are
+// byte positions like those of the string library. Patterns with named groups
+// return their captures as a table holding the captures by index and by name.
+func OpenRegexp(L *LState) int {
+	lib := &regexpLib{mt: L.NewTypeMetatable(regexpClass)}
+	methods := map[string]LGFunction{
+		"match": lib.match,
+		"find":  lib.find,
+		"gsub":  lib.gsub,
+		"split": lib.split,
+	}
+	gmatch := L.NewClosure(lib.gmatch, L.NewFunction(regexpGmatchIter))
+	L.SetFuncs(lib.mt, map[string]LGFunction{"__tostring": lib.toString})
+	index := L.SetFuncs(L.NewTable(), methods)
+	index.RawSetString("gmatch", gmatch)
+	lib.mt.RawSetString("__index", index)
+
+	mod := L.RegisterModule(RegexpLibName, methods).(*LTable)
+	mod.RawSetString("gmatch", gmatch)
+	L.SetFuncs(mod, map[string]LGFunction{
+		"compile": lib.compile,
+		"quote":   regexpQuote,
+	})
+	L.Push(mod)
+	return 1
+}
+
+func (lib *regexpLib) compile(L *LState) int {
+	re, err := L.compileRegexp(L.CheckString(1))
+	if err != nil {
+		L.ArgError(1, err.Error())
+	}
+	ud := L.NewUserData()
+	ud.Value = re
+	ud.Metatable = lib.mt
+	L.Push(ud)
+	return 1
+}
+
+func regexpQuote(L *LState) int {
+	L.Push(LString(regexp.QuoteMeta(L.CheckString(1))))
+	return 1
+}
+
+func (lib *regexpLib) toString(L *LState) int {
+	re := L.CheckUserData(1).Value.(*regexp.Regexp)
+	L.Push(LString("regexp: " + re.String()))
+	return 1
+}
+
+// args returns the pattern and the subject of a method call like re:find(s) or
+// a function call like regexp.find(s, pattern). Both have their other
+// arguments starting at index 3.
+func (lib *regexpLib) args(L *LState) (*regexp.Regexp, string) {
+	if ud, ok := L.Get(1).(*LUserData); ok {
+		re, ok := ud.Value.(*regexp.Regexp)
+		if !ok {
+			L.ArgError(1, "regexp expected")
+		}
+		return re, L.CheckString(2)
+	}
+	s := L.CheckString(1)
+	re, err := L.compileRegexp(L.CheckString(2))
+	if err != nil {
+		L.ArgError(2, err.Error())
+	}
+	return re, s
+}
+
+func hasNamedGroups(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if len(name) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pushCaptures pushes the captures of a match, the whole match if the pattern has
+// no groups and whole is true, or a table if it has named groups. Groups which
+// did not participate in the match are nil.
+func pushCaptures(L *LState, re *regexp.Regexp, s string, loc []int, whole bool) int {
+	n := re.NumSubexp()
+	if n == 0 {
+		if !whole {
+			return 0
+		}
+		L.Push(LString(s[loc[0]:loc[1]]))
+		return 1
+	}
+	capture := func(i int) LValue {
+		if loc[2*i] < 0 {
+			return LNil
+		}
+		return LString(s[loc[2*i]:loc[2*i+1]])
+	}
+	if hasNamedGroups(re) {
+		tb := L.CreateTable(n, n)
+		for i, name := range re.SubexpNames() {
+			if i == 0 {
+				continue
+			}
+			value := capture(i)
+			tb.RawSetInt(i, value)
+			if len(name) != 0 {
+				tb.RawSetString(name, value)
+			}
+		}
+		L.Push(tb)
+		return 1
+	}
+	for i := 1; i <= n; i++ {
+		L.Push(capture(i))
+	}
+	return n
+}
+
+func (lib *regexpLib) init(L *LState, s string) int {
+	return luaIndex2StringIndex(s, L.OptInt(3, 1), true)
+}
+
+func (lib *regexpLib) submatch(L *LState) (*regexp.Regexp, string, []int) {
+	re, s := lib.args(L)
+	init := lib.init(L, s)
+	if init > len(s) {
+		return re, s, nil
+	}
+	loc := re.FindStringSubmatchIndex(s[init:])
+	for i := range loc {
+		if loc[i] >= 0 {
+			loc[i] += init
+		}
+	}
+	return re, s, loc
+}
+
+// match(s, pattern [, init]) returns the captures of the first match.
+func (lib *regexpLib) match(L *LState) int {
+	re, s, loc := lib.submatch(L)
+	if loc == nil {
+		L.Push(LNil)
+		return 1
+	}
+	return pushCaptures(L, re, s, loc, true)
+}
+
+// find(s, pattern [, init]) returns the positions of the first match and its captures.
+func (lib *regexpLib) find(L *LState) int {
+	re, s, loc := lib.submatch(L)
+	if loc == nil {
+		L.Push(LNil)
+		return 1
+	}
+	L.Push(LNumber(loc[0] + 1))
+	L.Push(LNumber(loc[1]))
+	return 2 + pushCaptures(L, re, s, loc, false)
+}
+
+type regexpMatchData struct {
+	re      *regexp.Regexp
+	str     string
+	pos     int
+	matches [][]int
+}
+
+func (lib *regexpLib) gmatch(L *LState) int {
+	re, s := lib.args(L)
+	L.Push(L.Get(UpvalueIndex(1)))
+	ud := L.NewUserData()
+	ud.Value = &regexpMatchData{re, s, 0, re.FindAllStringSubmatchIndex(s, -1)}
+	L.Push(ud)
+	return 2
+}
+
+func regexpGmatchIter(L *LState) int {
+	md := L.CheckUserData(1).Value.(*regexpMatchData)
+	if md.pos == len(md.matches) {
+		return 0
+	}
+	loc := md.matches[md.pos]
+	md.pos++
+	return pushCaptures(L, md.re, md.str, loc, true)
+}
+
+// gsub(s, pattern, repl [, n]) replaces the first n matches. A string repl may
+// refer to captures as $1 or ${name}. A table is indexed with the first capture
+// or the whole match, a function is called with the captures. False or nil
+// keep the match.
+func (lib *regexpLib) gsub(L *LState) int {
+	re, s := lib.args(L)
+	L.CheckTypes(3, LTString, LTTable, LTFunction)
+	repl := L.Get(3)
+	matches := re.FindAllStringSubmatchIndex(s, L.OptInt(4, -1))
+	if len(matches) == 0 {
+		L.Push(LString(s))
+		L.Push(LNumber(0))
+		return 2
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range matches {
+		b.WriteString(s[last:loc[0]])
+		last = loc[1]
+		switch lv := repl.(type) {
+		case LString:
+			b.Write(re.ExpandString(nil, string(lv), s, loc))
+			continue
+		case *LTable:
+			key := LString(s[loc[0]:loc[1]])
+			if re.NumSubexp() > 0 && loc[2] >= 0 {
+				key = LString(s[loc[2]:loc[3]])
+			}
+			L.Push(L.GetTable(lv, key))
+		case *LFunction:
+			L.Push(lv)
+			L.Call(pushCaptures(L, re, s, loc, true), 1)
+		}
+		value := L.reg.Pop()
+		if LVIsFalse(value) {
+			b.WriteString(s[loc[0]:loc[1]])
+		} else if str, ok := value.(LString); ok {
+			b.WriteString(string(str))
+		} else if num, ok := value.(LNumber); ok {
+			b.WriteString(num.String())
+		} else {
+			L.RaiseError("invalid replacement value (a %s)", value.Type().String())
+		}
+	}
+	b.WriteString(s[last:])
+	L.Push(LString(b.String()))
+	L.Push(LNumber(len(matches)))
+	return 2
+}
+
+// split(s, pattern [, n]) returns a table of the substrings between the matches,
+// at most n substrings if n is not negative.
+func (lib *regexpLib) split(L *LState) int {
+	re, s := lib.args(L)
+	parts := re.Split(s, L.OptInt(3, -1))
+	tb := L.CreateTable(len(parts), 0)
+	for _, part := range parts {
+		tb.Append(LString(part))
+	}
+	L.Push(tb)
+	return 1
+}
+
+/* }}} */
@@ -0,0 +1,82 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func newRegexpState() *LState {
+	L := NewState()
+	L.PreloadModule(RegexpLibName, OpenRegexp)
+	return L
+}
+
+func TestRegexpLib(t *testing.T) {
+	L := newRegexpState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local regexp = require("regexp")
+	  local re = regexp.compile([[(?i)(error|fatal): (\d{2,3})]])
+	  assert(tostring(re) == [[regexp: (?i)(error|fatal): (\d{2,3})]])
+	  local level, code = re:match("x FATAL: 500")
+	  assert(level == "FATAL" and code == "500")
+	  assert(re:match("error: 5") == nil)
+	  assert(regexp.match("a1b22", [[\d+]]) == "1" and regexp.match("a1b22", [[\d+]], 3) == "22")
+	  local s, e, l = re:find("ok, error: 404")
+	  assert(s == 5 and e == 14 and l == "error")
+	  s, e = regexp.find("aaa", "a+?")
+	  assert(s == 1 and e == 1 and select("#", regexp.find("aaa", "a+?")) == 2)
+	  assert(regexp.find("abc", "b", -1) == nil and regexp.find("abc", "x") == nil)
+
+	  local m = regexp.match("user=bob id=7", [[user=(?P<user>\w+) id=(?P<id>\d+)(x)?]])
+	  assert(m.user == "bob" and m.id == "7" and m[1] == "bob" and m[2] == "7" and m[3] == nil)
+
+	  local words = {}
+	  for w in regexp.gmatch("one two  three", [[\w+]]) do words[#words + 1] = w end
+	  assert(table.concat(words, ",") == "one,two,three")
+	  local pairs_ = {}
+	  for k, v in regexp.compile([[(\w+)=(\w+)]]):gmatch("a=1, b=2") do pairs_[#pairs_ + 1] = k .. v end
+	  assert(table.concat(pairs_, ",") == "a1,b2")
+	  for t in regexp.gmatch("k:v", [[(?P<key>\w+):(?P<value>\w+)]]) do assert(t.key == "k" and t.value == "v") end
+
+	  assert(regexp.gsub("a1b22", [[\d+]], "<$0>") == "a<1>b<22>")
+	  assert(regexp.gsub("john smith", [[(?P<first>\w+) (?P<last>\w+)]], "${last}, ${first}") == "smith, john")
+	  local r, n = regexp.gsub("a b c", " ", "", 1)
+	  assert(r == "ab c" and n == 1)
+	  assert(regexp.gsub("$x $y", [[\$(\w)]], {x = "1"}) == "1 $y")
+	  assert(regexp.gsub("1 2 3", [[\d]], function(d) return d * 2 end) == "2 4 6")
+	  assert(select(2, regexp.gsub("abc", "x", "y")) == 0)
+
+	  local parts = regexp.split("a, b;c", [[[,;]\s*]])
+	  assert(#parts == 3 and parts[1] == "a" and parts[3] == "c")
+	  assert(#regexp.split("a,b,c", ",", 2) == 2)
+	  assert(regexp.quote("a.b*") == [[a\.b\*]])
+
+	  local ok, err = pcall(regexp.compile, "(")
+	  assert(not ok and err:find("missing closing %)"), err)
+	  ok, err = pcall(regexp.match, "x", "(?=x)")
+	  assert(not ok and err:find("invalid or unsupported Perl syntax"), err)
+	  ok, err = pcall(regexp.gsub, "x", "x", true)
+	  assert(not ok)
+	`)
+}
+
+func TestRegexpLimits(t *testing.T) {
+	L := newRegexpState()
+	defer L.Close()
+	L.SetGlobal("long", LString(strings.Repeat("a", RegexpMaxLength+1)))
+	errorIfScriptNotFail(t, L, `require("regexp").compile(long)`, "pattern too long")
+
+	defer func(size int) { RegexpCacheSize = size }(RegexpCacheSize)
+	RegexpCacheSize = 2
+	errorIfScriptFail(t, L, `
+	  local regexp = require("regexp")
+	  for _, p in ipairs({"a", "b", "a", "c"}) do regexp.match("abc", p) end
+	`)
+	errorIfNotEqual(t, 2, L.G.regexps.lru.Len())
+	_, ok := L.G.regexps.items["b"]
+	errorIfFalse(t, !ok, "least recently used pattern should be evicted")
+	re1, _ := L.compileRegexp("a")
+	re2, _ := L.compileRegexp("a")
+	errorIfFalse(t, re1 == re2, "compiled patterns should be cached")
+}
@@ -0,0 +1,219 @@
+package lua
+
+/* sandbox profiles {{{ */
+
+// SandboxProfile describes the libraries and functions available in a sandboxed state.
+type SandboxProfile struct {
+	// Name is the name of the profile, e.g. "rule".
+	Name string
+	// Libs maps the names of the libraries to open to the names of their allowed
+	// functions. A list containing "*" allows all functions of a library. The base
+	// library is named BaseLibName. Functions which are not allowed raise an error
+	// when called.
+	Libs map[string][]string
+	// ReadOnly replaces the library tables by read-only proxies, prevents the
+	// builtin globals from being replaced and protects the metatables of builtin
+	// types.
+	ReadOnly bool
+	// FileModules allows require to load modules from package.path. Otherwise
+	// only modules from package.preload can be required.
+	FileModules bool
+}
+
+var sandboxBaseFuncs = []string{
+	"assert", "error", "getmetatable", "ipairs", "load", "loadstring", "next", "pairs",
+	"pcall", "print", "rawequal", "select", "setmetatable",
+	"tonumber", "tostring", "type", "unpack", "xpcall",
+}
+
+var (
+	// SandboxPure allows side-effect free computations only.
+	SandboxPure = &SandboxProfile{
+		Name: "pure",
+		Libs: map[string][]string{
+			BaseLibName:   sandboxBaseFuncs,
+			TabLibName:    {"*"},
+			StringLibName: {"*"},
+			Utf8LibName:   {"*"},
+			MathLibName:   {"*"},
+		},
+		ReadOnly: true,
+	}
+	// SandboxRule is meant for rules: in addition to SandboxPure it allows
+	// coroutines, requiring preloaded modules and reading the clock.
+	SandboxRule = &SandboxProfile{
+		Name: "rule",
+		Libs: map[string][]string{
+			LoadLibName:      {},
+			BaseLibName:      append([]string{"require"}, sandboxBaseFuncs...),
+			TabLibName:       {"*"},
+			StringLibName:    {"*"},
+			Utf8LibName:      {"*"},
+			MathLibName:      {"*"},
+			CoroutineLibName: {"*"},
+			OsLibName:        {"clock", "date", "difftime", "time"},
+		},
+		ReadOnly: true,
+	}
+	// SandboxTrusted opens all libraries like OpenLibs does.
+	SandboxTrusted = &SandboxProfile{
+		Name: "trusted",
+		Libs: map[string][]string{
+			LoadLibName:      {"*"},
+			BaseLibName:      {"*"},
+			TabLibName:       {"*"},
+			IoLibName:        {"*"},
+			OsLibName:        {"*"},
+			StringLibName:    {"*"},
+			Utf8LibName:      {"*"},
+			MathLibName:      {"*"},
+			DebugLibName:     {"*"},
+			ChannelLibName:   {"*"},
+			CoroutineLibName: {"*"},
+		},
+		FileModules: true,
+	}
+)
+
+// SandboxProfiles are the sandbox profiles selectable by name, e.g. by the glua command.
+var SandboxProfiles = map[string]*SandboxProfile{
+	SandboxPure.Name:    SandboxPure,
+	SandboxRule.Name:    SandboxRule,
+	SandboxTrusted.Name: SandboxTrusted,
+}
+
+func (profile *SandboxProfile) allows(lib, fn string) bool {
+	for _, name := range profile.Libs[lib] {
+		if name == "*" || name == fn {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenSandbox opens the libraries allowed by the given profile.
+func (ls *LState) OpenSandbox(profile *SandboxProfile) {
+	for _, lib := range luaLibs {
+		if _, ok := profile.Libs[lib.libName]; !ok {
+			continue
+		}
+		ls.Push(ls.NewFunction(lib.libFunc))
+		ls.Push(LString(lib.libName))
+		ls.Call(1, 1)
+		mod, ok := ls.reg.Pop().(*LTable)
+		if !ok {
+			continue
+		}
+		ls.sandboxFuncs(profile, lib.libName, mod)
+	}
+	loaded, _ := ls.G.Registry.RawGetString("_LOADED").(*LTable)
+	if !profile.FileModules && loaded != nil {
+		if pkg, ok := loaded.RawGetString(LoadLibName).(*LTable); ok {
+			loaders := ls.CreateTable(1, 0)
+			pkg.RawSetString("loaders", loaders)
+			ls.G.Registry.RawSetString("_LOADERS", loaders)
+			ls.addLoader(PreloadSearcherPriority, ls.NewFunction(loLoaderPreload))
+			pkg.RawSetString("path", emptyLString)
+		}
+	}
+	if profile.ReadOnly {
+		for _, lib := range luaLibs {
+			if _, ok := profile.Libs[lib.libName]; !ok || lib.libName == BaseLibName {
+				continue
+			}
+			mod, ok := ls.G.Global.RawGetString(lib.libName).(*LTable)
+			if !ok {
+				continue
+			}
+			proxy := ls.readOnlyProxy(lib.libName, mod)
+			ls.G.Global.RawSetString(lib.libName, proxy)
+			if loaded != nil {
+				loaded.RawSetString(lib.libName, proxy)
+			}
+		}
+		for _, mt := range ls.G.builtinMts {
+			if tb, ok := mt.(*LTable); ok {
+				tb.RawSetString("__metatable", LFalse)
+			}
+		}
+		ls.protectGlobals()
+	}
+}
+
+// sandboxFuncs replaces the functions of a library which are not allowed by
+// the profile.
+func (ls *LState) sandboxFuncs(profile *SandboxProfile, libName string, mod *LTable) {
+	var names []string
+	mod.ForEach(func(k, v LValue) {
+		if _, ok := v.(*LFunction); ok {
+			if name, ok := k.(LString); ok {
+				names = append(names, string(name))
+			}
+		}
+	})
+	for _, name := range names {
+		qualified := name
+		if libName != BaseLibName {
+			qualified = libName + "." + name
+		}
+		if !profile.allows(libName, name) {
+			mod.RawSetString(name, ls.NewFunction(func(L *LState) int {
+				L.RaiseError("%s is not permitted in sandbox", qualified)
+				return 0
+			}))
+		}
+	}
+}
+
+// readOnlyProxy returns a table which reads from the given table and raises an
+// error on writes. Its metatable is protected.
+func (ls *LState) readOnlyProxy(name string, tb *LTable) *LTable {
+	proxy := ls.NewTable()
+	mt := ls.NewTable()
+	mt.RawSetString("__index", tb)
+	mt.RawSetString("__newindex", ls.NewFunction(func(L *LState) int {
+		L.RaiseError("attempt to modify read-only table '%s'", name)
+		return 0
+	}))
+	next := ls.NewFunction(baseNext)
+	mt.RawSetString("__pairs", ls.NewFunction(func(L *LState) int {
+		L.Push(next)
+		L.Push(tb)
+		L.Push(LNil)
+		return 3
+	}))
+	mt.RawSetString("__len", ls.NewFunction(func(L *LState) int {
+		L.Push(LNumber(tb.Len()))
+		return 1
+	}))
+	mt.RawSetString("__metatable", LFalse)
+	proxy.Metatable = mt
+	return proxy
+}
+
+// protectGlobals moves the current globals into a table behind the metatable
+// of _G. Scripts can still define new globals, but not replace the builtin ones.
+func (ls *LState) protectGlobals() {
+	globals := ls.G.Global
+	builtins := ls.NewTable()
+	globals.ForEach(func(k, v LValue) {
+		builtins.RawSet(k, v)
+	})
+	builtins.ForEach(func(k, _ LValue) {
+		globals.RawSet(k, LNil)
+	})
+	mt := ls.NewTable()
+	mt.RawSetString("__index", builtins)
+	mt.RawSetString("__newindex", ls.NewFunction(func(L *LState) int {
+		tb, key := L.CheckTable(1), L.Get(2)
+		if builtins.RawGet(key) != LNil {
+			L.RaiseError("attempt to modify read-only global '%s'", key.String())
+		}
+		tb.RawSet(key, L.Get(3))
+		return 0
+	}))
+	mt.RawSetString("__metatable", LFalse)
+	globals.Metatable = mt
+}
+
+/* }}} */
@@ -0,0 +1,53 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestSandboxPure(t *testing.T) {
+	L := NewState(Options{Sandbox: SandboxPure})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(io == nil and os == nil and debug == nil and package == nil)
+	  assert(string.format("%d", 1) == "1" and ("x"):upper() == "X")
+	  local n = 0
+	  for k, v in pairs(math) do n = n + 1 end
+	  assert(n > 0 and getmetatable(math) == false and getmetatable("") == false)
+	  assert(loadstring("return 1")() == 1)
+	`)
+	errorIfScriptNotFail(t, L, `dofile("x.lua")`, `dofile is not permitted in sandbox`)
+	errorIfScriptNotFail(t, L, `require("x")`, `require is not permitted in sandbox`)
+	errorIfScriptNotFail(t, L, `string.upper = nil`, `attempt to modify read-only table 'string'`)
+	errorIfScriptNotFail(t, L, `setmetatable(math, {})`, `cannot change a protected metatable`)
+	errorIfScriptNotFail(t, L, `rawset(string, "upper", print)`, `rawset is not permitted in sandbox`)
+	errorIfScriptNotFail(t, L, `rawget(string, "upper")`, `rawget is not permitted in sandbox`)
+	errorIfScriptNotFail(t, L, `string = {upper = print}`, `attempt to modify read-only global 'string'`)
+	errorIfScriptNotFail(t, L, `_G.print = nil`, `attempt to modify read-only global 'print'`)
+	errorIfScriptNotFail(t, L, `setmetatable(_G, nil)`, `cannot change a protected metatable`)
+	errorIfScriptFail(t, L, `
+	  counter = 1
+	  counter = counter + 1
+	  assert(counter == 2 and string.upper("x") == "X" and _G.string == string)
+	`)
+}
+
+func TestSandboxRule(t *testing.T) {
+	L := NewState(Options{Sandbox: SandboxRule})
+	defer L.Close()
+	L.PreloadModule("helpers", func(L *LState) int {
+		L.Push(L.NewTable())
+		return 1
+	})
+	errorIfScriptFail(t, L, `
+	  assert(type(os.time()) == "number" and io == nil)
+	  assert(require("helpers"))
+	  assert(not pcall(require, "nonexistent"))
+	`)
+	errorIfScriptNotFail(t, L, `os.execute("ls")`, `os.execute is not permitted in sandbox`)
+	errorIfScriptNotFail(t, L, `os.remove("x")`, `os.remove is not permitted in sandbox`)
+	errorIfScriptNotFail(t, L, `os.exit = print`, `read-only table 'os'`)
+
+	L2 := NewState(Options{Sandbox: SandboxTrusted})
+	defer L2.Close()
+	errorIfScriptFail(t, L2, `assert(io.open and os.execute and debug.getinfo and getmetatable("").__index == string)`)
+}
@@ -0,0 +1,210 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+/* searchers {{{ */
+
+const (
+	// PreloadSearcherPriority is the priority of the searcher for package.preload.
+	PreloadSearcherPriority = 200
+	// PathSearcherPriority is the priority of the searcher for package.path.
+	PathSearcherPriority = 100
+)
+
+// ModuleFormat is the format of the source of a module.
+type ModuleFormat int
+
+const (
+	// ModuleLua is Lua source code.
+	ModuleLua ModuleFormat = iota
+	// ModuleRuleAST is a JSON array of statements decoded by ast.ParseRule.
+	ModuleRuleAST
+)
+
+// ModuleSource is a module found by a Searcher.
+type ModuleSource struct {
+	// Name is the chunk name used in error messages, e.g. "db:rules.auth".
+	Name   string
+	Source []byte
+	Format ModuleFormat
+}
+
+// ErrModuleNotFound is matched by errors.Is for the errors returned by ModuleNotFound.
+var ErrModuleNotFound = errors.New("module not found")
+
+type moduleNotFoundError struct {
+	msg string
+}
+
+func (e *moduleNotFoundError) Error() string        { return e.msg }
+func (e *moduleNotFoundError) Is(target error) bool { return target == ErrModuleNotFound }
+
+// ModuleNotFound returns an error telling require that a searcher does not have a
+// module. The message is a part of the error raised by require if no searcher
+// has the module, e.g. "no row 'x' in modules".
+func ModuleNotFound(format string, args ...interface{}) error {
+	return &moduleNotFoundError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Searcher finds the source of modules for require.
+type Searcher interface {
+	// Search returns the source of a module or an error created by
+	// ModuleNotFound. Other errors are raised by require.
+	Search(L *LState, name string) (*ModuleSource, error)
+}
+
+// SearcherFunc adapts a function to a Searcher.
+type SearcherFunc func(L *LState, name string) (*ModuleSource, error)
+
+func (fn SearcherFunc) Search(L *LState, name string) (*ModuleSource, error) { return fn(L, name) }
+
+// AddSearcher adds a searcher to package.loaders. Searchers with a higher priority
+// run first, see PreloadSearcherPriority and PathSearcherPriority. Compiled modules
+// are shared by all states of the process as long as their name and source are the same.
+func (ls *LState) AddSearcher(priority int, searcher Searcher) {
+	ls.addLoader(priority, ls.NewFunction(func(L *LState) int {
+		name := L.CheckString(1)
+		src, err := searcher.Search(L, name)
+		if err != nil {
+			if errors.Is(err, ErrModuleNotFound) {
+				L.Push(LString(err.Error()))
+				return 1
+			}
+			L.RaiseError("error loading module '%s': %s", name, err.Error())
+		}
+		proto, err := compileModule(L, src)
+		if err != nil {
+			L.RaiseError("error loading module '%s': %s", name, err.Error())
+		}
+		L.Push(L.NewFunctionFromProto(proto))
+		return 1
+	}))
+}
+
+// addLoader inserts a loader before the first loader of package.loaders with a
+// lower priority. Loaders added by Lua code keep their positions.
+func (ls *LState) addLoader(priority int, loader *LFunction) {
+	loaders, ok := ls.G.Registry.RawGetString("_LOADERS").(*LTable)
+	if !ok {
+		ls.RaiseError("package.loaders must be a table")
+	}
+	if ls.G.loaderPriorities == nil {
+		ls.G.loaderPriorities = make(map[*LFunction]int)
+	}
+	ls.G.loaderPriorities[loader] = priority
+	pos := loaders.Len() + 1
+	for i := 1; i <= loaders.Len(); i++ {
+		fn, ok := loaders.RawGetInt(i).(*LFunction)
+		if !ok {
+			continue
+		}
+		if p, ok := ls.G.loaderPriorities[fn]; ok && p < priority {
+			pos = i
+			break
+		}
+	}
+	loaders.Insert(pos, loader)
+}
+
+/* }}} */
+
+/* module cache {{{ */
+
+var moduleProtoCache *ProtoCache
+var moduleProtoCacheOnce sync.Once
+
+// compileModule compiles a module once per process for a given name and source,
+// using Options.ProtoCache if it is set.
+func compileModule(L *LState, src *ModuleSource) (*FunctionProto, error) {
+	cache := L.Options.ProtoCache
+	if cache == nil {
+		moduleProtoCacheOnce.Do(func() { moduleProtoCache = NewProtoCache(DefaultProtoCacheSize) })
+		cache = moduleProtoCache
+	}
+	return cache.compile(src.Format, src.Name, src.Source)
+}
+
+/* }}} */
+
+/* searchpath {{{ */
+
+// searchPath returns the first existing file of the ';' separated templates in
+// path or the names of the files it tried.
+func searchPath(fsys fs.FS, name, path, sep, rep string) (string, []string) {
+	if len(sep) != 0 {
+		name = strings.Replace(name, sep, rep, -1)
+	}
+	var tried []string
+	for _, pattern := range strings.Split(path, ";") {
+		filename := strings.Replace(pattern, "?", name, -1)
+		if _, err := fs.Stat(fsys, filename); err == nil {
+			return filename, nil
+		}
+		tried = append(tried, filename)
+	}
+	return "", tried
+}
+
+func noFileMessage(tried []string) string {
+	var buf strings.Builder
+	for _, filename := range tried {
+		fmt.Fprintf(&buf, "\n\tno file '%s'", filename)
+	}
+	return buf.String()
+}
+
+func loSearchPath(L *LState) int {
+	name := L.CheckString(1)
+	path := L.CheckString(2)
+	sep := L.OptString(3, ".")
+	rep := L.OptString(4, string(os.PathSeparator))
+	filename, tried := searchPath(L.fileSystem(), name, path, sep, rep)
+	if len(filename) == 0 {
+		L.Push(LNil)
+		L.Push(LString(noFileMessage(tried)))
+		return 2
+	}
+	L.Push(LString(filename))
+	return 1
+}
+
+/* }}} */
+
+/* builtin searchers {{{ */
+
+type ruleSearcher struct {
+	fsys fs.FS
+	path string
+}
+
+// NewRuleSearcher returns a Searcher loading rule ASTs in the JSON format of
+// ast.ParseRule. path is a ';' separated list of templates like package.path,
+// e.g. "rules/?.json". If fsys is nil, the file system of the state is used.
+func NewRuleSearcher(fsys fs.FS, path string) Searcher {
+	return &ruleSearcher{fsys: fsys, path: path}
+}
+
+func (s *ruleSearcher) Search(L *LState, name string) (*ModuleSource, error) {
+	fsys := s.fsys
+	if fsys == nil {
+		fsys = L.fileSystem()
+	}
+	filename, tried := searchPath(fsys, name, s.path, ".", "/")
+	if len(filename) == 0 {
+		return nil, ModuleNotFound("%s", strings.TrimPrefix(noFileMessage(tried), "\n\t"))
+	}
+	source, err := fs.ReadFile(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	return &ModuleSource{Name: filename, Source: source, Format: ModuleRuleAST}, nil
+}
+
+/* }}} */
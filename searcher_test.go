@@ -0,0 +1,47 @@
+package lua
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestSearchers(t *testing.T) {
+	modules := map[string]string{
+		"go.helpers": `return {answer = 42}`,
+		"go.broken":  `return {`,
+	}
+	goSearcher := SearcherFunc(func(L *LState, name string) (*ModuleSource, error) {
+		source, ok := modules[name]
+		if !ok {
+			return nil, ModuleNotFound("no module '%s' in Go", name)
+		}
+		return &ModuleSource{Name: "go:" + name, Source: []byte(source)}, nil
+	})
+	rules := fstest.MapFS{
+		"rules/risk.json": &fstest.MapFile{Data: []byte(`[{"lhs":[{"value":"risk","_type":"ident_expr"}],"rhs":[{"value":"7","_type":"number_expr"}],"_type":"assign_stmt"}]`)},
+	}
+
+	L := NewState()
+	defer L.Close()
+	L.AddSearcher(PathSearcherPriority-1, goSearcher)
+	L.AddSearcher(PreloadSearcherPriority+1, NewRuleSearcher(rules, "rules/?.json"))
+	errorIfScriptFail(t, L, `
+	  assert(#package.loaders == 4)
+	  assert(require("go.helpers").answer == 42)
+	  assert(require("risk") == true and risk == 7)
+	  local ok, err = pcall(require, "go.broken")
+	  assert(not ok and err:find("error loading module 'go.broken'"), err)
+	  ok, err = pcall(require, "missing")
+	  assert(not ok and err:find("no module 'missing' in Go") and err:find("no file 'rules/missing.json'"), err)
+	`)
+}
+
+func TestSearchPath(t *testing.T) {
+	L := NewState(Options{FS: NewMemFS(map[string][]byte{"lib/a/b.lua": nil})})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(package.searchpath("a.b", "x/?.lua;lib/?.lua", ".", "/") == "lib/a/b.lua")
+	  local name, err = package.searchpath("a.c", "x/?.lua;lib/?.lua", ".", "/")
+	  assert(name == nil and err == "\n\tno file 'x/a/c.lua'\n\tno file 'lib/a/c.lua'", err)
+	`)
+}
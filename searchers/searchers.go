@@ -0,0 +1,120 @@
+// Package searchers provides lua.Searcher implementations loading modules from
+// databases and HTTP servers. They live outside of the lua package so that
+// programs which do not use them do not link database/sql and net/http.
+package searchers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func searchContext(L *lua.LState) context.Context {
+	if ctx := L.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+type sqlSearcher struct {
+	db    *sql.DB
+	query string
+}
+
+// NewSQLSearcher returns a Searcher loading the Lua source of modules from a
+// database. The query selects the source of the module whose name is its only
+// argument, e.g. "SELECT source FROM modules WHERE name = ?".
+func NewSQLSearcher(db *sql.DB, query string) lua.Searcher {
+	return &sqlSearcher{db: db, query: query}
+}
+
+func (s *sqlSearcher) Search(L *lua.LState, name string) (*lua.ModuleSource, error) {
+	var source []byte
+	if err := s.db.QueryRowContext(searchContext(L), s.query, name).Scan(&source); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, lua.ModuleNotFound("no module '%s' in database", name)
+		}
+		return nil, err
+	}
+	return &lua.ModuleSource{Name: "db:" + name, Source: source}, nil
+}
+
+// HTTPSearcher loads the Lua source of modules over HTTP and caches them in
+// memory. Cached modules are revalidated with their ETag and used as long as
+// the server can not be reached.
+type HTTPSearcher struct {
+	// BaseURL is the URL modules are loaded from: the module "a.b" is loaded
+	// from BaseURL + "/a/b.lua".
+	BaseURL string
+	// Client is used for the requests, http.DefaultClient by default.
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*httpModule
+}
+
+type httpModule struct {
+	etag   string
+	source []byte
+}
+
+// NewHTTPSearcher returns a HTTPSearcher loading modules from the given URL.
+func NewHTTPSearcher(baseURL string) *HTTPSearcher {
+	return &HTTPSearcher{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (s *HTTPSearcher) Search(L *lua.LState, name string) (*lua.ModuleSource, error) {
+	url := s.BaseURL + "/" + strings.Replace(name, ".", "/", -1) + ".lua"
+	s.mu.Lock()
+	cached := s.cache[url]
+	s.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(searchContext(L), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && len(cached.etag) != 0 {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if cached != nil {
+			return &lua.ModuleSource{Name: url, Source: cached.source}, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return &lua.ModuleSource{Name: url, Source: cached.source}, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, lua.ModuleNotFound("no module at '%s'", url)
+	case resp.StatusCode != http.StatusOK:
+		if cached != nil {
+			return &lua.ModuleSource{Name: url, Source: cached.source}, nil
+		}
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	source, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.cache == nil {
+		s.cache = make(map[string]*httpModule)
+	}
+	s.cache[url] = &httpModule{etag: resp.Header.Get("ETag"), source: source}
+	s.mu.Unlock()
+	return &lua.ModuleSource{Name: url, Source: source}, nil
+}
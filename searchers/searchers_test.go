@@ -0,0 +1,127 @@
+package searchers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// modulesDriver is a database/sql driver serving a fixed set of modules.
+type modulesDriver map[string]string
+
+func (d modulesDriver) Open(name string) (driver.Conn, error) { return modulesConn(d), nil }
+
+type modulesConn map[string]string
+
+func (c modulesConn) Prepare(query string) (driver.Stmt, error) { return modulesStmt(c), nil }
+func (c modulesConn) Close() error                              { return nil }
+func (c modulesConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type modulesStmt map[string]string
+
+func (s modulesStmt) Close() error  { return nil }
+func (s modulesStmt) NumInput() int { return 1 }
+func (s modulesStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+func (s modulesStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &modulesRows{}
+	if source, ok := s[args[0].(string)]; ok {
+		rows.values = []string{source}
+	}
+	return rows, nil
+}
+
+type modulesRows struct {
+	values []string
+}
+
+func (r *modulesRows) Columns() []string { return []string{"source"} }
+func (r *modulesRows) Close() error      { return nil }
+func (r *modulesRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = []byte(r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("lua_modules", modulesDriver{
+		"db.helpers": `return {answer = 42}`,
+		"db.broken":  `return {`,
+	})
+}
+
+func TestSQLSearcher(t *testing.T) {
+	db, err := sql.Open("lua_modules", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	L := lua.NewState()
+	defer L.Close()
+	L.AddSearcher(lua.PathSearcherPriority-1, NewSQLSearcher(db, "SELECT source FROM modules WHERE name = ?"))
+	if err := L.DoString(`
+	  assert(require("db.helpers").answer == 42)
+	  local ok, err = pcall(require, "db.broken")
+	  assert(not ok and err:find("error loading module 'db.broken'"), err)
+	  ok, err = pcall(require, "missing")
+	  assert(not ok and err:find("no module 'missing' in database"), err)
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPSearcher(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/remote/util.lua" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, `return {remote = true}`)
+	}))
+	defer server.Close()
+	remote := NewHTTPSearcher(server.URL)
+
+	for i := 0; i < 2; i++ {
+		L := lua.NewState()
+		L.AddSearcher(lua.PathSearcherPriority-1, remote)
+		err := L.DoString(`
+		  assert(require("remote.util").remote)
+		  local ok, err = pcall(require, "missing")
+		  assert(not ok and err:find("no module at '.*/missing.lua'"), err)
+		`)
+		L.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Fatalf("4 requests expected, but got %d", n)
+	}
+
+	// stale modules are served while the server is down
+	server.Close()
+	L := lua.NewState()
+	defer L.Close()
+	L.AddSearcher(lua.PathSearcherPriority, remote)
+	if err := L.DoString(`assert(require("remote.util").remote)`); err != nil {
+		t.Fatal(err)
+	}
+}
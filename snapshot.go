@@ -0,0 +1,209 @@
+package lua
+
+import (
+	"errors"
+)
+
+/* snapshots {{{ */
+
+// ErrSnapshotInvalid is returned by LState.Restore for snapshots which were
+// released or taken from another state.
+var ErrSnapshotInvalid = errors.New("lua: snapshot is no longer valid")
+
+// Snapshot is a copy-on-write checkpoint of the tables reachable from the
+// globals, the registry (including the loaded packages) and the builtin
+// metatables of a state, including the tables reachable from the closed
+// upvalues of their functions. Taking a snapshot does not copy any table:
+// tables save the original values of the keys they modify on their first
+// write, so restoring a snapshot costs time proportional to the modified keys
+// and the upvalues.
+//
+// Values held by userdata and by upvalues of running functions are not tracked.
+type Snapshot struct {
+	g          *Global
+	env        *LTable
+	tables     []*LTable
+	dirty      []*LTable
+	upvalues   []savedUpvalue
+	builtinMts map[int]LValue
+	released   bool
+}
+
+// savedUpvalue is the value of a closed upvalue when the snapshot was taken.
+type savedUpvalue struct {
+	uv    *Upvalue
+	value LValue
+}
+
+// tableJournal records the original contents of a tracked table.
+type tableJournal struct {
+	owner      *Snapshot
+	dirty      bool
+	array      []LValue
+	arraySaved bool
+	hash       map[LValue]LValue
+	metatable  LValue
+	mtSaved    bool
+}
+
+func (j *tableJournal) markDirty(tb *LTable) {
+	if !j.dirty {
+		j.dirty = true
+		j.owner.dirty = append(j.owner.dirty, tb)
+	}
+}
+
+func (j *tableJournal) saveKey(tb *LTable, key LValue) {
+	if j.hash == nil {
+		j.hash = make(map[LValue]LValue)
+	}
+	if _, ok := j.hash[key]; !ok {
+		j.hash[key] = tb.RawGetH(key)
+	}
+	j.markDirty(tb)
+}
+
+func (j *tableJournal) saveArray(tb *LTable) {
+	if !j.arraySaved {
+		j.arraySaved = true
+		if tb.array != nil {
+			j.array = make([]LValue, len(tb.array), cap(tb.array))
+			copy(j.array, tb.array)
+		}
+	}
+	j.markDirty(tb)
+}
+
+func (j *tableJournal) saveMetatable(tb *LTable) {
+	if !j.mtSaved {
+		j.mtSaved = true
+		j.metatable = tb.Metatable
+	}
+	j.markDirty(tb)
+}
+
+// rollback restores the saved contents of the table and clears the journal.
+func (j *tableJournal) rollback(tb *LTable) {
+	tb.journal = nil
+	if j.arraySaved {
+		tb.array = j.array
+	}
+	for key, value := range j.hash {
+		tb.RawSetH(key, value)
+	}
+	if j.mtSaved {
+		tb.Metatable = j.metatable
+	}
+	*j = tableJournal{owner: j.owner}
+	tb.journal = j
+}
+
+// Snapshot takes a snapshot of the state. Only the most recent snapshot of a
+// state can be restored, taking a snapshot releases the previous one.
+func (ls *LState) Snapshot() *Snapshot {
+	if j := ls.G.Global.journal; j != nil {
+		j.owner.Release()
+	}
+	snapshot := &Snapshot{
+		g:          ls.G,
+		env:        ls.Env,
+		builtinMts: make(map[int]LValue, len(ls.G.builtinMts)),
+	}
+	for k, v := range ls.G.builtinMts {
+		snapshot.builtinMts[k] = v
+	}
+	var stack []*LTable
+	var fns []*LFunction
+	seen := make(map[*LFunction]bool)
+	track := func(lv LValue) {
+		switch v := lv.(type) {
+		case *LTable:
+			if v.journal == nil {
+				v.journal = &tableJournal{owner: snapshot}
+				snapshot.tables = append(snapshot.tables, v)
+				stack = append(stack, v)
+			}
+		case *LFunction:
+			if !seen[v] {
+				seen[v] = true
+				fns = append(fns, v)
+			}
+		}
+	}
+	track(ls.G.Global)
+	track(ls.G.Registry)
+	track(ls.Env)
+	for _, mt := range ls.G.builtinMts {
+		track(mt)
+	}
+	saved := make(map[*Upvalue]bool)
+	for len(stack) > 0 || len(fns) > 0 {
+		if len(fns) > 0 {
+			fn := fns[len(fns)-1]
+			fns = fns[:len(fns)-1]
+			if fn.Env != nil {
+				track(fn.Env)
+			}
+			for _, uv := range fn.Upvalues {
+				if uv != nil && uv.IsClosed() && !saved[uv] {
+					saved[uv] = true
+					snapshot.upvalues = append(snapshot.upvalues, savedUpvalue{uv, uv.value})
+					track(uv.value)
+				}
+			}
+			continue
+		}
+		tb := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		track(tb.Metatable)
+		tb.ForEach(func(k, v LValue) {
+			track(k)
+			track(v)
+		})
+	}
+	return snapshot
+}
+
+// Restore rolls the state back to the given snapshot. The snapshot stays valid
+// and can be restored again.
+func (ls *LState) Restore(snapshot *Snapshot) error {
+	if snapshot.released || snapshot.g != ls.G {
+		return ErrSnapshotInvalid
+	}
+	for _, tb := range snapshot.dirty {
+		tb.journal.rollback(tb)
+	}
+	snapshot.dirty = snapshot.dirty[:0]
+	for _, saved := range snapshot.upvalues {
+		saved.uv.value = saved.value
+	}
+	for k := range ls.G.builtinMts {
+		if _, ok := snapshot.builtinMts[k]; !ok {
+			delete(ls.G.builtinMts, k)
+		}
+	}
+	for k, v := range snapshot.builtinMts {
+		ls.G.builtinMts[k] = v
+	}
+	ls.Env = snapshot.env
+	return nil
+}
+
+// Release stops tracking the changes of the state. The snapshot can not be
+// restored afterwards.
+func (snapshot *Snapshot) Release() {
+	if snapshot.released {
+		return
+	}
+	snapshot.released = true
+	for _, tb := range snapshot.tables {
+		if tb.journal != nil && tb.journal.owner == snapshot {
+			tb.journal = nil
+		}
+	}
+	snapshot.tables = nil
+	snapshot.dirty = nil
+	snapshot.upvalues = nil
+}
+
+/* }}} */
@@ -0,0 +1,74 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  config = {limits = {1, 2, 3}, name = "base"}
+	  counter = 0
+	`)
+	snapshot := L.Snapshot()
+	for i := 0; i < 2; i++ {
+		errorIfScriptFail(t, L, `
+		  assert(counter == 0 and config.name == "base" and #config.limits == 3)
+		  assert(tenant == nil and string.tenant == nil and getmetatable(config) == nil)
+		  assert(getmetatable("").__index == string)
+		  counter = counter + 1
+		  config.name = "tenant"
+		  config.limits[4] = 4
+		  table.sort(config.limits, function(a, b) return a > b end)
+		  table.remove(config.limits, 1)
+		  config[{}] = true
+		  tenant = {secret = 1}
+		  string.tenant = true
+		  package.loaded.tenant = tenant
+		  setmetatable(config, {})
+		  getmetatable("").__index = {}
+		  print = nil
+		`)
+		errorIfNotNil(t, L.Restore(snapshot))
+	}
+	errorIfScriptFail(t, L, `
+	  assert(config.limits[1] == 1 and config.limits[3] == 3 and config.limits[4] == nil)
+	  for k in pairs(config) do assert(type(k) == "string", k) end
+	  assert(package.loaded.tenant == nil and type(print) == "function")
+	`)
+
+	newer := L.Snapshot()
+	errorIfNotEqual(t, ErrSnapshotInvalid, L.Restore(snapshot))
+	newer.Release()
+	errorIfNotEqual(t, ErrSnapshotInvalid, L.Restore(newer))
+	errorIfScriptFail(t, L, `counter = 5`)
+	errorIfNotEqual(t, LNumber(5), L.GetGlobal("counter"))
+
+	L2 := NewState()
+	defer L2.Close()
+	errorIfNotEqual(t, ErrSnapshotInvalid, L2.Restore(L.Snapshot()))
+}
+
+func TestSnapshotUpvalues(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local count = 0
+	  local seen = {}
+	  local function remember(k) seen[k] = true end
+	  function handle(k)
+	    count = count + 1
+	    assert(count > 1 or getmetatable(seen) == nil)
+	    assert(not seen[k], "seen " .. k)
+	    remember(k)
+	    setmetatable(seen, {})
+	    return count
+	  end
+	`)
+	snapshot := L.Snapshot()
+	for i := 0; i < 3; i++ {
+		errorIfScriptFail(t, L, `assert(handle("a") == 1 and handle("b") == 2)`)
+		errorIfNotNil(t, L.Restore(snapshot))
+	}
+}
@@ -2,7 +2,11 @@ package lua
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"sync"
+	"unsafe"
 
 	"github.com/yuin/gopher-lua/pm"
 )
@@ -25,19 +29,44 @@ func OpenString(L *LState) int {
 }
 
 var strFuncs = map[string]LGFunction{
-	"byte":    strByte,
-	"char":    strChar,
-	"dump":    strDump,
-	"find":    strFind,
-	"format":  strFormat,
-	"gsub":    strGsub,
-	"len":     strLen,
-	"lower":   strLower,
-	"match":   strMatch,
-	"rep":     strRep,
-	"reverse": strReverse,
-	"sub":     strSub,
-	"upper":   strUpper,
+	"byte":     strByte,
+	"char":     strChar,
+	"dump":     strDump,
+	"find":     strFind,
+	"format":   strFormat,
+	"gsub":     strGsub,
+	"len":      strLen,
+	"lower":    strLower,
+	"match":    strMatch,
+	"pack":     strPack,
+	"packsize": strPackSize,
+	"rep":      strRep,
+	"reverse":  strReverse,
+	"sub":      strSub,
+	"unpack":   strUnpack,
+	"upper":    strUpper,
+}
+
+var patternCache *pm.Cache
+var patternCacheOnce sync.Once
+
+// findPattern matches a pattern compiled once per process and raises pattern
+// errors and exceeded step limits as Lua errors.
+func findPattern(L *LState, pattern string, src []byte, offset, limit int) []*pm.MatchData {
+	patternCacheOnce.Do(func() { patternCache = pm.NewCache(PatternCacheSize) })
+	var flags pm.Flags
+	if L.Options.UnicodePatterns {
+		flags = pm.Unicode
+	}
+	pat, err := patternCache.Compile(pattern, flags)
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	mds, err := pat.Find(src, offset, limit, L.Options.PatternStepLimit)
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	return mds
 }
 
 func strByte(L *LState) int {
@@ -112,10 +141,7 @@ func strFind(L *LState) int {
 		return 2
 	}
 
-	mds, err := pm.Find(pattern, unsafeFastStringToReadOnlyBytes(str), init, 1)
-	if err != nil {
-		L.RaiseError(err.Error())
-	}
+	mds := findPattern(L, pattern, unsafeFastStringToReadOnlyBytes(str), init, 1)
 	if len(mds) == 0 {
 		L.Push(LNil)
 		return 1
@@ -133,18 +159,6 @@ func strFind(L *LState) int {
 	return md.CaptureLength()/2 + 1
 }
 
-func strFormat(L *LState) int {
-	str := L.CheckString(1)
-	args := make([]interface{}, L.GetTop()-1)
-	top := L.GetTop()
-	for i := 2; i <= top; i++ {
-		args[i-2] = L.Get(i)
-	}
-	npat := strings.Count(str, "%") - strings.Count(str, "%%")
-	L.Push(LString(fmt.Sprintf(str, args[:intMin(npat, len(args))]...)))
-	return 1
-}
-
 func strGsub(L *LState) int {
 	str := L.CheckString(1)
 	pat := L.CheckString(2)
@@ -152,10 +166,7 @@ func strGsub(L *LState) int {
 	repl := L.CheckAny(3)
 	limit := L.OptInt(4, -1)
 
-	mds, err := pm.Find(pat, unsafeFastStringToReadOnlyBytes(str), 0, limit)
-	if err != nil {
-		L.RaiseError(err.Error())
-	}
+	mds := findPattern(L, pat, unsafeFastStringToReadOnlyBytes(str), 0, limit)
 	if len(mds) == 0 {
 		L.SetTop(1)
 		L.Push(LNumber(0))
@@ -327,10 +338,7 @@ func strGmatchIter(L *LState) int {
 func strGmatch(L *LState) int {
 	str := L.CheckString(1)
 	pattern := L.CheckString(2)
-	mds, err := pm.Find(pattern, []byte(str), 0, -1)
-	if err != nil {
-		L.RaiseError(err.Error())
-	}
+	mds := findPattern(L, pattern, []byte(str), 0, -1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	ud := L.NewUserData()
 	ud.Value = &strMatchData{str, 0, mds}
@@ -363,10 +371,7 @@ func strMatch(L *LState) int {
 		offset = 0
 	}
 
-	mds, err := pm.Find(pattern, unsafeFastStringToReadOnlyBytes(str), offset, 1)
-	if err != nil {
-		L.RaiseError(err.Error())
-	}
+	mds := findPattern(L, pattern, unsafeFastStringToReadOnlyBytes(str), offset, 1)
 	if len(mds) == 0 {
 		L.Push(LNil)
 		return 0
@@ -430,6 +435,668 @@ func strUpper(L *LState) int {
 	return 1
 }
 
+/* string.format {{{ */
+
+// formatFlags are the flags of string.format conversions.
+const formatFlags = "-+ #0"
+
+// formatSpec is a conversion specification of string.format like %-5.2s.
+type formatSpec struct {
+	minus, plus, space, alt, zero bool
+	width                         int
+	precision                     int // -1 if there is none
+	verb                          byte
+	source                        string
+}
+
+func formatArgError(L *LState, n int, format string, args ...interface{}) {
+	L.RaiseError("bad argument #%d to 'format' (%s)", n, fmt.Sprintf(format, args...))
+}
+
+// readFormatNumber reads a width or a precision of at most 2 digits like C Lua does.
+func readFormatNumber(L *LState, format string, pos int) (int, int) {
+	n := 0
+	for start := pos; pos < len(format) && '0' <= format[pos] && format[pos] <= '9'; pos++ {
+		if pos-start == 2 {
+			L.RaiseError("invalid format (width or precision too long)")
+		}
+		n = n*10 + int(format[pos]-'0')
+	}
+	return n, pos
+}
+
+// parseFormatSpec parses the conversion specification after the '%' at pos-1.
+func parseFormatSpec(L *LState, format string, pos int) (*formatSpec, int) {
+	spec := &formatSpec{precision: -1}
+	start := pos
+	for ; pos < len(format) && strings.IndexByte(formatFlags, format[pos]) >= 0; pos++ {
+		switch format[pos] {
+		case '-':
+			spec.minus = true
+		case '+':
+			spec.plus = true
+		case ' ':
+			spec.space = true
+		case '#':
+			spec.alt = true
+		case '0':
+			spec.zero = true
+		}
+	}
+	if pos-start > len(formatFlags) {
+		L.RaiseError("invalid format (repeated flags)")
+	}
+	spec.width, pos = readFormatNumber(L, format, pos)
+	if pos < len(format) && format[pos] == '.' {
+		spec.precision, pos = readFormatNumber(L, format, pos+1)
+	}
+	if pos == len(format) {
+		L.RaiseError("invalid conversion '%s' to 'format'", format[start-1:])
+	}
+	spec.verb = format[pos]
+	spec.source = format[start-1 : pos+1]
+	return spec, pos + 1
+}
+
+// pad appends a converted value padded to the width. Zero padding goes between
+// the sign or prefix and the digits.
+func (spec *formatSpec) pad(buf []byte, prefix, body string, zero bool) []byte {
+	n := spec.width - len(prefix) - len(body)
+	if spec.minus {
+		buf = append(append(buf, prefix...), body...)
+		for ; n > 0; n-- {
+			buf = append(buf, ' ')
+		}
+		return buf
+	}
+	if zero && spec.zero {
+		buf = append(buf, prefix...)
+		for ; n > 0; n-- {
+			buf = append(buf, '0')
+		}
+		return append(buf, body...)
+	}
+	for ; n > 0; n-- {
+		buf = append(buf, ' ')
+	}
+	return append(append(buf, prefix...), body...)
+}
+
+func (spec *formatSpec) sign(negative bool) string {
+	switch {
+	case negative:
+		return "-"
+	case spec.plus:
+		return "+"
+	case spec.space:
+		return " "
+	}
+	return ""
+}
+
+// appendInt formats an integer like C printf, unsigned conversions use its
+// two's complement.
+func (spec *formatSpec) appendInt(buf []byte, v int64) []byte {
+	var prefix, digits string
+	switch spec.verb {
+	case 'd', 'i':
+		u := uint64(v)
+		if v < 0 {
+			u = -u
+		}
+		prefix = spec.sign(v < 0)
+		digits = strconv.FormatUint(u, 10)
+	case 'u':
+		digits = strconv.FormatUint(uint64(v), 10)
+	case 'o':
+		digits = strconv.FormatUint(uint64(v), 8)
+	case 'x', 'X':
+		digits = strconv.FormatUint(uint64(v), 16)
+		if spec.alt && v != 0 {
+			prefix = "0x"
+		}
+	}
+	if spec.precision == 0 && v == 0 {
+		digits = ""
+	}
+	if n := spec.precision - len(digits); n > 0 {
+		digits = strings.Repeat("0", n) + digits
+	}
+	if spec.verb == 'o' && spec.alt && (len(digits) == 0 || digits[0] != '0') {
+		digits = "0" + digits
+	}
+	if spec.verb == 'X' {
+		prefix, digits = strings.ToUpper(prefix), strings.ToUpper(digits)
+	}
+	return spec.pad(buf, prefix, digits, spec.precision < 0)
+}
+
+// appendFloat formats a number like C printf.
+func (spec *formatSpec) appendFloat(buf []byte, f float64) []byte {
+	verb := spec.verb | 0x20 // lower case
+	abs := math.Abs(f)
+	prefix := spec.sign(math.Signbit(f) && !math.IsNaN(f))
+	finite := true
+	var body string
+	switch {
+	case math.IsInf(f, 0):
+		body, finite = "inf", false
+	case math.IsNaN(f):
+		body, finite = "nan", false
+	case verb == 'a':
+		body = strconv.FormatFloat(abs, 'x', spec.precision, 64)
+		// C prints the exponent without leading zeros
+		p := strings.IndexByte(body, 'p') + 2
+		exp := strings.TrimLeft(body[p:], "0")
+		if len(exp) == 0 {
+			exp = "0"
+		}
+		if spec.alt && strings.IndexByte(body, '.') < 0 {
+			body = body[:3] + "." + body[3:p]
+		} else {
+			body = body[:p]
+		}
+		body += exp
+	default:
+		precision := spec.precision
+		if precision < 0 {
+			precision = 6
+		}
+		if spec.alt {
+			body = fmt.Sprintf("%#.*"+string(verb), precision, abs)
+		} else {
+			body = fmt.Sprintf("%.*"+string(verb), precision, abs)
+		}
+	}
+	if spec.verb != verb {
+		body = strings.ToUpper(body)
+	}
+	return spec.pad(buf, prefix, body, finite)
+}
+
+// appendQuoted appends a string, a number, a boolean or nil in a form Lua reads back.
+func appendQuoted(L *LState, buf []byte, n int) []byte {
+	switch lv := L.Get(n).(type) {
+	case LString:
+		buf = append(buf, '"')
+		for i := 0; i < len(lv); i++ {
+			switch c := lv[i]; {
+			case c == '"' || c == '\\' || c == '\n':
+				buf = append(buf, '\\', c)
+			case c == '\r':
+				buf = append(buf, '\\', 'r')
+			case c < 0x20 || c == 0x7f:
+				buf = append(buf, fmt.Sprintf("\\%03d", c)...)
+			default:
+				buf = append(buf, c)
+			}
+		}
+		return append(buf, '"')
+	case LNumber:
+		f := float64(lv)
+		switch {
+		case math.IsInf(f, 1):
+			return append(buf, "1e9999"...)
+		case math.IsInf(f, -1):
+			return append(buf, "-1e9999"...)
+		case math.IsNaN(f):
+			return append(buf, "(0/0)"...)
+		case isInteger(lv):
+			return strconv.AppendInt(buf, int64(lv), 10)
+		}
+		return strconv.AppendFloat(buf, f, 'g', -1, 64)
+	case *LNilType, LBool:
+		return append(buf, lv.String()...)
+	}
+	formatArgError(L, n, "value has no literal form")
+	return buf
+}
+
+func checkFormatNumber(L *LState, n int) float64 {
+	switch lv := L.Get(n).(type) {
+	case LNumber:
+		return float64(lv)
+	case LString:
+		if num, err := parseNumber(string(lv)); err == nil {
+			return float64(num)
+		}
+	}
+	formatArgError(L, n, "number expected, got %s", L.Get(n).Type().String())
+	return 0
+}
+
+func checkFormatInt(L *LState, n int) int64 {
+	f := checkFormatNumber(L, n)
+	if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 {
+		formatArgError(L, n, "number has no integer representation")
+	}
+	return int64(f)
+}
+
+func strFormat(L *LState) int {
+	format := L.CheckString(1)
+	top := L.GetTop()
+	buf := make([]byte, 0, len(format))
+	arg := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			buf = append(buf, format[i])
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			buf = append(buf, '%')
+			i++
+			continue
+		}
+		if arg++; arg > top {
+			formatArgError(L, arg, "no value")
+		}
+		spec, next := parseFormatSpec(L, format, i+1)
+		i = next - 1
+		switch spec.verb {
+		case 'c':
+			buf = spec.pad(buf, "", string([]byte{byte(checkFormatInt(L, arg))}), false)
+		case 'd', 'i', 'o', 'u', 'x', 'X':
+			buf = spec.appendInt(buf, checkFormatInt(L, arg))
+		case 'a', 'A', 'e', 'E', 'f', 'F', 'g', 'G':
+			buf = spec.appendFloat(buf, checkFormatNumber(L, arg))
+		case 'q':
+			if len(spec.source) != 2 {
+				L.RaiseError("%s", "specifier '%q' cannot have modifiers")
+			}
+			buf = appendQuoted(L, buf, arg)
+		case 's':
+			str := L.ToStringMeta(L.Get(arg)).String()
+			if spec.precision >= 0 && len(str) > spec.precision {
+				str = str[:spec.precision]
+			}
+			buf = spec.pad(buf, "", str, false)
+		default:
+			L.RaiseError("invalid conversion '%s' to 'format'", spec.source)
+		}
+	}
+	L.Push(LString(buf))
+	return 1
+}
+
+/* }}} */
+
+/* string.pack {{{ */
+
+// packOption is the kind of a string.pack format option.
+type packOption int
+
+const (
+	packInt packOption = iota
+	packUint
+	packFloat
+	packDouble
+	packChar
+	packString
+	packZstr
+	packPadding
+	packPaddAlign
+	packNop
+)
+
+const (
+	// packMaxIntSize is the maximum size of integer options like i16.
+	packMaxIntSize = 16
+	// packNativeAlign is the alignment of '!' without a size.
+	packNativeAlign = 8
+	// packMaxSize is the maximum size of an option or a packed string.
+	packMaxSize = 0x7fffffff
+	// packMaxExact is the largest integer a LNumber represents exactly.
+	packMaxExact = 1 << 53
+)
+
+var packNativeLittle = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+type packState struct {
+	L        *LState
+	format   string
+	pos      int
+	little   bool
+	maxAlign int
+}
+
+func newPackState(L *LState, format string) *packState {
+	return &packState{L: L, format: format, little: packNativeLittle, maxAlign: 1}
+}
+
+func (ps *packState) done() bool { return ps.pos >= len(ps.format) }
+
+func (ps *packState) isDigit() bool {
+	return !ps.done() && '0' <= ps.format[ps.pos] && ps.format[ps.pos] <= '9'
+}
+
+// readSize reads an optional size, returning def if there is none.
+func (ps *packState) readSize(def int) int {
+	if !ps.isDigit() {
+		return def
+	}
+	size := 0
+	for ps.isDigit() && size <= (packMaxSize-9)/10 {
+		size = size*10 + int(ps.format[ps.pos]-'0')
+		ps.pos++
+	}
+	return size
+}
+
+func (ps *packState) readIntSize(def int) int {
+	size := ps.readSize(def)
+	if size < 1 || size > packMaxIntSize {
+		ps.L.RaiseError("integral size (%d) out of limits [1,%d]", size, packMaxIntSize)
+	}
+	return size
+}
+
+// option reads the next option and its size.
+func (ps *packState) option() (packOption, int) {
+	opt := ps.format[ps.pos]
+	ps.pos++
+	switch opt {
+	case 'b':
+		return packInt, 1
+	case 'B':
+		return packUint, 1
+	case 'h':
+		return packInt, 2
+	case 'H':
+		return packUint, 2
+	case 'l', 'j':
+		return packInt, 8
+	case 'L', 'J', 'T':
+		return packUint, 8
+	case 'f':
+		return packFloat, 4
+	case 'd', 'n':
+		return packDouble, 8
+	case 'i':
+		return packInt, ps.readIntSize(4)
+	case 'I':
+		return packUint, ps.readIntSize(4)
+	case 's':
+		return packString, ps.readIntSize(8)
+	case 'c':
+		size := ps.readSize(-1)
+		if size == -1 {
+			ps.L.RaiseError("missing size for format option 'c'")
+		}
+		return packChar, size
+	case 'z':
+		return packZstr, 0
+	case 'x':
+		return packPadding, 1
+	case 'X':
+		return packPaddAlign, 0
+	case ' ':
+	case '<':
+		ps.little = true
+	case '>':
+		ps.little = false
+	case '=':
+		ps.little = packNativeLittle
+	case '!':
+		ps.maxAlign = ps.readIntSize(packNativeAlign)
+	default:
+		ps.L.RaiseError("invalid format option '%c'", opt)
+	}
+	return packNop, 0
+}
+
+// details reads the next option and returns the padding aligning it at total.
+func (ps *packState) details(total int) (packOption, int, int) {
+	opt, size := ps.option()
+	align := size
+	if opt == packPaddAlign {
+		// 'X' gets its alignment from the next option
+		if ps.done() {
+			ps.L.ArgError(1, "invalid next option for option 'X'")
+		}
+		var next packOption
+		if next, align = ps.option(); next == packChar || align == 0 {
+			ps.L.ArgError(1, "invalid next option for option 'X'")
+		}
+	}
+	if align <= 1 || opt == packChar {
+		return opt, size, 0
+	}
+	if align > ps.maxAlign {
+		align = ps.maxAlign
+	}
+	if align&(align-1) != 0 {
+		ps.L.ArgError(1, "format asks for alignment not power of 2")
+	}
+	return opt, size, (align - total&(align-1)) & (align - 1)
+}
+
+// appendPackedInt appends the size bytes of a two's complement integer.
+func appendPackedInt(buf []byte, v uint64, little bool, size int, negative bool) []byte {
+	start := len(buf)
+	for i := 0; i < size; i++ {
+		switch {
+		case i < 8:
+			buf = append(buf, byte(v>>(8*uint(i))))
+		case negative:
+			buf = append(buf, 0xff)
+		default:
+			buf = append(buf, 0)
+		}
+	}
+	if !little {
+		for i, j := start, len(buf)-1; i < j; i, j = i+1, j-1 {
+			buf[i], buf[j] = buf[j], buf[i]
+		}
+	}
+	return buf
+}
+
+// unpackInt reads a size bytes integer. Integers wider than 64 bits must be sign
+// or zero extensions of a 64 bits integer.
+func unpackInt(L *LState, data string, little bool, size int, signed bool) uint64 {
+	at := func(i int) byte {
+		if little {
+			return data[i]
+		}
+		return data[size-1-i]
+	}
+	limit := intMin(size, 8)
+	var v uint64
+	for i := limit - 1; i >= 0; i-- {
+		v = v<<8 | uint64(at(i))
+	}
+	if size < 8 {
+		if signed {
+			mask := uint64(1) << (uint(size)*8 - 1)
+			v = (v ^ mask) - mask
+		}
+	} else if size > 8 {
+		ext := byte(0)
+		if signed && int64(v) < 0 {
+			ext = 0xff
+		}
+		for i := limit; i < size; i++ {
+			if at(i) != ext {
+				L.RaiseError("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return v
+}
+
+// checkPackInteger returns the integer argument n as a two's complement value.
+// Integers which do not fit in a LNumber exactly can be given as decimal strings.
+// Signed integers must fit in 64 bits, unsigned ones may be up to 2^64-1.
+func checkPackInteger(L *LState, n int, signed bool) uint64 {
+	if s, ok := L.Get(n).(LString); ok {
+		if v, err := strconv.ParseInt(strings.TrimSpace(string(s)), 10, 64); err == nil {
+			return uint64(v)
+		}
+		if v, err := strconv.ParseUint(strings.TrimSpace(string(s)), 10, 64); err == nil && !signed {
+			return v
+		}
+	}
+	f := float64(L.CheckNumber(n))
+	switch {
+	case f != math.Trunc(f):
+	case f >= -(1<<63) && f < 1<<63:
+		return uint64(int64(f))
+	case f >= 1<<63 && f < 1<<64 && !signed:
+		return uint64(f)
+	}
+	L.ArgError(n, "number has no integer representation")
+	return 0
+}
+
+// pushUnpackedInt pushes an integer as a LNumber if it is exact, as a decimal string otherwise.
+func pushUnpackedInt(L *LState, v uint64, signed bool) {
+	if signed && int64(v) < 0 {
+		if -int64(v) <= packMaxExact {
+			L.Push(LNumber(int64(v)))
+		} else {
+			L.Push(LString(strconv.FormatInt(int64(v), 10)))
+		}
+	} else if v <= packMaxExact {
+		L.Push(LNumber(v))
+	} else {
+		L.Push(LString(strconv.FormatUint(v, 10)))
+	}
+}
+
+func strPack(L *LState) int {
+	ps := newPackState(L, L.CheckString(1))
+	buf := []byte{}
+	arg := 1
+	for !ps.done() {
+		opt, size, ntoalign := ps.details(len(buf))
+		for ; ntoalign > 0; ntoalign-- {
+			buf = append(buf, 0)
+		}
+		arg++
+		switch opt {
+		case packInt:
+			v := checkPackInteger(L, arg, true)
+			if size < 8 {
+				lim := int64(1) << (uint(size)*8 - 1)
+				if n := int64(v); n < -lim || n >= lim {
+					L.ArgError(arg, "integer overflow")
+				}
+			}
+			buf = appendPackedInt(buf, v, ps.little, size, int64(v) < 0)
+		case packUint:
+			v := checkPackInteger(L, arg, false)
+			if size < 8 && v >= uint64(1)<<(uint(size)*8) {
+				L.ArgError(arg, "unsigned overflow")
+			}
+			buf = appendPackedInt(buf, v, ps.little, size, false)
+		case packFloat:
+			buf = appendPackedInt(buf, uint64(math.Float32bits(float32(L.CheckNumber(arg)))), ps.little, size, false)
+		case packDouble:
+			buf = appendPackedInt(buf, math.Float64bits(float64(L.CheckNumber(arg))), ps.little, size, false)
+		case packChar:
+			s := L.CheckString(arg)
+			if len(s) > size {
+				L.ArgError(arg, "string longer than given size")
+			}
+			buf = append(buf, s...)
+			for i := len(s); i < size; i++ {
+				buf = append(buf, 0)
+			}
+		case packString:
+			s := L.CheckString(arg)
+			if size < 8 && uint64(len(s)) >= uint64(1)<<(uint(size)*8) {
+				L.ArgError(arg, "string length does not fit in given size")
+			}
+			buf = appendPackedInt(buf, uint64(len(s)), ps.little, size, false)
+			buf = append(buf, s...)
+		case packZstr:
+			s := L.CheckString(arg)
+			if strings.IndexByte(s, 0) >= 0 {
+				L.ArgError(arg, "string contains zeros")
+			}
+			buf = append(append(buf, s...), 0)
+		case packPadding:
+			buf = append(buf, 0)
+			arg--
+		default:
+			arg--
+		}
+	}
+	L.Push(LString(buf))
+	return 1
+}
+
+func strPackSize(L *LState) int {
+	ps := newPackState(L, L.CheckString(1))
+	total := 0
+	for !ps.done() {
+		opt, size, ntoalign := ps.details(total)
+		size += ntoalign
+		if total > packMaxSize-size {
+			L.ArgError(1, "format result too large")
+		}
+		total += size
+		if opt == packString || opt == packZstr {
+			L.ArgError(1, "variable-length format")
+		}
+	}
+	L.Push(LNumber(total))
+	return 1
+}
+
+func strUnpack(L *LState) int {
+	ps := newPackState(L, L.CheckString(1))
+	data := L.CheckString(2)
+	pos := utf8PosRelat(L.OptInt(3, 1), len(data)) - 1
+	if pos < 0 || pos > len(data) {
+		L.ArgError(3, "initial position out of string")
+	}
+	n := 0
+	for !ps.done() {
+		opt, size, ntoalign := ps.details(pos)
+		if ntoalign+size > len(data)-pos {
+			L.ArgError(2, "data string too short")
+		}
+		pos += ntoalign
+		n++
+		switch opt {
+		case packInt, packUint:
+			pushUnpackedInt(L, unpackInt(L, data[pos:], ps.little, size, opt == packInt), opt == packInt)
+		case packFloat:
+			L.Push(LNumber(math.Float32frombits(uint32(unpackInt(L, data[pos:], ps.little, size, false)))))
+		case packDouble:
+			L.Push(LNumber(math.Float64frombits(unpackInt(L, data[pos:], ps.little, size, false))))
+		case packChar:
+			L.Push(LString(data[pos : pos+size]))
+		case packString:
+			length := unpackInt(L, data[pos:], ps.little, size, false)
+			if length > uint64(len(data)-pos-size) {
+				L.ArgError(2, "data string too short")
+			}
+			L.Push(LString(data[pos+size : pos+size+int(length)]))
+			pos += int(length)
+		case packZstr:
+			length := strings.IndexByte(data[pos:], 0)
+			if length < 0 {
+				L.ArgError(2, "unfinished string for format 'z'")
+			}
+			L.Push(LString(data[pos : pos+length]))
+			pos += length + 1
+		default:
+			n--
+		}
+		pos += size
+	}
+	L.Push(LNumber(pos + 1))
+	return n + 1
+}
+
+/* }}} */
+
 func luaIndex2StringIndex(str string, i int, start bool) int {
 	if start && i != 0 {
 		i -= 1
@@ -0,0 +1,187 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestPatternStepLimit(t *testing.T) {
+	L := NewState(Options{PatternStepLimit: 100000})
+	defer L.Close()
+	errorIfScriptFail(t, L, `assert(("aab"):match("a*a*b") == "aab")`)
+	errorIfScriptNotFail(t, L, `string.find(string.rep("a", 30), string.rep("a*", 20) .. "b")`, "pattern too complex")
+	errorIfScriptNotFail(t, L, `string.find(string.rep("a", 40000), ".-b")`, "pattern too complex")
+
+	L = NewState(Options{PatternStepLimit: -1})
+	defer L.Close()
+	errorIfScriptFail(t, L, `assert(string.find(string.rep("a", 12), string.rep("a*", 6) .. "b") == nil)`)
+
+	L = NewState()
+	defer L.Close()
+	errorIfNotEqual(t, PatternStepLimit, L.Options.PatternStepLimit)
+	errorIfScriptFail(t, L, `assert(string.find(string.rep("x", 4e6) .. "y", "xy") == 4e6)`)
+	errorIfScriptNotFail(t, L, `string.find(string.rep("a", 40000), ".-b")`, "pattern too complex")
+}
+
+// TestPatternConformance runs the pattern tests Lua 5.2 added to pm.lua. The
+// tests of Lua 5.1 are run by TestLua.
+func TestPatternConformance(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(string.gsub("aaa aa a aaa a", "%f[%w]%w+", "x") == "x x x x x")
+	  assert(string.gsub("[[]] [][] [[[[", "%f[[].", "x") == "x[]] x]x] x[[[")
+	  assert(string.gsub("01abc45de3", "%f[%d]", ".") == ".01abc.45de.3")
+	  assert(string.gsub("01abc45 de3x", "%f[%D]%w", ".") == "01.bc45 de3.")
+	  assert(string.gsub("function", "%f[\1-\255]%w", ".") == ".unction")
+	  assert(string.gsub("function", "%f[^\1-\255]", ".") == "function.")
+	  assert(string.find("a", "%f[a]") == 1)
+	  assert(string.find("a", "%f[^%z]") == 1)
+	  assert(string.find("a", "%f[^%l]") == 2)
+	  assert(string.find("aba", "%f[a%z]") == 3)
+	  assert(string.find("aba", "%f[%z]") == 4)
+	  assert(not string.find("aba", "%f[%l%z]"))
+	  assert(not string.find("aba", "%f[^%l%z]"))
+
+	  assert(string.match("ab\0\1\2c", "[\0-\2]+") == "\0\1\2")
+	  assert(string.match("ab\0\1\2c", "[\0-\0]+") == "\0")
+	  assert(string.find("b$a", "$\0?") == 2)
+	  assert(string.find("abc\0efg", "%\0") == 4)
+	  assert(string.match("abc\0efg\0\1e\1g", "%b\0\1") == "\0efg\0\1e\1")
+	  assert(string.match("abc\0\0\0", "%\0+") == "\0\0\0")
+	  assert(string.match("abc\0\0\0", "%\0%\0?") == "\0\0")
+	  assert(string.find("abc\0\0", "\0.") == 4)
+	  assert(string.find("abcx\0\0abc\0abc", "x\0\0abc\0a.") == 4)
+	  assert(string.match("alo alo", "[%a-z]+") == "alo")
+	  assert(string.match("x-y", "[%a-]+") == "x-y")
+
+	  local function malform(p, m)
+	    m = m or "malformed"
+	    local ok, msg = pcall(string.find, "a", p)
+	    assert(not ok and string.find(msg, m, 1, true), p)
+	  end
+	  malform("(.", "unfinished capture")
+	  malform(".)", "invalid pattern capture")
+	  malform("[a", "malformed pattern (missing ']')")
+	  malform("[]")
+	  malform("[^]")
+	  malform("[a%]")
+	  malform("[a%")
+	  malform("%b", "malformed pattern (missing arguments to '%b')")
+	  malform("%ba")
+	  malform("%", "malformed pattern (ends with '%')")
+	  malform("%f", "missing '[' after '%f' in pattern")
+	  malform("(%0)", "invalid capture index %0")
+	  malform("(%1)", "invalid capture index %1")
+	  malform("(a)%2", "invalid capture index %2")
+	  malform(string.rep("()", 33), "too many captures")
+	  assert(string.find("a", string.rep("()", 32)) == 1)
+	`)
+}
+
+func TestStringPack(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(string.pack(">I2", 0x1234) == "\18\52" and string.pack("<I2", 0x1234) == "\52\18")
+	  assert(string.unpack("=i3", string.pack("=i3", -2)) == -2)
+	  assert(string.pack("<i16", -2) == "\254" .. string.rep("\255", 15))
+	  assert(string.unpack("<i16", string.pack("<i16", -2)) == -2)
+	  assert(string.unpack(">I3", "\1\2\3") == 0x010203)
+	  assert(string.unpack("<h", "\255\255") == -1 and string.unpack("<H", "\255\255") == 65535)
+	  assert(string.packsize("i4i8") == 12 and string.packsize("!i4i8") == 16 and string.packsize("!4 i2 d") == 12)
+	  assert(string.pack("!<i2 Xi8 i1", 1, 2) == "\1\0\0\0\0\0\0\0\2")
+	  assert(#string.pack("!8 b i8", 1, 2) == 16 and #string.pack("b i8", 1, 2) == 9)
+
+	  local packet = string.pack(">I2 s1 z B x c3", 53, "example", "com", 7, "abc")
+	  local id, name, tld, n, tag, next = string.unpack(">I2 s1 z B x c3", packet)
+	  assert(id == 53 and name == "example" and tld == "com" and n == 7 and tag == "abc" and next == #packet + 1)
+	  assert(select(2, string.unpack("B", "\1\2\3", 2)) == 3 and string.unpack("B", "\1\2\3", -1) == 3)
+	  local f, d = string.unpack("<f d", string.pack("<f d", 0.5, -1.25))
+	  assert(f == 0.5 and d == -1.25)
+
+	  -- integers beyond 2^53 are exact as decimal strings
+	  local big = string.unpack(">j", "\127\255\255\255\255\255\255\255")
+	  assert(big == "9223372036854775807")
+	  assert(string.unpack(">J", string.rep("\255", 8)) == "18446744073709551615")
+	  assert(string.unpack(">j", string.rep("\255", 8)) == -1)
+	  assert(string.unpack("<j", string.pack("<j", "-9007199254740993")) == "-9007199254740993")
+	  assert(string.pack(">J", "18446744073709551615") == string.rep("\255", 8))
+	  assert(string.unpack("<j", string.pack("<j", 2^53)) == 2^53)
+
+	  local function check(msg, f, ...)
+	    local ok, err = pcall(f, ...)
+	    assert(not ok and string.find(err, msg, 1, true), err)
+	  end
+	  check("integer overflow", string.pack, "i1", 128)
+	  check("unsigned overflow", string.pack, "I1", -1)
+	  check("number has no integer representation", string.pack, "i4", 1.5)
+	  check("number has no integer representation", string.pack, "<i8", 2^63)
+	  check("number has no integer representation", string.pack, "<i16", "18446744073709551615")
+	  assert(string.pack("<I16", "18446744073709551615") == string.rep("\255", 8) .. string.rep("\0", 8))
+	  assert(string.pack("<I8", 2^63) == string.rep("\0", 7) .. "\128")
+	  assert(string.pack("<i8", -2^63) == string.rep("\0", 7) .. "\128")
+	  check("integral size (17) out of limits [1,16]", string.pack, "i17", 1)
+	  check("invalid format option 'y'", string.pack, "y")
+	  check("missing size for format option 'c'", string.pack, "c", "")
+	  check("string longer than given size", string.pack, "c2", "abc")
+	  check("string length does not fit in given size", string.pack, "s1", string.rep("x", 256))
+	  check("string contains zeros", string.pack, "z", "a\0b")
+	  check("format asks for alignment not power of 2", string.pack, "!4 i3 Xi3")
+	  check("invalid next option for option 'X'", string.pack, "X")
+	  check("variable-length format", string.packsize, "s")
+	  check("data string too short", string.unpack, "i4", "abc")
+	  check("data string too short", string.unpack, "s1", "\5abc")
+	  check("unfinished string for format 'z'", string.unpack, "z", "abc")
+	  check("initial position out of string", string.unpack, "b", "a", 3)
+	  check("9-byte integer does not fit into Lua Integer", string.unpack, "<i9", string.rep("\0", 8) .. "\1")
+	`)
+}
+
+func TestStringFormat(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local function eq(expected, fmt, ...)
+	    local s = string.format(fmt, ...)
+	    assert(s == expected, fmt .. ": " .. s)
+	  end
+	  eq("42|42|+42| 42|-42", "%d|%i|%+d|% d|%i", 42, 42, 42, 42, -42)
+	  eq("   42|42   |00042|-0042|  042", "%5d|%-5d|%05d|%05d|%5.3d", 42, 42, 42, -42, 42)
+	  eq("|7", "%.0d|%.0d", 0, 7)
+	  eq("ff|FF|0xff|0XFF|0|377|0377|0", "%x|%X|%#x|%#X|%#x|%o|%#o|%#o", 255, 255, 255, 255, 0, 255, 255, 0)
+	  eq("18446744073709551615|ffffffffffffffff", "%u|%x", -1, -1)
+	  eq("3", "%d", "3")
+	  eq("  abc|abc  |ab|   ab|nil|true", "%5s|%-5s|%.2s|%5.2s|%s|%s", "abc", "abc", "abc", "abc", nil, true)
+	  eq("obj", "%s", setmetatable({}, {__tostring = function() return "obj" end}))
+	  eq("A|    A|A    ", "%c|%5c|%-5c", 65, 65, 65)
+	  eq("3.141593|3.14|  3.1|3.14159|3.141593e+00|3.142E+00", "%f|%.2f|%5.1f|%g|%e|%.3E", math.pi, math.pi, math.pi, math.pi, math.pi, math.pi)
+	  eq("1e+20|100000|1.23457e+06|0.0001|1e-05", "%g|%g|%g|%g|%g", 1e20, 1e5, 1234567, 0.0001, 0.00001)
+	  eq("1.00000|1.|+1.0|-0.0", "%#g|%#.0f|%+.1f|%.1f", 1, 1, 1, -0.04)
+	  eq("0x1p+0|0x1.8p+1|-0x1p-2|0X1.8P+1|0x0p+0|0x1.p+0|0x1.0p+0", "%a|%a|%a|%A|%a|%#.0a|%.1a", 1, 3, -0.25, 3, 0, 1, 1)
+	  eq("inf|-inf|  INF|nan|+inf", "%f|%f|%5F|%f|%+g", 1/0, -1/0, 1/0, 0/0, 1/0)
+	  eq("00003.50|3.50   ", "%08.2f|%-7.2f", 3.5, 3.5)
+	  eq("100%", "%d%%", 100)
+
+	  eq([["a\"b\\c\
+d\r\000\127\001"]], "%q", "a\"b\\c\nd\r\0\127\1")
+	  eq("1|-7|0.1|1e+100|1e9999|-1e9999|(0/0)|nil|false", "%q|%q|%q|%q|%q|%q|%q|%q|%q", 1, -7, 0.1, 1e100, 1/0, -1/0, 0/0, nil, false)
+	  local s = "x\0y\r\n\"\\\200"
+	  assert(loadstring("return " .. string.format("%q", s))() == s)
+	  assert(loadstring("return " .. string.format("%q", 0.1))() == 0.1)
+
+	  local function check(msg, ...)
+	    local ok, err = pcall(string.format, ...)
+	    assert(not ok and string.find(err, msg, 1, true), err)
+	  end
+	  check("bad argument #2 to 'format' (number has no integer representation)", "%d", 1.5)
+	  check("bad argument #3 to 'format' (number expected, got table)", "%s %f", "x", {})
+	  check("bad argument #2 to 'format' (no value)", "%d")
+	  check("bad argument #2 to 'format' (value has no literal form)", "%q", {})
+	  check("invalid conversion '%y' to 'format'", "%y", 1)
+	  check("invalid conversion '%-5' to 'format'", "%-5", 1)
+	  check("invalid format (repeated flags)", "%------d", 1)
+	  check("invalid format (width or precision too long)", "%100d", 1)
+	  check("invalid format (width or precision too long)", "%.100f", 1)
+	  check("specifier '%q' cannot have modifiers", "%10q", "x")
+	`)
+}
@@ -0,0 +1,220 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// OpenStrings opens the strings library. It is not opened by OpenLibs.
+//
+// The library wraps the strings package of Go. Its functions work on the bytes
+// of the Lua strings without copying them; only the returned strings and tables
+// are allocated.
+func OpenStrings(L *LState) int {
+	mod := L.RegisterModule(StringsLibName, stringsFuncs).(*LTable)
+	L.Push(mod)
+	return 1
+}
+
+var stringsFuncs = map[string]LGFunction{
+	"split":        stringsSplit,
+	"fields":       stringsFields,
+	"trim":         stringsTrim,
+	"trim_left":    stringsTrimLeft,
+	"trim_right":   stringsTrimRight,
+	"trim_prefix":  stringsTrimPrefix,
+	"trim_suffix":  stringsTrimSuffix,
+	"has_prefix":   stringsHasPrefix,
+	"has_suffix":   stringsHasSuffix,
+	"contains":     stringsContains,
+	"contains_any": stringsContainsAny,
+	"replace":      stringsReplace,
+	"fold_equal":   stringsFoldEqual,
+	"levenshtein":  stringsLevenshtein,
+}
+
+func pushStringList(L *LState, parts []string) {
+	tb := L.CreateTable(len(parts), 0)
+	for _, part := range parts {
+		tb.Append(LString(part))
+	}
+	L.Push(tb)
+}
+
+// split(s, sep [, n [, pattern]]) returns a table of the substrings between the
+// separators, at most n substrings if n is positive. The separator is a plain
+// string unless pattern is true, then it is a Lua pattern whose empty matches
+// are ignored.
+func stringsSplit(L *LState) int {
+	str := L.CheckString(1)
+	sep := L.CheckString(2)
+	n := L.OptInt(3, -1)
+	if n == 0 {
+		n = -1
+	}
+	if !L.OptBool(4, false) {
+		pushStringList(L, strings.SplitN(str, sep, n))
+		return 1
+	}
+	parts := []string{}
+	last := 0
+	for _, md := range findPattern(L, sep, unsafeFastStringToReadOnlyBytes(str), 0, -1) {
+		if len(parts) == n-1 {
+			break
+		}
+		start, end := md.Capture(0), md.Capture(1)
+		if start == end {
+			continue
+		}
+		parts = append(parts, str[last:start])
+		last = end
+	}
+	pushStringList(L, append(parts, str[last:]))
+	return 1
+}
+
+// fields(s) returns a table of the substrings separated by white space.
+func stringsFields(L *LState) int {
+	pushStringList(L, strings.Fields(L.CheckString(1)))
+	return 1
+}
+
+// stringsTrimFunc trims the characters in the optional cutset, white space by default.
+func stringsTrimFunc(trim func(string, func(rune) bool) string) LGFunction {
+	return func(L *LState) int {
+		str := L.CheckString(1)
+		if L.GetTop() < 2 || L.Get(2) == LNil {
+			L.Push(LString(trim(str, unicode.IsSpace)))
+			return 1
+		}
+		cutset := L.CheckString(2)
+		L.Push(LString(trim(str, func(r rune) bool { return strings.ContainsRune(cutset, r) })))
+		return 1
+	}
+}
+
+var stringsTrim = stringsTrimFunc(strings.TrimFunc)
+var stringsTrimLeft = stringsTrimFunc(strings.TrimLeftFunc)
+var stringsTrimRight = stringsTrimFunc(strings.TrimRightFunc)
+
+func stringsTrimPrefix(L *LState) int {
+	L.Push(LString(strings.TrimPrefix(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
+
+func stringsTrimSuffix(L *LState) int {
+	L.Push(LString(strings.TrimSuffix(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
+
+func stringsHasPrefix(L *LState) int {
+	L.Push(LBool(strings.HasPrefix(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
+
+func stringsHasSuffix(L *LState) int {
+	L.Push(LBool(strings.HasSuffix(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
+
+func stringsContains(L *LState) int {
+	L.Push(LBool(strings.Contains(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
+
+// contains_any(s, chars) reports whether s contains any of the characters of chars.
+func stringsContainsAny(L *LState) int {
+	L.Push(LBool(strings.ContainsAny(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
+
+// replace(s, old, new [, n]) replaces the first n occurrences of the plain string
+// old, all of them by default. It returns the result and the number of replacements.
+func stringsReplace(L *LState) int {
+	str := L.CheckString(1)
+	old := L.CheckString(2)
+	repl := L.CheckString(3)
+	n := L.OptInt(4, -1)
+	count := strings.Count(str, old)
+	if n >= 0 && n < count {
+		count = n
+	}
+	if count == 0 {
+		L.Push(L.Get(1))
+	} else {
+		L.Push(LString(strings.Replace(str, old, repl, count)))
+	}
+	L.Push(LNumber(count))
+	return 2
+}
+
+// fold_equal(a, b) reports whether a and b are equal under Unicode case folding.
+func stringsFoldEqual(L *LState) int {
+	L.Push(LBool(strings.EqualFold(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
+
+// levenshteinMaxLen is the maximum length in characters of the strings given
+// to levenshtein, which takes time proportional to the product of the lengths.
+const levenshteinMaxLen = 10000
+
+// levenshtein(a, b [, max]) returns the edit distance between a and b in
+// characters. If max is given, it returns nil as soon as the distance is known
+// to exceed max.
+func stringsLevenshtein(L *LState) int {
+	a, b := L.CheckString(1), L.CheckString(2)
+	limit := L.OptInt(3, -1)
+	for i, s := range []string{a, b} {
+		if utf8.RuneCountInString(s) > levenshteinMaxLen {
+			L.ArgError(i+1, fmt.Sprintf("string longer than %d characters", levenshteinMaxLen))
+		}
+	}
+	if d, ok := levenshtein(a, b, limit); ok {
+		L.Push(LNumber(d))
+	} else {
+		L.Push(LNil)
+	}
+	return 1
+}
+
+// levenshtein computes the distance row by row, keeping a single row of the
+// shorter string. If limit is not negative, it gives up as soon as every entry of
+// a row exceeds limit, since the distance is at least the minimum of any row.
+func levenshtein(a, b string, limit int) (int, bool) {
+	la, lb := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+	if la < lb {
+		a, b, la, lb = b, a, lb, la
+	}
+	if limit >= 0 && la-lb > limit {
+		return 0, false
+	}
+	row := make([]int, lb+1)
+	for j := range row {
+		row[j] = j
+	}
+	i := 0
+	for _, ra := range a {
+		i++
+		diag := row[0]
+		row[0] = i
+		least := row[0]
+		j := 0
+		for _, rb := range b {
+			j++
+			cost := 1
+			if ra == rb {
+				cost = 0
+			}
+			next := intMin(intMin(row[j]+1, row[j-1]+1), diag+cost)
+			diag, row[j] = row[j], next
+			least = intMin(least, next)
+		}
+		if limit >= 0 && least > limit {
+			return 0, false
+		}
+	}
+	d := row[len(row)-1]
+	return d, limit < 0 || d <= limit
+}
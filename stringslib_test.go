@@ -0,0 +1,77 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"привет", "привед", 1},
+		{"a", "ab", 1},
+	}
+	for _, c := range cases {
+		for _, ab := range [][2]string{{c.a, c.b}, {c.b, c.a}} {
+			d, ok := levenshtein(ab[0], ab[1], -1)
+			errorIfFalse(t, ok, "no distance")
+			errorIfNotEqual(t, c.expected, d)
+			_, ok = levenshtein(ab[0], ab[1], c.expected)
+			errorIfFalse(t, ok, "distance %d exceeds itself", c.expected)
+			if c.expected > 0 {
+				_, ok = levenshtein(ab[0], ab[1], c.expected-1)
+				errorIfFalse(t, !ok, "distance %d is within %d", c.expected, c.expected-1)
+			}
+		}
+	}
+}
+
+func TestStringsLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule(StringsLibName, OpenStrings)
+	errorIfScriptFail(t, L, `
+	  local strings = require("strings")
+	  local function list(t) return table.concat(t, "|") end
+	  assert(list(strings.split("a,b,,c", ",")) == "a|b||c")
+	  assert(list(strings.split("a,b,c", ",", 2)) == "a|b,c")
+	  assert(list(strings.split("a.b", ".")) == "a|b")
+	  assert(list(strings.split("a1b22c", "%d+", nil, true)) == "a|b|c")
+	  assert(list(strings.split("a1b22c", "%d+", 2, true)) == "a|b22c")
+	  assert(list(strings.split("abc", "x*", nil, true)) == "abc")
+	  assert(#strings.split("", ",") == 1)
+	  assert(list(strings.fields("  level=info \t msg=ok\n")) == "level=info|msg=ok")
+
+	  assert(strings.trim("  x y \n") == "x y" and strings.trim("--x--", "-") == "x")
+	  assert(strings.trim_left("  x ") == "x " and strings.trim_right("  x ") == "  x")
+	  assert(strings.trim_left("xxyx", "x") == "yx" and strings.trim_right("xyxx", "x") == "xy")
+	  assert(strings.trim_prefix("prefix-x", "prefix-") == "x" and strings.trim_suffix("x.log", ".log") == "x")
+	  assert(strings.has_prefix("GET /", "GET") and not strings.has_prefix("GE", "GET"))
+	  assert(strings.has_suffix("a.log", ".log") and not strings.has_suffix("a.txt", ".log"))
+	  assert(strings.contains("a.b.c", ".b.") and not strings.contains("abc", "."))
+	  assert(strings.contains_any("key=value", "=:") and not strings.contains_any("key", "=:"))
+
+	  local s, n = strings.replace("a.b.c", ".", "%1")
+	  assert(s == "a%1b%1c" and n == 2)
+	  s, n = strings.replace("a.b.c", ".", "-", 1)
+	  assert(s == "a-b.c" and n == 1)
+	  s, n = strings.replace("abc", "x", "y")
+	  assert(s == "abc" and n == 0)
+
+	  assert(strings.fold_equal("Content-Type", "content-type") and strings.fold_equal("ПРИВЕТ", "привет"))
+	  assert(not strings.fold_equal("a", "b"))
+	  assert(strings.levenshtein("kitten", "sitting") == 3)
+	  assert(strings.levenshtein("kitten", "sitting", 3) == 3 and strings.levenshtein("kitten", "sitting", 2) == nil)
+	  assert(strings.levenshtein(string.rep("a", 10000), string.rep("b", 10000), 1) == nil)
+	  local ok, err = pcall(strings.levenshtein, string.rep("a", 10001), "a")
+	  assert(not ok and err:find("string longer than 10000 characters"), err)
+
+	  assert(not pcall(strings.split, "a", "(", nil, true))
+	  assert(not pcall(strings.trim, {}))
+	`)
+}
@@ -0,0 +1,637 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* timestamp parsing {{{ */
+
+// timeLayouts are the named layouts accepted by time.parse and time:format.
+var timeLayouts = map[string]string{
+	"rfc3339": time.RFC3339Nano,
+	"rfc1123": time.RFC1123Z,
+	"syslog":  time.Stamp,
+	"kitchen": time.Kitchen,
+}
+
+// autoTimeLayouts are tried in order by time.parse without a layout.
+var autoTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+var strptimeFlagToGo = map[byte]string{
+	'a': "Mon", 'A': "Monday", 'b': "Jan", 'B': "January", 'h': "Jan", 'd': "02",
+	'e': "_2", 'f': "999999999", 'H': "15", 'I': "03", 'm': "01", 'M': "04", 'p': "PM",
+	'S': "05", 'y': "06", 'Y': "2006", 'z': "-0700", 'Z': "MST", 'n': " ", 't': "\t",
+	'%': "%", 'c': "Mon Jan _2 15:04:05 2006", 'D': "01/02/06", 'F': "2006-01-02",
+	'R': "15:04", 'T': "15:04:05", 'x': "01/02/06", 'X': "15:04:05"}
+
+// strptimeLayout converts a strftime format to a layout of the time package.
+// %f matches the fractional seconds following a '.' or ','.
+func strptimeLayout(cfmt string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(cfmt); i++ {
+		c := cfmt[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i == len(cfmt)-1 {
+			return "", fmt.Errorf("layout ends with '%%'")
+		}
+		i++
+		layout, ok := strptimeFlagToGo[cfmt[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported conversion '%%%c' in layout", cfmt[i])
+		}
+		b.WriteString(layout)
+	}
+	return b.String(), nil
+}
+
+// parseEpoch parses a decimal number of units since the Unix epoch without
+// converting it to a float, so that fractions down to nanoseconds are exact.
+func parseEpoch(s string, unit time.Duration) (time.Time, error) {
+	digits := strings.TrimPrefix(s, "-")
+	whole, fraction := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		whole, fraction = digits[:i], digits[i+1:]
+	}
+	if !isDigits(whole) || !isDigits(fraction) || len(whole)+len(fraction) == 0 {
+		return time.Time{}, fmt.Errorf("cannot parse %q as a Unix time", s)
+	}
+	if len(whole) == 0 {
+		whole = "0"
+	}
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse %q as a Unix time: out of range", s)
+	}
+	if len(fraction) > 9 {
+		fraction = fraction[:9]
+	}
+	f, _ := strconv.ParseInt((fraction + "000000000")[:9], 10, 64)
+	perSecond := int64(time.Second / unit)
+	sec := n / perSecond
+	nsec := (n%perSecond)*int64(unit) + f*int64(unit)/int64(time.Second)
+	if len(digits) != len(s) {
+		sec, nsec = -sec, -nsec
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// epochUnit guesses the unit of an integer timestamp by its number of digits.
+func epochUnit(s string) time.Duration {
+	n := len(strings.TrimPrefix(s, "-"))
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		n = len(strings.TrimPrefix(s[:i], "-"))
+	}
+	switch {
+	case n >= 18:
+		return time.Nanosecond
+	case n >= 15:
+		return time.Microsecond
+	case n >= 12:
+		return time.Millisecond
+	}
+	return time.Second
+}
+
+// parseSyslog parses a RFC 3164 timestamp, which has no year. The year is the
+// current one unless that puts the timestamp more than a day into the future.
+func parseSyslog(s string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation(time.Stamp, s, loc)
+	if err != nil {
+		return t, err
+	}
+	now := time.Now().In(loc)
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.Sub(now) > 24*time.Hour {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, nil
+}
+
+// parseTime parses a timestamp with a layout of time.parse. Timestamps without
+// a time zone are in loc.
+func parseTime(s, layout string, loc *time.Location) (time.Time, error) {
+	switch layout {
+	case "":
+		if _, err := parseEpoch(s, time.Second); err == nil {
+			return parseEpoch(s, epochUnit(s))
+		}
+		for _, layout := range autoTimeLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t, nil
+			}
+		}
+		if t, err := parseSyslog(s, loc); err == nil {
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("cannot parse %q as a timestamp", s)
+	case "epoch", "%s":
+		return parseEpoch(s, time.Second)
+	case "epoch_ms":
+		return parseEpoch(s, time.Millisecond)
+	case "syslog":
+		return parseSyslog(s, loc)
+	}
+	if named, ok := timeLayouts[layout]; ok {
+		layout = named
+	} else if strings.IndexByte(layout, '%') >= 0 {
+		var err error
+		if layout, err = strptimeLayout(layout); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
+
+/* }}} */
+
+/* time library {{{ */
+
+const (
+	timeClass     = "time.Time"
+	durationClass = "time.Duration"
+)
+
+type timeLib struct {
+	timeMt     *LTable
+	durationMt *LTable
+}
+
+// OpenTime opens the time library. It is not opened by OpenLibs.
+//
+// Timestamps and durations are userdata holding a time.Time and a
+// time.Duration, so they keep nanoseconds exactly. Functions taking a duration
+// also accept a string like "5m30s" or a number of seconds.
+func OpenTime(L *LState) int {
+	lib := &timeLib{timeMt: L.NewTypeMetatable(timeClass), durationMt: L.NewTypeMetatable(durationClass)}
+	L.SetFuncs(lib.timeMt, map[string]LGFunction{
+		"__tostring": lib.timeToString,
+		"__eq":       lib.timeEq,
+		"__lt":       lib.timeLt,
+		"__le":       lib.timeLe,
+		"__add":      lib.add,
+		"__sub":      lib.sub,
+	})
+	lib.timeMt.RawSetString("__index", L.SetFuncs(L.NewTable(), map[string]LGFunction{
+		"unix":       lib.timeUnix,
+		"unix_ms":    lib.timeUnixMs,
+		"nanosecond": lib.timeNanosecond,
+		"format":     lib.timeFormat,
+		"utc":        lib.timeUTC,
+		"in_zone":    lib.timeInZone,
+		"add":        lib.timeAdd,
+		"sub":        lib.timeSub,
+		"before":     lib.timeLt,
+		"after":      lib.timeAfter,
+		"truncate":   lib.truncate,
+		"round":      lib.round,
+		"bucket":     lib.bucket,
+	}))
+	L.SetFuncs(lib.durationMt, map[string]LGFunction{
+		"__tostring": lib.durationToString,
+		"__eq":       lib.durationEq,
+		"__lt":       lib.durationLt,
+		"__le":       lib.durationLe,
+		"__add":      lib.add,
+		"__sub":      lib.sub,
+		"__mul":      lib.mul,
+		"__div":      lib.div,
+		"__unm":      lib.durationUnm,
+	})
+	lib.durationMt.RawSetString("__index", L.SetFuncs(L.NewTable(), map[string]LGFunction{
+		"seconds":      lib.durationSeconds,
+		"milliseconds": lib.durationMilliseconds,
+		"nanoseconds":  lib.durationNanoseconds,
+		"truncate":     lib.durationTruncate,
+		"round":        lib.durationRound,
+		"abs":          lib.durationAbs,
+	}))
+	mod := L.RegisterModule(TimeLibName, map[string]LGFunction{
+		"parse":    lib.parse,
+		"now":      lib.now,
+		"now_ms":   timeNowMs,
+		"unix":     lib.unix,
+		"unix_ms":  lib.unixMs,
+		"duration": lib.duration,
+		"since":    lib.since,
+		"truncate": lib.truncate,
+		"round":    lib.round,
+		"bucket":   lib.bucket,
+	})
+	L.Push(mod)
+	return 1
+}
+
+func (lib *timeLib) pushTime(L *LState, t time.Time) {
+	ud := L.NewUserData()
+	ud.Value = t
+	ud.Metatable = lib.timeMt
+	L.Push(ud)
+}
+
+func (lib *timeLib) pushDuration(L *LState, d time.Duration) {
+	ud := L.NewUserData()
+	ud.Value = d
+	ud.Metatable = lib.durationMt
+	L.Push(ud)
+}
+
+func toTime(lv LValue) (time.Time, bool) {
+	if ud, ok := lv.(*LUserData); ok {
+		t, ok := ud.Value.(time.Time)
+		return t, ok
+	}
+	return time.Time{}, false
+}
+
+func toDuration(lv LValue) (time.Duration, bool) {
+	switch v := lv.(type) {
+	case *LUserData:
+		d, ok := v.Value.(time.Duration)
+		return d, ok
+	case LNumber:
+		return time.Duration(math.Round(float64(v) * float64(time.Second))), true
+	case LString:
+		d, err := time.ParseDuration(string(v))
+		return d, err == nil
+	}
+	return 0, false
+}
+
+func checkTime(L *LState, n int) time.Time {
+	t, ok := toTime(L.Get(n))
+	if !ok {
+		L.ArgError(n, "time expected, got "+L.Get(n).Type().String())
+	}
+	return t
+}
+
+func checkDuration(L *LState, n int) time.Duration {
+	d, ok := toDuration(L.Get(n))
+	if !ok {
+		if s, isString := L.Get(n).(LString); isString {
+			L.ArgError(n, "invalid duration '"+string(s)+"'")
+		}
+		L.ArgError(n, "duration expected, got "+L.Get(n).Type().String())
+	}
+	return d
+}
+
+func checkPositiveDuration(L *LState, n int) time.Duration {
+	d := checkDuration(L, n)
+	if d <= 0 {
+		L.ArgError(n, "duration must be positive")
+	}
+	return d
+}
+
+func optLocation(L *LState, n int, loc *time.Location) *time.Location {
+	zone := L.OptString(n, "")
+	if len(zone) == 0 {
+		return loc
+	}
+	loc, err := loadLocation(zone)
+	if err != nil {
+		L.ArgError(n, "unknown time zone '"+zone+"'")
+	}
+	return loc
+}
+
+// time.parse(s [, layout [, zone]]) returns a time or nil and an error message.
+func (lib *timeLib) parse(L *LState) int {
+	s := L.CheckString(1)
+	layout := L.OptString(2, "")
+	loc := optLocation(L, 3, time.UTC)
+	t, err := parseTime(s, layout, loc)
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	lib.pushTime(L, t)
+	return 1
+}
+
+func (lib *timeLib) now(L *LState) int {
+	lib.pushTime(L, time.Now())
+	return 1
+}
+
+func timeNowMs(L *LState) int {
+	L.Push(LNumber(time.Now().UnixNano() / int64(time.Millisecond)))
+	return 1
+}
+
+// time.unix(sec [, nsec])
+func (lib *timeLib) unix(L *LState) int {
+	lib.pushTime(L, time.Unix(L.CheckInt64(1), L.OptInt64(2, 0)))
+	return 1
+}
+
+func (lib *timeLib) unixMs(L *LState) int {
+	ms := L.CheckInt64(1)
+	lib.pushTime(L, time.Unix(ms/1000, ms%1000*int64(time.Millisecond)))
+	return 1
+}
+
+func (lib *timeLib) duration(L *LState) int {
+	lib.pushDuration(L, checkDuration(L, 1))
+	return 1
+}
+
+func (lib *timeLib) since(L *LState) int {
+	lib.pushDuration(L, time.Since(checkTime(L, 1)))
+	return 1
+}
+
+// windowIndex returns the index of the window of size d since the Unix epoch t is in.
+func windowIndex(t time.Time, d time.Duration) int64 {
+	n := t.UnixNano()
+	index := n / int64(d)
+	if n%int64(d) < 0 {
+		index--
+	}
+	return index
+}
+
+func (lib *timeLib) truncate(L *LState) int {
+	t := checkTime(L, 1)
+	d := checkPositiveDuration(L, 2)
+	lib.pushTime(L, time.Unix(0, windowIndex(t, d)*int64(d)).In(t.Location()))
+	return 1
+}
+
+func (lib *timeLib) round(L *LState) int {
+	t := checkTime(L, 1)
+	d := checkPositiveDuration(L, 2)
+	lib.pushTime(L, time.Unix(0, windowIndex(t.Add(d/2), d)*int64(d)).In(t.Location()))
+	return 1
+}
+
+// bucket returns the index of the window of a time and the start of the window.
+// Windows are aligned to the Unix epoch, so the index can be used as a table key.
+func (lib *timeLib) bucket(L *LState) int {
+	t := checkTime(L, 1)
+	d := checkPositiveDuration(L, 2)
+	index := windowIndex(t, d)
+	L.Push(LNumber(index))
+	lib.pushTime(L, time.Unix(0, index*int64(d)).In(t.Location()))
+	return 2
+}
+
+func (lib *timeLib) timeToString(L *LState) int {
+	L.Push(LString(checkTime(L, 1).Format(time.RFC3339Nano)))
+	return 1
+}
+
+func (lib *timeLib) timeEq(L *LState) int {
+	L.Push(LBool(checkTime(L, 1).Equal(checkTime(L, 2))))
+	return 1
+}
+
+func (lib *timeLib) timeLt(L *LState) int {
+	L.Push(LBool(checkTime(L, 1).Before(checkTime(L, 2))))
+	return 1
+}
+
+func (lib *timeLib) timeLe(L *LState) int {
+	L.Push(LBool(!checkTime(L, 1).After(checkTime(L, 2))))
+	return 1
+}
+
+func (lib *timeLib) timeAfter(L *LState) int {
+	L.Push(LBool(checkTime(L, 1).After(checkTime(L, 2))))
+	return 1
+}
+
+func (lib *timeLib) timeUnix(L *LState) int {
+	L.Push(LNumber(checkTime(L, 1).Unix()))
+	return 1
+}
+
+func (lib *timeLib) timeUnixMs(L *LState) int {
+	t := checkTime(L, 1)
+	L.Push(LNumber(t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond)))
+	return 1
+}
+
+func (lib *timeLib) timeNanosecond(L *LState) int {
+	L.Push(LNumber(checkTime(L, 1).Nanosecond()))
+	return 1
+}
+
+// time:format([layout [, zone]]) formats with a strftime format, a named layout
+// or a layout of the time package.
+func (lib *timeLib) timeFormat(L *LState) int {
+	t := checkTime(L, 1)
+	layout := L.OptString(2, "rfc3339")
+	t = t.In(optLocation(L, 3, t.Location()))
+	if named, ok := timeLayouts[layout]; ok {
+		L.Push(LString(t.Format(named)))
+	} else if strings.IndexByte(layout, '%') >= 0 {
+		L.Push(LString(strftime(t, layout)))
+	} else {
+		L.Push(LString(t.Format(layout)))
+	}
+	return 1
+}
+
+func (lib *timeLib) timeUTC(L *LState) int {
+	lib.pushTime(L, checkTime(L, 1).UTC())
+	return 1
+}
+
+func (lib *timeLib) timeInZone(L *LState) int {
+	t := checkTime(L, 1)
+	L.CheckString(2)
+	lib.pushTime(L, t.In(optLocation(L, 2, t.Location())))
+	return 1
+}
+
+func (lib *timeLib) timeAdd(L *LState) int {
+	lib.pushTime(L, checkTime(L, 1).Add(checkDuration(L, 2)))
+	return 1
+}
+
+func (lib *timeLib) timeSub(L *LState) int {
+	lib.pushDuration(L, checkTime(L, 1).Sub(checkTime(L, 2)))
+	return 1
+}
+
+func (lib *timeLib) durationToString(L *LState) int {
+	L.Push(LString(checkDuration(L, 1).String()))
+	return 1
+}
+
+func (lib *timeLib) durationEq(L *LState) int {
+	L.Push(LBool(checkDuration(L, 1) == checkDuration(L, 2)))
+	return 1
+}
+
+func (lib *timeLib) durationLt(L *LState) int {
+	L.Push(LBool(checkDuration(L, 1) < checkDuration(L, 2)))
+	return 1
+}
+
+func (lib *timeLib) durationLe(L *LState) int {
+	L.Push(LBool(checkDuration(L, 1) <= checkDuration(L, 2)))
+	return 1
+}
+
+func (lib *timeLib) durationUnm(L *LState) int {
+	lib.pushDuration(L, -checkDuration(L, 1))
+	return 1
+}
+
+func (lib *timeLib) durationSeconds(L *LState) int {
+	L.Push(LNumber(checkDuration(L, 1).Seconds()))
+	return 1
+}
+
+func (lib *timeLib) durationMilliseconds(L *LState) int {
+	L.Push(LNumber(checkDuration(L, 1) / time.Millisecond))
+	return 1
+}
+
+func (lib *timeLib) durationNanoseconds(L *LState) int {
+	L.Push(LNumber(checkDuration(L, 1)))
+	return 1
+}
+
+func (lib *timeLib) durationTruncate(L *LState) int {
+	lib.pushDuration(L, checkDuration(L, 1).Truncate(checkDuration(L, 2)))
+	return 1
+}
+
+func (lib *timeLib) durationRound(L *LState) int {
+	lib.pushDuration(L, checkDuration(L, 1).Round(checkDuration(L, 2)))
+	return 1
+}
+
+func (lib *timeLib) durationAbs(L *LState) int {
+	d := checkDuration(L, 1)
+	if d < 0 {
+		d = -d
+	}
+	lib.pushDuration(L, d)
+	return 1
+}
+
+func arithError(L *LState, op string) {
+	L.RaiseError("cannot perform %s operation between %s and %s", op, timeTypeName(L.Get(1)), timeTypeName(L.Get(2)))
+}
+
+func timeTypeName(lv LValue) string {
+	if _, ok := toTime(lv); ok {
+		return "time"
+	}
+	if ud, ok := lv.(*LUserData); ok {
+		if _, ok := ud.Value.(time.Duration); ok {
+			return "duration"
+		}
+	}
+	return lv.Type().String()
+}
+
+func (lib *timeLib) add(L *LState) int {
+	lhs, rhs := L.Get(1), L.Get(2)
+	if t, ok := toTime(lhs); ok {
+		if d, ok := toDuration(rhs); ok {
+			lib.pushTime(L, t.Add(d))
+			return 1
+		}
+	} else if t, ok := toTime(rhs); ok {
+		if d, ok := toDuration(lhs); ok {
+			lib.pushTime(L, t.Add(d))
+			return 1
+		}
+	} else if d1, ok := toDuration(lhs); ok {
+		if d2, ok := toDuration(rhs); ok {
+			lib.pushDuration(L, d1+d2)
+			return 1
+		}
+	}
+	arithError(L, "add")
+	return 0
+}
+
+func (lib *timeLib) sub(L *LState) int {
+	lhs, rhs := L.Get(1), L.Get(2)
+	if t, ok := toTime(lhs); ok {
+		if u, ok := toTime(rhs); ok {
+			lib.pushDuration(L, t.Sub(u))
+			return 1
+		}
+		if d, ok := toDuration(rhs); ok {
+			lib.pushTime(L, t.Add(-d))
+			return 1
+		}
+	} else if d1, ok := toDuration(lhs); ok {
+		if d2, ok := toDuration(rhs); ok {
+			lib.pushDuration(L, d1-d2)
+			return 1
+		}
+	}
+	arithError(L, "sub")
+	return 0
+}
+
+func (lib *timeLib) mul(L *LState) int {
+	lhs, rhs := L.Get(1), L.Get(2)
+	if _, ok := lhs.(LNumber); ok {
+		lhs, rhs = rhs, lhs
+	}
+	if ud, ok := lhs.(*LUserData); ok {
+		d, isDuration := ud.Value.(time.Duration)
+		if n, isNumber := rhs.(LNumber); isDuration && isNumber {
+			lib.pushDuration(L, time.Duration(math.Round(float64(n)*float64(d))))
+			return 1
+		}
+	}
+	arithError(L, "mul")
+	return 0
+}
+
+// div divides a duration by a number or returns the ratio of two durations.
+func (lib *timeLib) div(L *LState) int {
+	if ud, ok := L.Get(1).(*LUserData); ok {
+		if d, ok := ud.Value.(time.Duration); ok {
+			switch rhs := L.Get(2).(type) {
+			case LNumber:
+				lib.pushDuration(L, time.Duration(math.Round(float64(d)/float64(rhs))))
+				return 1
+			case *LUserData:
+				if d2, ok := rhs.Value.(time.Duration); ok {
+					L.Push(LNumber(float64(d) / float64(d2)))
+					return 1
+				}
+			}
+		}
+	}
+	arithError(L, "div")
+	return 0
+}
+
+/* }}} */
@@ -0,0 +1,89 @@
+package lua
+
+import (
+	"testing"
+	"time"
+)
+
+func newTimeState() *LState {
+	L := NewState()
+	L.PreloadModule(TimeLibName, OpenTime)
+	return L
+}
+
+func TestParseTime(t *testing.T) {
+	cases := []struct {
+		value, layout string
+		expected      time.Time
+	}{
+		{"2024-03-05T10:20:30.123456789Z", "", time.Date(2024, 3, 5, 10, 20, 30, 123456789, time.UTC)},
+		{"2024-03-05 10:20:30.5", "", time.Date(2024, 3, 5, 10, 20, 30, 500000000, time.UTC)},
+		{"1709634030", "", time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC)},
+		{"1709634030.000000001", "", time.Date(2024, 3, 5, 10, 20, 30, 1, time.UTC)},
+		{"1709634030123", "", time.Date(2024, 3, 5, 10, 20, 30, 123000000, time.UTC)},
+		{"-1.5", "epoch", time.Date(1969, 12, 31, 23, 59, 58, 500000000, time.UTC)},
+		{"1709634030123.5", "epoch_ms", time.Date(2024, 3, 5, 10, 20, 30, 123500000, time.UTC)},
+		{"05/03/2024 10:20:30,25", "%d/%m/%Y %H:%M:%S,%f", time.Date(2024, 3, 5, 10, 20, 30, 250000000, time.UTC)},
+		{"Tue, 05 Mar 2024 10:20:30 +0000", "rfc1123", time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC)},
+		{"2024.03.05", "2006.01.02", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		parsed, err := parseTime(c.value, c.layout, time.UTC)
+		errorIfNotNil(t, err)
+		errorIfFalse(t, parsed.Equal(c.expected), "%s: expected %v, got %v", c.value, c.expected, parsed)
+	}
+
+	parsed, err := parseTime("Mar  5 10:20:30", "", time.UTC)
+	errorIfNotNil(t, err)
+	errorIfFalse(t, parsed.Sub(time.Now()) < 24*time.Hour && time.Since(parsed) < 366*24*time.Hour, "syslog timestamps should be in the last year")
+
+	_, err = parseTime("yesterday", "", time.UTC)
+	errorIfNil(t, err)
+	_, err = parseTime("2024", "%Y %j", time.UTC)
+	errorIfFalse(t, err != nil && err.Error() == "unsupported conversion '%j' in layout", "%v", err)
+}
+
+func TestTimeLib(t *testing.T) {
+	L := newTimeState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local time = require("time")
+	  local t = assert(time.parse("2024-03-05T10:20:30.123456789+03:00"))
+	  assert(tostring(t) == "2024-03-05T10:20:30.123456789+03:00")
+	  assert(t:unix() == 1709623230 and t:unix_ms() == 1709623230123 and t:nanosecond() == 123456789)
+	  assert(t:format("%Y-%m-%d %H:%M:%S", "UTC") == "2024-03-05 07:20:30")
+	  assert(t:utc():format("2006-01-02T15:04:05.000Z07:00") == "2024-03-05T07:20:30.123Z")
+
+	  local t2 = time.parse("1709623230123456456")
+	  assert(t2 - t == time.duration("-333ns"))
+	  assert(t2 < t and t2:before(t) and t:after(t2) and t ~= t2)
+	  assert(t2 + time.duration(0.000000333) == t)
+	  assert(time.unix_ms(1709623230123) == time.unix(1709623230, 123000000))
+
+	  local window = time.duration("5m30s")
+	  assert(tostring(window) == "5m30s" and window:seconds() == 330 and window:milliseconds() == 330000)
+	  assert(window * 2 == time.duration("11m") and 2 * window == time.duration(660))
+	  assert(window / 2 == time.duration("2m45s") and window / time.duration("30s") == 11)
+	  assert(-window < window and (-window):abs() == window)
+	  assert(window + "30s" == time.duration("6m"))
+
+	  local start = t:truncate("5m")
+	  assert(start:format("%H:%M:%S", "UTC") == "07:20:00")
+	  assert(time.round(t, "1m"):format("%M", "UTC") == "21")
+	  local index, bucket = t:bucket("1h")
+	  assert(index == math.floor(1709623230 / 3600) and bucket == t:truncate("1h"))
+	  assert(time.parse("1969-12-31T23:59:59Z"):bucket("1m") == -1)
+
+	  assert(time.now_ms() % 1 == 0 and math.abs(time.now_ms() - time.now():unix_ms()) < 1000)
+	  assert(time.since(t) > time.duration("1h"))
+
+	  local v, err = time.parse("not a time")
+	  assert(v == nil and err:find("cannot parse"), err)
+	  local ok, err = pcall(time.duration, "5 minutes")
+	  assert(not ok and err:find("invalid duration '5 minutes'"), err)
+	  ok, err = pcall(function() return t + t end)
+	  assert(not ok and err:find("cannot perform add operation between time and time"), err)
+	  ok, err = pcall(time.truncate, t, 0)
+	  assert(not ok and err:find("duration must be positive"), err)
+	`)
+}
@@ -0,0 +1,216 @@
+package lua
+
+// utf8MaxUnicode is the largest code point accepted by the utf8 library.
+const utf8MaxUnicode = 0x10FFFF
+
+// utf8CharPattern matches exactly one UTF-8 byte sequence, assuming that the subject is a valid UTF-8 string.
+const utf8CharPattern = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+
+func OpenUtf8(L *LState) int {
+	mod := L.RegisterModule(Utf8LibName, utf8Funcs).(*LTable)
+	mod.RawSetString("charpattern", LString(utf8CharPattern))
+	mod.RawSetString("codes", L.NewClosure(utf8Codes, L.NewFunction(utf8CodesIter)))
+	L.Push(mod)
+	return 1
+}
+
+var utf8Funcs = map[string]LGFunction{
+	"char":      utf8Char,
+	"codepoint": utf8Codepoint,
+	"len":       utf8Len,
+	"offset":    utf8Offset,
+}
+
+// utf8Decode decodes the sequence at pos like Lua 5.3 does: surrogates are
+// accepted, overlong sequences and code points above utf8MaxUnicode are not.
+// It returns the position after the sequence or -1 if it is invalid.
+func utf8Decode(s string, pos int) (rune, int) {
+	limits := [...]rune{0xFF, 0x7F, 0x7FF, 0xFFFF}
+	c := rune(s[pos])
+	if c < 0x80 {
+		return c, pos + 1
+	}
+	res := rune(0)
+	count := 0
+	for ; c&0x40 != 0; c <<= 1 {
+		count++
+		if pos+count >= len(s) || s[pos+count]&0xC0 != 0x80 {
+			return 0, -1
+		}
+		res = res<<6 | rune(s[pos+count]&0x3F)
+	}
+	res |= (c & 0x7F) << (uint(count) * 5)
+	if count > 3 || res > utf8MaxUnicode || res <= limits[count] {
+		return 0, -1
+	}
+	return res, pos + count + 1
+}
+
+// utf8Encode appends the UTF-8 sequence of a code point, including surrogates.
+func utf8Encode(buf []byte, r rune) []byte {
+	switch {
+	case r < 0x80:
+		return append(buf, byte(r))
+	case r < 0x800:
+		return append(buf, byte(0xC0|r>>6), byte(0x80|r&0x3F))
+	case r < 0x10000:
+		return append(buf, byte(0xE0|r>>12), byte(0x80|(r>>6)&0x3F), byte(0x80|r&0x3F))
+	}
+	return append(buf, byte(0xF0|r>>18), byte(0x80|(r>>12)&0x3F), byte(0x80|(r>>6)&0x3F), byte(0x80|r&0x3F))
+}
+
+func utf8IsCont(s string, pos int) bool {
+	return pos < len(s) && s[pos]&0xC0 == 0x80
+}
+
+// utf8PosRelat converts a relative string position: negative means back from the end.
+func utf8PosRelat(pos, length int) int {
+	switch {
+	case pos >= 0:
+		return pos
+	case -pos > length:
+		return 0
+	}
+	return length + pos + 1
+}
+
+func utf8Char(L *LState) int {
+	top := L.GetTop()
+	buf := make([]byte, 0, top)
+	for i := 1; i <= top; i++ {
+		code := L.CheckInt64(i)
+		if code < 0 || code > utf8MaxUnicode {
+			L.ArgError(i, "value out of range")
+		}
+		buf = utf8Encode(buf, rune(code))
+	}
+	L.Push(LString(buf))
+	return 1
+}
+
+func utf8Codepoint(L *LState) int {
+	s := L.CheckString(1)
+	posi := utf8PosRelat(L.OptInt(2, 1), len(s))
+	pose := utf8PosRelat(L.OptInt(3, posi), len(s))
+	if posi < 1 {
+		L.ArgError(2, "out of range")
+	}
+	if pose > len(s) {
+		L.ArgError(3, "out of range")
+	}
+	n := 0
+	for pos := posi - 1; pos < pose; n++ {
+		var code rune
+		code, pos = utf8Decode(s, pos)
+		if pos < 0 {
+			L.RaiseError("invalid UTF-8 code")
+		}
+		L.Push(LNumber(code))
+	}
+	return n
+}
+
+func utf8Len(L *LState) int {
+	s := L.CheckString(1)
+	posi := utf8PosRelat(L.OptInt(2, 1), len(s))
+	posj := utf8PosRelat(L.OptInt(3, -1), len(s))
+	if posi < 1 || posi-1 > len(s) {
+		L.ArgError(2, "initial position out of string")
+	}
+	if posj-1 >= len(s) {
+		L.ArgError(3, "final position out of string")
+	}
+	n := 0
+	for pos := posi - 1; pos <= posj-1; n++ {
+		next := -1
+		if pos < len(s) {
+			_, next = utf8Decode(s, pos)
+		}
+		if next < 0 {
+			L.Push(LNil)
+			L.Push(LNumber(pos + 1))
+			return 2
+		}
+		pos = next
+	}
+	L.Push(LNumber(n))
+	return 1
+}
+
+func utf8Offset(L *LState) int {
+	s := L.CheckString(1)
+	n := L.CheckInt(2)
+	posi := 1
+	if n < 0 {
+		posi = len(s) + 1
+	}
+	posi = utf8PosRelat(L.OptInt(3, posi), len(s))
+	if posi < 1 || posi-1 > len(s) {
+		L.ArgError(3, "position out of range")
+	}
+	posi--
+	if n == 0 {
+		// find the beginning of the current byte sequence
+		for posi > 0 && utf8IsCont(s, posi) {
+			posi--
+		}
+	} else {
+		if utf8IsCont(s, posi) {
+			L.RaiseError("initial position is a continuation byte")
+		}
+		if n < 0 {
+			for ; n < 0 && posi > 0; n++ {
+				posi--
+				for posi > 0 && utf8IsCont(s, posi) {
+					posi--
+				}
+			}
+		} else {
+			// do not move for the first character
+			for n--; n > 0 && posi < len(s); n-- {
+				posi++
+				for utf8IsCont(s, posi) {
+					posi++
+				}
+			}
+		}
+	}
+	if n == 0 {
+		L.Push(LNumber(posi + 1))
+	} else {
+		L.Push(LNil)
+	}
+	return 1
+}
+
+func utf8Codes(L *LState) int {
+	L.CheckString(1)
+	L.Push(L.Get(UpvalueIndex(1)))
+	L.Push(L.Get(1))
+	L.Push(LNumber(0))
+	return 3
+}
+
+func utf8CodesIter(L *LState) int {
+	s := L.CheckString(1)
+	pos := L.CheckInt(2) - 1
+	if pos < 0 {
+		pos = 0
+	} else if pos < len(s) {
+		// skip the current byte and its continuations
+		pos++
+		for utf8IsCont(s, pos) {
+			pos++
+		}
+	}
+	if pos >= len(s) {
+		return 0
+	}
+	code, next := utf8Decode(s, pos)
+	if next < 0 || utf8IsCont(s, next) {
+		L.RaiseError("invalid UTF-8 code")
+	}
+	L.Push(LNumber(pos + 1))
+	L.Push(LNumber(code))
+	return 2
+}
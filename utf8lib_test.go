@@ -0,0 +1,76 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestUtf8Lib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local s = "привет, мир"
+	  assert(#s == 20 and utf8.len(s) == 11)
+	  assert(utf8.char(72, 0x43F, 0x10FFFF, 0) == "H\208\191\244\143\191\191\0")
+	  assert(utf8.char() == "")
+	  assert(utf8.codepoint(s) == 0x43F and select("#", utf8.codepoint(s, 1, -1)) == 11)
+	  local a, b, c = utf8.codepoint(s, -8, -6)
+	  assert(a == 0x2C and b == 0x20 and c == 0x43C)
+	  assert(utf8.codepoint("\237\160\128") == 0xD800)
+
+	  assert(utf8.offset(s, 3) == 5 and utf8.offset(s, -1) == 19 and utf8.offset(s, 0, 4) == 3)
+	  assert(utf8.offset(s, 12) == 21 and utf8.offset(s, 13) == nil and utf8.offset("abc", -4) == nil)
+	  assert(utf8.len(s, 3) == 10 and utf8.len(s, 21) == 0 and utf8.len("") == 0)
+	  local n, pos = utf8.len("ab\255c")
+	  assert(n == nil and pos == 3)
+	  assert(utf8.len("\192\128") == nil and utf8.len("\244\144\128\128") == nil)
+
+	  local t = {}
+	  for p, c in utf8.codes("aж€😀") do t[#t + 1] = p .. ":" .. c end
+	  assert(table.concat(t, " ") == "1:97 2:1078 4:8364 7:128512")
+	  local count = 0
+	  for c in s:gmatch(utf8.charpattern) do count = count + 1 end
+	  assert(count == 11)
+
+	  assert(not pcall(utf8.char, 0x110000))
+	  assert(not pcall(utf8.char, -1))
+	  local ok, err = pcall(utf8.codepoint, "\255")
+	  assert(not ok and err:find("invalid UTF%-8 code"), err)
+	  ok, err = pcall(utf8.offset, s, 1, 2)
+	  assert(not ok and err:find("continuation byte"), err)
+	  ok, err = pcall(function() for _ in utf8.codes("a\128") do end end)
+	  assert(not ok and err:find("invalid UTF%-8 code"), err)
+	  assert(not pcall(utf8.len, s, 22))
+	  assert(not pcall(utf8.codepoint, s, 0))
+	`)
+}
+
+func TestUnicodePatterns(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(("привет мир"):match("%a+") == nil)
+	  assert(("Ёж"):find(".") == 1 and select(2, ("Ёж"):find(".")) == 1)
+	`)
+
+	L = NewState(Options{UnicodePatterns: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(("user Иван logged in"):match("%u%l+", 6) == "Иван")
+	  assert(("ошибка: 42"):match("^(%a+)") == "ошибка")
+	  assert(("Ёж"):find(".") == 1 and select(2, ("Ёж"):find(".")) == 2)
+	  local chars = {}
+	  for c in ("привет"):gmatch(utf8.charpattern) do chars[#chars + 1] = c end
+	  assert(table.concat(chars, " ") == "п р и в е т")
+	  assert(("äöü"):gsub(".", "x") == "xxx")
+	  assert(("день-ночь"):gsub("%W", " ") == "день ночь")
+	  assert(("ёжик"):match("[а-я]+") == "жик")
+	  assert(("ёжик"):match("[а-яё]+") == "ёжик")
+	  assert(("«цитата»"):match("%b«»") == "«цитата»")
+	  assert(("x1٣"):match("%w+") == "x1٣")
+	  local words = {}
+	  for w in ("Hello, Мир! Γειά"):gmatch("%a+") do words[#words + 1] = w end
+	  assert(table.concat(words, "|") == "Hello|Мир|Γειά")
+	  -- invalid sequences are matched byte by byte
+	  assert(("a\255b"):match("a(.)b") == "\255" and ("\233"):match("é") == nil)
+	`)
+}
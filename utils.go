@@ -100,34 +100,102 @@ func (fs *flagScanner) Next() (byte, bool) {
 }
 
 var cDateFlagToGo = map[byte]string{
-	'a': "mon", 'A': "Monday", 'b': "Jan", 'B': "January", 'c': "02 Jan 06 15:04 MST", 'd': "02",
-	'F': "2006-01-02", 'H': "15", 'I': "03", 'm': "01", 'M': "04", 'p': "PM", 'P': "pm", 'S': "05",
-	'x': "15/04/05", 'X': "15:04:05", 'y': "06", 'Y': "2006", 'z': "-0700", 'Z': "MST"}
+	'a': "Mon", 'A': "Monday", 'b': "Jan", 'B': "January", 'd': "02", 'h': "Jan",
+	'H': "15", 'I': "03", 'm': "01", 'M': "04", 'p': "PM", 'S': "05",
+	'y': "06", 'Y': "2006", 'z': "-0700", 'Z': "MST"}
 
+// cDateFlagExpansions are the conversions defined in terms of other conversions in the C locale.
+var cDateFlagExpansions = map[byte]string{
+	'c': "%a %b %e %H:%M:%S %Y", 'D': "%m/%d/%y", 'F': "%Y-%m-%d", 'r': "%I:%M:%S %p",
+	'R': "%H:%M", 'T': "%H:%M:%S", 'x': "%m/%d/%y", 'X': "%H:%M:%S"}
+
+// strftime formats a time like the C99/POSIX strftime in the C locale. The GNU
+// extensions %s, %k, %l and %P are supported as well. Unknown conversions are
+// copied to the output.
 func strftime(t time.Time, cfmt string) string {
-	sc := newFlagScanner('%', "", "", cfmt)
-	for c, eos := sc.Next(); !eos; c, eos = sc.Next() {
-		if !sc.ChangeFlag {
-			if sc.HasFlag {
-				if v, ok := cDateFlagToGo[c]; ok {
-					sc.AppendString(t.Format(v))
-				} else {
-					switch c {
-					case 'w':
-						sc.AppendString(fmt.Sprint(int(t.Weekday())))
-					default:
-						sc.AppendChar('%')
-						sc.AppendChar(c)
-					}
-				}
-				sc.HasFlag = false
-			} else {
-				sc.AppendChar(c)
-			}
+	buf := make([]byte, 0, len(cfmt)*2)
+	return string(appendStrftime(buf, t, cfmt))
+}
+
+func appendStrftime(buf []byte, t time.Time, cfmt string) []byte {
+	for i := 0; i < len(cfmt); i++ {
+		c := cfmt[i]
+		if c != '%' || i == len(cfmt)-1 {
+			buf = append(buf, c)
+			continue
+		}
+		i++
+		c = cfmt[i]
+		// the E and O modifiers select alternative representations of the locale
+		if (c == 'E' || c == 'O') && i < len(cfmt)-1 {
+			i++
+			c = cfmt[i]
+		}
+		if v, ok := cDateFlagToGo[c]; ok {
+			buf = t.AppendFormat(buf, v)
+			continue
+		}
+		if v, ok := cDateFlagExpansions[c]; ok {
+			buf = appendStrftime(buf, t, v)
+			continue
+		}
+		switch c {
+		case '%':
+			buf = append(buf, '%')
+		case 'n':
+			buf = append(buf, '\n')
+		case 't':
+			buf = append(buf, '\t')
+		case 'C':
+			buf = appendPadded(buf, t.Year()/100, 2, '0')
+		case 'e':
+			buf = appendPadded(buf, t.Day(), 2, ' ')
+		case 'g':
+			year, _ := t.ISOWeek()
+			buf = appendPadded(buf, year%100, 2, '0')
+		case 'G':
+			year, _ := t.ISOWeek()
+			buf = strconv.AppendInt(buf, int64(year), 10)
+		case 'j':
+			buf = appendPadded(buf, t.YearDay(), 3, '0')
+		case 'k':
+			buf = appendPadded(buf, t.Hour(), 2, ' ')
+		case 'l':
+			buf = appendPadded(buf, (t.Hour()+11)%12+1, 2, ' ')
+		case 'P':
+			buf = append(buf, strings.ToLower(t.Format("PM"))...)
+		case 's':
+			buf = strconv.AppendInt(buf, t.Unix(), 10)
+		case 'u':
+			buf = strconv.AppendInt(buf, int64((int(t.Weekday())+6)%7+1), 10)
+		case 'U':
+			buf = appendPadded(buf, (t.YearDay()+6-int(t.Weekday()))/7, 2, '0')
+		case 'V':
+			_, week := t.ISOWeek()
+			buf = appendPadded(buf, week, 2, '0')
+		case 'w':
+			buf = strconv.AppendInt(buf, int64(t.Weekday()), 10)
+		case 'W':
+			buf = appendPadded(buf, (t.YearDay()+6-(int(t.Weekday())+6)%7)/7, 2, '0')
+		default:
+			buf = append(buf, '%', c)
 		}
 	}
+	return buf
+}
 
-	return sc.String()
+func appendPadded(buf []byte, v, width int, pad byte) []byte {
+	if v < 0 {
+		buf = append(buf, '-')
+		v = -v
+		width--
+	}
+	for n, w := v, 1; w < width; w++ {
+		if n /= 10; n == 0 {
+			buf = append(buf, pad)
+		}
+	}
+	return strconv.AppendInt(buf, int64(v), 10)
 }
 
 func isInteger(v LNumber) bool {
@@ -3,7 +3,7 @@ package lua
 import (
 	"context"
 	"fmt"
-	"os"
+	"reflect"
 )
 
 type LValueType int
@@ -166,6 +166,7 @@ type LTable struct {
 	strdict map[string]LValue
 	keys    []LValue
 	k2i     map[LValue]int
+	journal *tableJournal
 }
 
 func (tb *LTable) String() string                     { return fmt.Sprintf("table: %p", tb) }
@@ -196,8 +197,16 @@ type Global struct {
 	Global        *LTable
 
 	builtinMts map[int]LValue
-	tempFiles  []*os.File
+	tempFiles  []File
 	gccount    int32
+	hooks      []vmHook
+	profiler   *profiler
+	coverage   *coverageHook
+	reflectMts map[reflect.Type]*LTable
+	regexps    *regexpCache
+	random     randomSource
+
+	loaderPriorities map[*LFunction]int
 }
 
 type LState struct {
@@ -64,6 +64,46 @@ func mainLoopWithContext(L *LState, baseframe *callFrame) {
 	}
 }
 
+func mainLoopWithHooks(L *LState, baseframe *callFrame) {
+	var inst uint32
+	var cf *callFrame
+	var pc int
+
+	if L.stack.IsEmpty() {
+		return
+	}
+
+	L.currentFrame = L.stack.Last()
+	if L.currentFrame.Fn.IsG {
+		callGFunction(L, false)
+		return
+	}
+
+	for {
+		cf = L.currentFrame
+		pc = cf.Pc
+		inst = cf.Fn.Proto.Code[pc]
+		cf.Pc++
+		if L.ctx != nil {
+			select {
+			case <-L.ctx.Done():
+				L.RaiseError(L.ctx.Err().Error())
+				return
+			default:
+			}
+		}
+		for _, hook := range L.G.hooks {
+			hook.beforeInst(L, cf, pc, inst)
+		}
+		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+			return
+		}
+		for _, hook := range L.G.hooks {
+			hook.afterInst(L, cf, pc, inst)
+		}
+	}
+}
+
 // regv is the first target register to copy the return values to.
 // It can be reg.top, indicating that the copied values are going into new registers, or it can be below reg.top
 // Indicating that the values should be within the existing registers.
@@ -291,6 +331,7 @@ func threadRun(L *LState) {
 			}
 		}
 	}()
+	L.updateMainLoop()
 	L.mainLoop(L, nil)
 }
 